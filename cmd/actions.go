@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// revisionAnnotation is set by the deployment controller on deployments and their ReplicaSets
+	revisionAnnotation = "deployment.kubernetes.io/revision"
+	// restartedAtAnnotation is the pod template annotation kubectl uses for rollout restarts
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+)
+
+// writeOptions are the dry-run and optimistic concurrency settings of a change
+type writeOptions struct {
+	// resourceVersion makes the change fail with a conflict if the deployment has changed since
+	resourceVersion string
+	dryRun          bool
+}
+
+func (o writeOptions) dryRunValue() []string {
+	if o.dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// patch encodes a patch of the deployment spec. A resourceVersion in a
+// patch makes the API server reject it when the object has moved on.
+func (o writeOptions) patch(spec map[string]interface{}) ([]byte, error) {
+	patch := map[string]interface{}{"spec": spec}
+	if o.resourceVersion != "" {
+		patch["metadata"] = map[string]interface{}{"resourceVersion": o.resourceVersion}
+	}
+	return json.Marshal(patch)
+}
+
+// patchDeployment applies a patch of the deployment spec with the write options
+func patchDeployment(ctx context.Context, client kubernetes.Interface, namespace, name string, patchType types.PatchType, spec map[string]interface{}, opts writeOptions) (*appsv1.Deployment, error) {
+	patch, err := opts.patch(spec)
+	if err != nil {
+		return nil, err
+	}
+	return client.AppsV1().Deployments(namespace).Patch(ctx, name, patchType, patch, metav1.PatchOptions{DryRun: opts.dryRunValue()})
+}
+
+// scaleDeployment sets the desired replica count of a deployment
+func scaleDeployment(ctx context.Context, client kubernetes.Interface, namespace, name string, replicas int32, opts writeOptions) (*appsv1.Deployment, error) {
+	deployment, err := patchDeployment(ctx, client, namespace, name, types.MergePatchType,
+		map[string]interface{}{"replicas": replicas}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scale deployment %s/%s: %w", namespace, name, err)
+	}
+	return deployment, nil
+}
+
+// restartDeployment triggers a rolling restart the same way kubectl rollout restart does
+func restartDeployment(ctx context.Context, client kubernetes.Interface, namespace, name string, opts writeOptions) (*appsv1.Deployment, error) {
+	deployment, err := patchDeployment(ctx, client, namespace, name, types.StrategicMergePatchType, map[string]interface{}{
+		"template": map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{restartedAtAnnotation: time.Now().Format(time.RFC3339)},
+			},
+		},
+	}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restart deployment %s/%s: %w", namespace, name, err)
+	}
+	return deployment, nil
+}
+
+// setDeploymentImage changes the image of one container of the pod template.
+// container may be empty for deployments with a single container.
+func setDeploymentImage(ctx context.Context, client kubernetes.Interface, namespace, name, container, image string, opts writeOptions) (*appsv1.Deployment, error) {
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment %s/%s: %w", namespace, name, err)
+	}
+
+	// A strategic merge patch would add an unknown container, so look it up first
+	containers := deployment.Spec.Template.Spec.Containers
+	path := field.NewPath("spec", "template", "spec", "containers")
+	switch {
+	case container == "" && len(containers) == 1:
+		container = containers[0].Name
+	case container == "":
+		return nil, invalidDeployment(name, field.Required(path.Child("name"), "the deployment has several containers, choose one"))
+	default:
+		found := false
+		for _, c := range containers {
+			found = found || c.Name == container
+		}
+		if !found {
+			return nil, invalidDeployment(name, field.NotFound(path.Child("name"), container))
+		}
+	}
+
+	deployment, err = patchDeployment(ctx, client, namespace, name, types.StrategicMergePatchType, map[string]interface{}{
+		"template": map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers": []map[string]string{{"name": container, "image": image}},
+			},
+		},
+	}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set image of deployment %s/%s: %w", namespace, name, err)
+	}
+	return deployment, nil
+}
+
+// createDeployment creates a deployment
+func createDeployment(ctx context.Context, client kubernetes.Interface, deployment *appsv1.Deployment, opts writeOptions) (*appsv1.Deployment, error) {
+	created, err := client.AppsV1().Deployments(deployment.Namespace).Create(ctx, deployment, metav1.CreateOptions{DryRun: opts.dryRunValue()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deployment %s/%s: %w", deployment.Namespace, deployment.Name, err)
+	}
+	return created, nil
+}
+
+// deleteDeployment deletes a deployment and, in the background, its ReplicaSets and pods
+func deleteDeployment(ctx context.Context, client kubernetes.Interface, namespace, name string, opts writeOptions) error {
+	propagation := metav1.DeletePropagationBackground
+	options := metav1.DeleteOptions{DryRun: opts.dryRunValue(), PropagationPolicy: &propagation}
+	if opts.resourceVersion != "" {
+		options.Preconditions = &metav1.Preconditions{ResourceVersion: &opts.resourceVersion}
+	}
+	if err := client.AppsV1().Deployments(namespace).Delete(ctx, name, options); err != nil {
+		return fmt.Errorf("failed to delete deployment %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// invalidDeployment returns the API server's error type for invalid deployments
+func invalidDeployment(name string, errs ...*field.Error) error {
+	return apierrors.NewInvalid(schema.GroupKind{Group: appsv1.GroupName, Kind: "Deployment"}, name, errs)
+}
+
+// rollbackDeployment restores the pod template of the previous revision and returns that revision
+func rollbackDeployment(ctx context.Context, client kubernetes.Interface, namespace, name string) (int64, error) {
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get deployment %s/%s: %w", namespace, name, err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return 0, fmt.Errorf("invalid selector on deployment %s/%s: %w", namespace, name, err)
+	}
+	list, err := client.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list replicasets for %s/%s: %w", namespace, name, err)
+	}
+
+	replicaSets := make([]*appsv1.ReplicaSet, 0, len(list.Items))
+	for i := range list.Items {
+		replicaSets = append(replicaSets, &list.Items[i])
+	}
+	previous := previousReplicaSet(deployment, replicaSets)
+	if previous == nil {
+		return 0, fmt.Errorf("no previous revision found for deployment %s/%s", namespace, name)
+	}
+
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "replace", "path": "/spec/template", "value": template},
+	})
+	if err != nil {
+		return 0, err
+	}
+	_, err = client.AppsV1().Deployments(namespace).Patch(ctx, name, types.JSONPatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll back deployment %s/%s: %w", namespace, name, err)
+	}
+	return replicaSetRevision(previous), nil
+}
+
+// previousReplicaSet returns the owned ReplicaSet with the highest revision below the current one
+func previousReplicaSet(deployment *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet) *appsv1.ReplicaSet {
+	current, _ := strconv.ParseInt(deployment.Annotations[revisionAnnotation], 10, 64)
+
+	var previous *appsv1.ReplicaSet
+	for _, rs := range ownedReplicaSets(deployment, replicaSets) {
+		revision := replicaSetRevision(rs)
+		if current > 0 && revision >= current {
+			continue
+		}
+		if previous == nil || revision > replicaSetRevision(previous) {
+			previous = rs
+		}
+	}
+	return previous
+}
+
+// replicaSetRevision returns the revision annotation of a ReplicaSet, or 0 if unset
+func replicaSetRevision(rs *appsv1.ReplicaSet) int64 {
+	revision, _ := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+	return revision
+}
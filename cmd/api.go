@@ -5,38 +5,94 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/e1jefe/k8s-controller/pkg/api"
 	"github.com/spf13/cobra"
 	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
+	"k8s.io/klog/v2"
 )
 
 var (
-	apiKubeconfig string
-	apiNamespace  string
-	apiPort       string
-	informer      cache.SharedIndexInformer
+	apiKubeconfig        string
+	apiNamespace         string
+	apiInformerOpts      informerOptions
+	apiPort              string
+	apiMetricsAddr       string
+	apiSnapshotFile      string
+	apiSnapshotInterval  time.Duration
+	apiSnapshot          *cacheSnapshot
+	apiWriteTokenFile    string
+	apiTokenAuthFile     string
+	apiTokenWebhook      bool
+	apiClientCAFile      string
+	apiAuthorizationMode string
+	apiTLSCertFile       string
+	apiTLSKeyFile        string
+	apiTLSMinVersion     string
+	apiTLSCipherSuites   []string
+	apiTLSSelfSigned     bool
+	apiHTTPRedirectPort  string
+	informer             cache.SharedIndexInformer
+	apiClient            kubernetes.Interface
+	apiFactory           informers.SharedInformerFactory
 )
 
-// Deployment represents a simple deployment response
-type Deployment struct {
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-	Replicas  int32  `json:"replicas"`
-	Ready     int32  `json:"ready"`
-}
-
 // apiCmd represents the api command
 var apiCmd = &cobra.Command{
 	Use:   "api",
 	Short: "Start JSON API server for deployments",
-	Long:  "Start a simple JSON API server that lists deployments from informer cache",
+	Long: `Start a simple JSON API server that lists deployments from informer cache.
+
+Query parameters filter through cache indexes:
+  /deployments?image=nginx            # Deployments running an image (with or without tag)
+  /deployments?label=app=web          # Deployments with a label (repeatable)
+  /deployments?team=payments          # Deployments whose team or owner annotation matches
+  /deployments?readiness=partial      # ready, partial, unavailable or scaled-down
+
+Further filters, sorting and paging:
+  /deployments?labelSelector=tier in (web,api)
+  /deployments?namespace=production
+  /deployments?ready=false            # Deployments with fewer ready than desired replicas
+  /deployments?sort=-age              # name, age or ready; prefix with - to reverse
+  /deployments?limit=50               # X-Continue holds the token of the next page
+  /deployments?limit=50&continue=...
+
+Every response carries the number of matching deployments in X-Total-Count.
+
+Callers authenticate with --token-auth-file tokens, Kubernetes tokens checked
+by TokenReview (--authentication-token-webhook) or client certificates
+(--client-ca-file). Any of them makes reads require authentication too.
+--authorization-mode=Webhook checks every request with SubjectAccessReview
+and cannot be combined with the shared --write-token-file.
+
+With --write-token-file or an authenticator, callers can change deployments.
+All writes accept ?dryRun=true and If-Match: <resourceVersion>:
+  POST   /deployments                          # create from a JSON or YAML manifest
+  PATCH  /deployments/{namespace}/{name}/scale   {"replicas": 3}
+  POST   /deployments/{namespace}/{name}/restart
+  PUT    /deployments/{namespace}/{name}/image   {"container": "web", "image": "nginx:1.26"}
+  DELETE /deployments/{namespace}/{name}
+
+/deployments/watch streams changes as Server-Sent Events, or over a WebSocket
+when the client upgrades. It takes the list filters and resumes from the
+Last-Event-ID header or the lastEventId parameter.
+
+--tls-cert-file and --tls-key-file serve HTTPS and pick up renewed certificates
+without a restart. --tls-self-signed generates a certificate for development.
+
+/readyz fails until the cache has synced and while the deployment watch fails,
+/livez answers while the server runs, /healthz reports the API server
+connection and /debug/cache the state of the cache.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := runAPIServer(); err != nil {
 			fmt.Printf("Error: %v\n", err)
@@ -49,22 +105,150 @@ func init() {
 	apiCmd.Flags().StringVar(&apiKubeconfig, "kubeconfig", "", "path to kubeconfig file")
 	apiCmd.Flags().StringVar(&apiNamespace, "namespace", "default", "namespace to watch")
 	apiCmd.Flags().StringVar(&apiPort, "port", "8080", "port to run the API server on")
+	apiCmd.Flags().StringVar(&apiMetricsAddr, "metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9090 (disabled when empty)")
+	apiCmd.Flags().StringVar(&apiSnapshotFile, "snapshot-file", "", "periodically save the cache to this file and serve it at startup until the cache syncs")
+	apiCmd.Flags().DurationVar(&apiSnapshotInterval, "snapshot-interval", time.Minute, "how often to save the cache snapshot")
+	apiCmd.Flags().IntVar(&apiWatchBuffer, "watch-buffer", 1000, "number of recent events a reconnecting watch client can resume from")
+	apiCmd.Flags().StringVar(&apiWriteTokenFile, "write-token-file", "", "file with the bearer token that enables the write endpoints")
+	apiCmd.Flags().StringVar(&apiTokenAuthFile, "token-auth-file", "", "CSV file of static bearer tokens: token,user,uid,\"group1,group2\"")
+	apiCmd.Flags().BoolVar(&apiTokenWebhook, "authentication-token-webhook", false, "validate bearer tokens with the Kubernetes TokenReview API")
+	apiCmd.Flags().StringVar(&apiClientCAFile, "client-ca-file", "", "authenticate callers by TLS client certificates signed by this CA (requires --tls-cert-file)")
+	apiCmd.Flags().StringVar(&apiAuthorizationMode, "authorization-mode", authorizationModeGroup, "Group lets authenticated callers read and members of "+writersGroup+" write, Webhook asks SubjectAccessReview")
+	apiCmd.Flags().StringVar(&apiTLSCertFile, "tls-cert-file", "", "serve HTTPS with this certificate, reloaded when the file changes")
+	apiCmd.Flags().StringVar(&apiTLSKeyFile, "tls-key-file", "", "private key of --tls-cert-file")
+	apiCmd.Flags().StringVar(&apiTLSMinVersion, "tls-min-version", "VersionTLS12", "minimum TLS version: VersionTLS12 or VersionTLS13")
+	apiCmd.Flags().StringSliceVar(&apiTLSCipherSuites, "tls-cipher-suites", nil, "comma-separated TLS 1.2 cipher suites, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (Go defaults when empty)")
+	apiCmd.Flags().BoolVar(&apiTLSSelfSigned, "tls-self-signed", false, "serve HTTPS with a generated self-signed certificate, for development")
+	apiCmd.Flags().StringVar(&apiHTTPRedirectPort, "http-redirect-port", "", "also listen for plain HTTP on this port and redirect it to HTTPS")
+	apiInformerOpts.addFlags(apiCmd.Flags())
 }
 
 func runAPIServer() error {
+	if apiSnapshotFile != "" && apiSnapshotInterval <= 0 {
+		return fmt.Errorf("--snapshot-interval must be positive")
+	}
+	if err := validateTLSFlags(); err != nil {
+		return err
+	}
+
 	// Setup informer
-	if err := setupInformer(); err != nil {
+	if err := createInformer(); err != nil {
+		return err
+	}
+	if err := configureAPIAuth(); err != nil {
 		return err
 	}
 
-	// Setup HTTP handler
-	http.HandleFunc("/deployments", listDeploymentsHandler)
+	if apiSnapshotFile == "" {
+		if err := waitForInformerSync(); err != nil {
+			return err
+		}
+		return serveAPI()
+	}
+
+	namespace := apiInformerOpts.watchedNamespaces()[0]
+	snapshot, err := loadSnapshot(apiSnapshotFile, &apiInformerOpts, namespace)
+	switch {
+	case err == nil:
+		klog.Infof("Loaded %d deployments from snapshot %s taken %s ago", len(snapshot.indexer.ListKeys()),
+			apiSnapshotFile, snapshot.age().Round(time.Second))
+		apiSnapshot = snapshot
+		go func() {
+			if err := waitForInformerSync(); err != nil {
+				klog.Errorf("%v, still serving the snapshot", err)
+			}
+		}()
+	case os.IsNotExist(err):
+		klog.Infof("No snapshot at %s yet, waiting for the cache to sync", apiSnapshotFile)
+		if err := waitForInformerSync(); err != nil {
+			return err
+		}
+	default:
+		klog.Warningf("Ignoring snapshot: %v", err)
+		if err := waitForInformerSync(); err != nil {
+			return err
+		}
+	}
 
-	fmt.Printf("API server running on http://localhost:%s/deployments\n", apiPort)
-	return http.ListenAndServe(":"+apiPort, nil)
+	go wait.Until(func() {
+		if !informer.HasSynced() {
+			return
+		}
+		if err := writeSnapshot(apiSnapshotFile, informer.GetStore(), &apiInformerOpts, namespace); err != nil {
+			klog.Errorf("Failed to save snapshot: %v", err)
+		}
+	}, apiSnapshotInterval, wait.NeverStop)
+
+	return serveAPI()
+}
+
+// apiPaths maps the fixed paths of the api to their handlers. The paths
+// below /deployments/{namespace}/{name} are routed by deploymentRoutes.
+var apiPaths = map[string]http.HandlerFunc{
+	"/deployments":  deploymentsHandler,
+	watchRoute:      requireReader("watch", watchDeploymentsHandler),
+	"/healthz":      healthHandler,
+	"/readyz":       readyHandler,
+	"/livez":        liveHandler,
+	"/debug/cache":  requireReader("list", debugCacheHandler),
+	"/openapi.json": openAPIHandler,
+}
+
+// registerAPIRoutes adds the api handlers to mux
+func registerAPIRoutes(mux *http.ServeMux) {
+	for path, handler := range apiPaths {
+		mux.HandleFunc(path, handler)
+	}
+	mux.HandleFunc("/deployments/", deploymentHandler)
 }
 
+// openAPIHandler serves the OpenAPI document of the api
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(api.OpenAPISpec)
+}
+
+// serveAPI serves the deployments API from the informer cache
+func serveAPI() error {
+	registerAPIRoutes(http.DefaultServeMux)
+
+	handler := instrumentAPI(compressAPI(http.DefaultServeMux))
+	if !apiTLSEnabled() {
+		fmt.Printf("API server running on http://localhost:%s/deployments\n", apiPort)
+		return http.ListenAndServe(":"+apiPort, handler)
+	}
+
+	tlsConfig, err := apiTLSConfig(wait.NeverStop)
+	if err != nil {
+		return err
+	}
+	if apiHTTPRedirectPort != "" {
+		serveHTTPRedirect(apiHTTPRedirectPort)
+	}
+	server := &http.Server{Addr: ":" + apiPort, Handler: handler, TLSConfig: tlsConfig}
+	fmt.Printf("API server running on https://localhost:%s/deployments\n", apiPort)
+	// The certificate comes from the TLS config
+	return server.ListenAndServeTLS("", "")
+}
+
+// setupInformer creates the deployment informer and waits until its cache has synced
 func setupInformer() error {
+	if err := createInformer(); err != nil {
+		return err
+	}
+	return waitForInformerSync()
+}
+
+// createInformer creates the client and the deployment informer of the api
+func createInformer() error {
+	// The api serves a single cache, so it watches one namespace or all of them
+	apiInformerOpts.namespaces = []string{apiNamespace}
+	// Objects are cached without managedFields and with trimmed pod specs
+	apiInformerOpts.transform = stripDeployment
+	if err := apiInformerOpts.validate(); err != nil {
+		return err
+	}
+
 	// Create client
 	if apiKubeconfig == "" {
 		if home := homedir.HomeDir(); home != "" {
@@ -76,41 +260,148 @@ func setupInformer() error {
 	if err != nil {
 		return err
 	}
+	countWatchRestarts(config)
+	trackConnection(config)
 
-	client, err := kubernetes.NewForConfig(config)
+	apiClient, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		return err
 	}
 
 	// Create informer
-	factory := informers.NewSharedInformerFactoryWithOptions(
-		client, 30*time.Second, informers.WithNamespace(apiNamespace))
+	apiFactory = apiInformerOpts.newFactory(apiClient, apiInformerOpts.watchedNamespaces()[0])
 
-	informer = factory.Apps().V1().Deployments().Informer()
+	informer = apiFactory.Apps().V1().Deployments().Informer()
+	if err := addDeploymentIndexers(informer); err != nil {
+		return err
+	}
+	if err := handleWatchErrors(informer, "Deployment", apiInformerOpts.watchedNamespaces()[0], "deployments"); err != nil {
+		return err
+	}
+	if err := addRelatedInformers(apiFactory, apiInformerOpts.watchedNamespaces()[0]); err != nil {
+		return err
+	}
+	if apiWatchBuffer < 1 {
+		return fmt.Errorf("--watch-buffer must be positive")
+	}
+	apiWatch = newWatchBroadcaster(apiWatchBuffer)
+	if _, err := informer.AddEventHandler(apiWatch.handler()); err != nil {
+		return err
+	}
+	if _, err := informer.AddEventHandler(apiActivity.handler()); err != nil {
+		return err
+	}
 
-	// Start informer
-	factory.Start(context.Background().Done())
-	cache.WaitForCacheSync(context.Background().Done(), informer.HasSynced)
+	if apiMetricsAddr != "" {
+		if _, err := informer.AddEventHandler(metricsEventHandler("Deployment")); err != nil {
+			return err
+		}
+		registerCacheMetrics("Deployment", informer.GetStore())
+		serveMetrics(apiMetricsAddr)
+		registerAPIMetrics()
+	}
 
 	return nil
 }
 
+// waitForInformerSync starts the informer factory and waits for the
+// deployment cache and the related caches to sync
+func waitForInformerSync() error {
+	started := time.Now()
+	apiFactory.Start(context.Background().Done())
+	for informerType, synced := range apiFactory.WaitForCacheSync(context.Background().Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync cache for %v", informerType)
+		}
+	}
+	apiActivity.synced()
+	observeInitialSync(started)
+	if apiSnapshot != nil {
+		klog.Info("Cache synced, serving live data")
+	}
+	return nil
+}
+
+// apiCache returns the indexer to serve from: the loaded snapshot until the
+// live cache has synced, then the live cache. The snapshot is nil for live data.
+func apiCache() (cache.Indexer, *cacheSnapshot) {
+	if apiSnapshot != nil && !informer.HasSynced() {
+		return apiSnapshot.indexer, apiSnapshot
+	}
+	return informer.GetIndexer(), nil
+}
+
+// apiLastModified is the time the deployment cache last changed, or the
+// snapshot was taken while it is served
+func apiLastModified(snapshot *cacheSnapshot) time.Time {
+	if snapshot != nil {
+		return snapshot.time
+	}
+	if _, lastEvent := apiActivity.times(); lastEvent != nil {
+		return *lastEvent
+	}
+	return time.Time{}
+}
+
+// deploymentItem converts a cached deployment to its list entry
+func deploymentItem(d *appsv1.Deployment, stale bool) api.Deployment {
+	var replicas int32
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	return api.Deployment{
+		Name:      d.Name,
+		Namespace: d.Namespace,
+		Replicas:  replicas,
+		Ready:     d.Status.ReadyReplicas,
+		Link:      deploymentPath(d.Namespace, d.Name),
+		Stale:     stale,
+	}
+}
+
 func listDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+
+	query, err := parseDeploymentQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	query.allowed = namespaceAccess(r, "list")
+
+	// Get deployments from cache, or from the snapshot while it syncs. Index
+	// filters are answered by the cache indexes, the rest by the query.
+	indexer, snapshot := apiCache()
+	matches, err := queryDeployments(indexer, query.filters)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	page, total, next := query.apply(matches)
+	w.Header().Set(api.TotalCountHeader, strconv.Itoa(total))
+	if next != "" {
+		w.Header().Set(api.ContinueHeader, next)
+	}
+	if snapshot != nil {
+		w.Header().Set(api.SnapshotAgeHeader, strconv.Itoa(int(snapshot.age().Seconds())))
+	}
+
+	// The page, its position and the staleness make up the response
+	etag := newETag("list?" + r.URL.RawQuery)
+	etag.add(fmt.Sprintf("total=%d next=%s stale=%t", total, next, snapshot != nil))
+	for _, d := range page {
+		etag.addObject(d)
+	}
+	if checkNotModified(w, r, etag.String(), apiLastModified(snapshot)) {
 		return
 	}
 
-	// Get deployments from cache
-	var deployments []Deployment
-	for _, obj := range informer.GetStore().List() {
-		d := obj.(*appsv1.Deployment)
-		deployments = append(deployments, Deployment{
-			Name:      d.Name,
-			Namespace: d.Namespace,
-			Replicas:  *d.Spec.Replicas,
-			Ready:     d.Status.ReadyReplicas,
-		})
+	deployments := []api.Deployment{}
+	for _, d := range page {
+		deployments = append(deployments, deploymentItem(d, snapshot != nil))
 	}
 
 	// Return JSON
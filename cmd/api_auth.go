@@ -0,0 +1,557 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	authenticationclient "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	"k8s.io/klog/v2"
+)
+
+// writersGroup is the group of callers the default authorizer lets change
+// deployments. The shared write token belongs to it.
+const writersGroup = "k8s-controller:writers"
+
+// Authorization modes of the api
+const (
+	authorizationModeGroup   = "Group"
+	authorizationModeWebhook = "Webhook"
+)
+
+// Lifetimes of cached TokenReview and SubjectAccessReview answers
+const (
+	tokenReviewTTL         = 2 * time.Minute
+	accessReviewAllowedTTL = 5 * time.Minute
+	accessReviewDeniedTTL  = 30 * time.Second
+	authCacheSize          = 4096
+)
+
+// apiUser is the authenticated caller of a request
+type apiUser struct {
+	name   string
+	uid    string
+	groups []string
+	extra  map[string][]string
+}
+
+func (u *apiUser) inGroup(group string) bool {
+	for _, g := range u.groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticator identifies the caller of a request. It returns a nil user
+// without an error when the request carries no credentials it understands.
+type authenticator interface {
+	authenticate(r *http.Request) (*apiUser, error)
+}
+
+// resourceAttributes describe what a request does to deployments, or to
+// the group and resource when they are set
+type resourceAttributes struct {
+	verb        string
+	group       string
+	resource    string
+	namespace   string
+	name        string
+	subresource string
+}
+
+// groupResource returns the API group and resource of the request
+func (a resourceAttributes) groupResource() (string, string) {
+	if a.resource == "" {
+		return "apps", "deployments"
+	}
+	return a.group, a.resource
+}
+
+// authorizer decides whether a user may perform a request. It returns the
+// reason of a denial.
+type authorizer interface {
+	authorize(ctx context.Context, user *apiUser, attrs resourceAttributes) (bool, string, error)
+}
+
+var (
+	// apiAuthn authenticates api callers. While it is nil, reads are
+	// anonymous and the write endpoints are disabled.
+	apiAuthn authenticator
+	// apiAuthz decides what authenticated callers may do
+	apiAuthz authorizer = groupAuthorizer{}
+	// apiAnonymousReads lets callers without credentials read. Only the
+	// shared write token keeps it on, every other authenticator turns it off.
+	apiAnonymousReads = true
+)
+
+// unionAuthenticator tries authenticators in order until one knows the caller
+type unionAuthenticator []authenticator
+
+func (u unionAuthenticator) authenticate(r *http.Request) (*apiUser, error) {
+	var errs []error
+	for _, a := range u {
+		user, err := a.authenticate(r)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if user != nil {
+			return user, nil
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return nil, nil
+}
+
+// sharedTokenAuthenticator accepts a single bearer token shared with trusted callers
+type sharedTokenAuthenticator struct {
+	token string
+}
+
+// newSharedTokenAuthenticator reads the token from the first line of path
+func newSharedTokenAuthenticator(path string) (*sharedTokenAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read write token: %w", err)
+	}
+	token := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if token == "" {
+		return nil, fmt.Errorf("write token file %s is empty", path)
+	}
+	return &sharedTokenAuthenticator{token: token}, nil
+}
+
+func (a *sharedTokenAuthenticator) authenticate(r *http.Request) (*apiUser, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		return nil, fmt.Errorf("invalid bearer token")
+	}
+	return &apiUser{name: "write-token", groups: []string{writersGroup}}, nil
+}
+
+// tokenFileAuthenticator accepts the static tokens of a CSV file in the
+// format of the API server's --token-auth-file: token,user,uid,"group1,group2".
+// It also stands in for TokenReview where no cluster is at hand.
+type tokenFileAuthenticator struct {
+	tokens map[string]*apiUser
+}
+
+// newTokenFileAuthenticator reads the tokens of path
+func newTokenFileAuthenticator(path string) (*tokenFileAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	a := &tokenFileAuthenticator{tokens: make(map[string]*apiUser)}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid token file %s: %w", path, err)
+		}
+		if len(record) < 3 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("invalid token file %s, line %d: expected token,user,uid[,groups]", path, line)
+		}
+		user := &apiUser{name: record[1], uid: record[2]}
+		if len(record) > 3 && record[3] != "" {
+			for _, group := range strings.Split(record[3], ",") {
+				user.groups = append(user.groups, strings.TrimSpace(group))
+			}
+		}
+		if _, duplicate := a.tokens[record[0]]; duplicate {
+			return nil, fmt.Errorf("invalid token file %s, line %d: duplicate token", path, line)
+		}
+		a.tokens[record[0]] = user
+	}
+	if len(a.tokens) == 0 {
+		return nil, fmt.Errorf("token file %s has no tokens", path)
+	}
+	return a, nil
+}
+
+func (a *tokenFileAuthenticator) authenticate(r *http.Request) (*apiUser, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, nil
+	}
+	user, found := a.tokens[token]
+	if !found {
+		return nil, fmt.Errorf("invalid bearer token")
+	}
+	return user, nil
+}
+
+// tokenReviewAuthenticator validates Kubernetes bearer tokens, such as
+// service account tokens, through the TokenReview API. Answers are cached
+// by the hash of the token.
+type tokenReviewAuthenticator struct {
+	client authenticationclient.TokenReviewInterface
+	cache  *utilcache.LRUExpireCache
+}
+
+// tokenReviewResult is a cached TokenReview answer
+type tokenReviewResult struct {
+	user *apiUser
+	err  error
+}
+
+func newTokenReviewAuthenticator(client authenticationclient.TokenReviewInterface) *tokenReviewAuthenticator {
+	return &tokenReviewAuthenticator{client: client, cache: utilcache.NewLRUExpireCache(authCacheSize)}
+}
+
+func (a *tokenReviewAuthenticator) authenticate(r *http.Request) (*apiUser, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	if cached, ok := a.cache.Get(key); ok {
+		result := cached.(tokenReviewResult)
+		return result.user, result.err
+	}
+
+	review, err := a.client.Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		// Not cached, the next request asks again
+		return nil, fmt.Errorf("token review failed: %w", err)
+	}
+
+	var result tokenReviewResult
+	if review.Status.Authenticated {
+		info := review.Status.User
+		result.user = &apiUser{name: info.Username, uid: info.UID, groups: info.Groups}
+		if len(info.Extra) > 0 {
+			result.user.extra = make(map[string][]string, len(info.Extra))
+			for key, values := range info.Extra {
+				result.user.extra[key] = values
+			}
+		}
+	} else {
+		result.err = fmt.Errorf("invalid bearer token")
+		if review.Status.Error != "" {
+			result.err = fmt.Errorf("invalid bearer token: %s", review.Status.Error)
+		}
+	}
+	a.cache.Add(key, result, tokenReviewTTL)
+	return result.user, result.err
+}
+
+// clientCertAuthenticator identifies callers by the TLS client certificate
+// the server verified: the common name is the user, the organizations are
+// the groups, as for the API server.
+type clientCertAuthenticator struct{}
+
+func (clientCertAuthenticator) authenticate(r *http.Request) (*apiUser, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if subject.CommonName == "" {
+		return nil, fmt.Errorf("client certificate has no common name")
+	}
+	return &apiUser{name: subject.CommonName, groups: subject.Organization}, nil
+}
+
+// groupAuthorizer lets every authenticated caller read and members of
+// writersGroup change deployments
+type groupAuthorizer struct{}
+
+func (groupAuthorizer) authorize(_ context.Context, user *apiUser, attrs resourceAttributes) (bool, string, error) {
+	if isReadVerb(attrs.verb) || user.inGroup(writersGroup) {
+		return true, "", nil
+	}
+	return false, "only members of " + writersGroup + " may change deployments", nil
+}
+
+// subjectAccessReviewAuthorizer asks the API server whether the caller's
+// RBAC allows a request, as if it was sent to the API server directly.
+// Answers are cached, denials for a shorter time.
+type subjectAccessReviewAuthorizer struct {
+	client authorizationclient.SubjectAccessReviewInterface
+	cache  *utilcache.LRUExpireCache
+}
+
+// accessReviewResult is a cached SubjectAccessReview answer
+type accessReviewResult struct {
+	allowed bool
+	reason  string
+}
+
+func newSubjectAccessReviewAuthorizer(client authorizationclient.SubjectAccessReviewInterface) *subjectAccessReviewAuthorizer {
+	return &subjectAccessReviewAuthorizer{client: client, cache: utilcache.NewLRUExpireCache(authCacheSize)}
+}
+
+func (a *subjectAccessReviewAuthorizer) authorize(ctx context.Context, user *apiUser, attrs resourceAttributes) (bool, string, error) {
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%v", user.name, user.uid, strings.Join(user.groups, ","), attrs)
+	if cached, ok := a.cache.Get(key); ok {
+		result := cached.(accessReviewResult)
+		return result.allowed, result.reason, nil
+	}
+
+	group, resource := attrs.groupResource()
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.name,
+			UID:    user.uid,
+			Groups: user.groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   attrs.namespace,
+				Verb:        attrs.verb,
+				Group:       group,
+				Resource:    resource,
+				Subresource: attrs.subresource,
+				Name:        attrs.name,
+			},
+		},
+	}
+	if len(user.extra) > 0 {
+		review.Spec.Extra = make(map[string]authorizationv1.ExtraValue, len(user.extra))
+		for key, values := range user.extra {
+			review.Spec.Extra[key] = values
+		}
+	}
+	review, err := a.client.Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", fmt.Errorf("subject access review failed: %w", err)
+	}
+
+	result := accessReviewResult{allowed: review.Status.Allowed && !review.Status.Denied, reason: review.Status.Reason}
+	ttl := accessReviewAllowedTTL
+	if !result.allowed {
+		ttl = accessReviewDeniedTTL
+	}
+	a.cache.Add(key, result, ttl)
+	return result.allowed, result.reason, nil
+}
+
+func isReadVerb(verb string) bool {
+	return verb == "get" || verb == "list" || verb == "watch"
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+type userContextKey struct{}
+
+// requestUser returns the authenticated caller of a request, nil for anonymous reads
+func requestUser(r *http.Request) *apiUser {
+	user, _ := r.Context().Value(userContextKey{}).(*apiUser)
+	return user
+}
+
+// authenticateRequest identifies the caller and stores it in the request
+// context. Writes always need a caller, reads only when anonymous reads are off.
+func authenticateRequest(w http.ResponseWriter, r *http.Request, write bool) (*http.Request, bool) {
+	if apiAuthn == nil {
+		if write {
+			writeJSONError(w, http.StatusForbidden, "write endpoints are disabled, start the api with --write-token-file or an authenticator")
+			return r, false
+		}
+		return r, true
+	}
+	user, err := apiAuthn.authenticate(r)
+	if err == nil && user == nil && (write || !apiAnonymousReads) {
+		err = fmt.Errorf("missing credentials")
+	}
+	if err != nil {
+		klog.V(2).Infof("Rejected %s %s from %s: %v", r.Method, r.URL.RequestURI(), r.RemoteAddr, err)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="k8s-controller"`)
+		writeJSONError(w, http.StatusUnauthorized, "%v", err)
+		return r, false
+	}
+	if user == nil {
+		return r, true
+	}
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)), true
+}
+
+// authorizeRequest checks that the caller may perform attrs and answers 403 if
+// not. Anonymous reads that got through authentication are allowed.
+func authorizeRequest(w http.ResponseWriter, r *http.Request, attrs resourceAttributes) bool {
+	user := requestUser(r)
+	if user == nil {
+		return true
+	}
+	allowed, reason, err := apiAuthz.authorize(r.Context(), user, attrs)
+	if err != nil {
+		klog.Errorf("Failed to authorize %s: %v", user.name, err)
+		writeJSONError(w, http.StatusInternalServerError, "authorization failed: %v", err)
+		return false
+	}
+	if !allowed {
+		_, resource := attrs.groupResource()
+		if attrs.subresource != "" {
+			resource += "/" + attrs.subresource
+		}
+		message := fmt.Sprintf("user %q cannot %s %s in namespace %q", user.name, attrs.verb, resource, attrs.namespace)
+		if reason != "" {
+			message += ": " + reason
+		}
+		writeJSONError(w, http.StatusForbidden, "%s", message)
+		return false
+	}
+	return true
+}
+
+// namespaceAccess returns whether the caller of r may perform verb on the
+// deployments of a namespace, for filtering lists and watches. Callers
+// allowed in all namespaces are not asked about each one. It returns nil for
+// anonymous reads, which see every namespace.
+func namespaceAccess(r *http.Request, verb string) func(namespace string) bool {
+	user := requestUser(r)
+	if user == nil {
+		return nil
+	}
+	ctx := r.Context()
+	check := func(namespace string) bool {
+		allowed, _, err := apiAuthz.authorize(ctx, user, resourceAttributes{verb: verb, namespace: namespace})
+		if err != nil {
+			klog.Errorf("Failed to authorize %s to %s deployments in %q: %v", user.name, verb, namespace, err)
+		}
+		return err == nil && allowed
+	}
+	return func(namespace string) bool {
+		return check(metav1.NamespaceAll) || check(namespace)
+	}
+}
+
+// requireReader authenticates a read request. When the path names a
+// deployment, the caller also needs verb on it; lists and watches filter
+// their results with namespaceAccess instead.
+func requireReader(verb string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, ok := authenticateRequest(w, r, false)
+		if !ok {
+			return
+		}
+		if namespace, name, _, named := parseDeploymentPath(r.URL.Path); named {
+			if !authorizeRequest(w, r, resourceAttributes{verb: verb, namespace: namespace, name: name}) {
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// requireRelatedReader authenticates a request for the objects related to a
+// deployment. The caller needs get on the deployment and list on the
+// related resource in its namespace, since the response contains them.
+func requireRelatedReader(group, resource string, next http.HandlerFunc) http.HandlerFunc {
+	return requireReader("get", func(w http.ResponseWriter, r *http.Request) {
+		namespace, _, _, _ := parseDeploymentPath(r.URL.Path)
+		if !authorizeRequest(w, r, resourceAttributes{verb: "list", group: group, resource: resource, namespace: namespace}) {
+			return
+		}
+		next(w, r)
+	})
+}
+
+// requireWriter only lets authenticated callers through to a write handler
+// and logs who called it. When the path names a deployment, the caller also
+// needs verb on it; the create handler authorizes once it knows the namespace.
+func requireWriter(verb, subresource string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, ok := authenticateRequest(w, r, true)
+		if !ok {
+			return
+		}
+		if namespace, name, _, named := parseDeploymentPath(r.URL.Path); named {
+			attrs := resourceAttributes{verb: verb, namespace: namespace, name: name, subresource: subresource}
+			if !authorizeRequest(w, r, attrs) {
+				return
+			}
+		}
+
+		klog.Infof("%s %s by %s", r.Method, r.URL.RequestURI(), requestUser(r).name)
+		next(w, r)
+	}
+}
+
+// configureAPIAuth builds the authenticators and the authorizer of the api
+// from its flags. TokenReview and SubjectAccessReview go through apiClient.
+func configureAPIAuth() error {
+	var authenticators unionAuthenticator
+	if apiClientCAFile != "" {
+		authenticators = append(authenticators, clientCertAuthenticator{})
+	}
+	if apiTokenAuthFile != "" {
+		tokens, err := newTokenFileAuthenticator(apiTokenAuthFile)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, tokens)
+	}
+	if apiTokenWebhook {
+		authenticators = append(authenticators, newTokenReviewAuthenticator(apiClient.AuthenticationV1().TokenReviews()))
+	}
+	// Only the shared write token leaves reads open, as it did before the
+	// other authenticators existed
+	apiAnonymousReads = len(authenticators) == 0
+	if apiWriteTokenFile != "" {
+		shared, err := newSharedTokenAuthenticator(apiWriteTokenFile)
+		if err != nil {
+			return err
+		}
+		authenticators = append(authenticators, shared)
+	}
+	if len(authenticators) > 0 {
+		apiAuthn = authenticators
+	}
+
+	switch apiAuthorizationMode {
+	case authorizationModeGroup:
+		apiAuthz = groupAuthorizer{}
+	case authorizationModeWebhook:
+		if apiAuthn == nil {
+			return errors.New("--authorization-mode=Webhook needs an authenticator")
+		}
+		// The shared token's user is unknown to the cluster's RBAC, so every
+		// review would deny it
+		if apiWriteTokenFile != "" {
+			return errors.New("--write-token-file cannot be used with --authorization-mode=Webhook, use --token-auth-file or --authentication-token-webhook")
+		}
+		apiAuthz = newSubjectAccessReviewAuthorizer(apiClient.AuthorizationV1().SubjectAccessReviews())
+	default:
+		return fmt.Errorf("invalid --authorization-mode %q, expected %s or %s", apiAuthorizationMode,
+			authorizationModeGroup, authorizationModeWebhook)
+	}
+	return nil
+}
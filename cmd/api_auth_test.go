@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// writeTokenFile writes a static token file for the test
+func writeTokenFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// bearerRequest builds a request carrying token
+func bearerRequest(method, target, token string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestTokenFileAuthenticator(t *testing.T) {
+	auth, err := newTokenFileAuthenticator(writeTokenFile(t, `# token,user,uid,groups
+alice-token,alice,1
+bob-token,bob,2,"k8s-controller:writers,ops"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := auth.authenticate(bearerRequest("GET", "/", "bob-token"))
+	if err != nil || user.name != "bob" || user.uid != "2" || !user.inGroup(writersGroup) || !user.inGroup("ops") {
+		t.Errorf("Unexpected user %+v, %v", user, err)
+	}
+	if user, err := auth.authenticate(bearerRequest("GET", "/", "")); user != nil || err != nil {
+		t.Errorf("Expected no opinion without a token, got %+v, %v", user, err)
+	}
+	if _, err := auth.authenticate(bearerRequest("GET", "/", "mallory-token")); err == nil {
+		t.Error("Expected an unknown token to fail")
+	}
+
+	for _, content := range []string{"", "token-only\n", "a,alice,1\na,bob,2\n"} {
+		if _, err := newTokenFileAuthenticator(writeTokenFile(t, content)); err == nil {
+			t.Errorf("Expected %q to be rejected", content)
+		}
+	}
+}
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	reviews := 0
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "sa-token" {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{
+				Username: "system:serviceaccount:default:reader",
+				Groups:   []string{"system:serviceaccounts"},
+			}
+		} else {
+			review.Status.Error = "token expired"
+		}
+		return true, review, nil
+	})
+	auth := newTokenReviewAuthenticator(client.AuthenticationV1().TokenReviews())
+
+	for i := 0; i < 2; i++ {
+		user, err := auth.authenticate(bearerRequest("GET", "/", "sa-token"))
+		if err != nil || user.name != "system:serviceaccount:default:reader" {
+			t.Errorf("Unexpected user %+v, %v", user, err)
+		}
+		if _, err := auth.authenticate(bearerRequest("GET", "/", "old-token")); err == nil {
+			t.Error("Expected a rejected token to fail")
+		}
+	}
+	if reviews != 2 {
+		t.Errorf("Expected answers to be cached, got %d reviews", reviews)
+	}
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if user, err := (clientCertAuthenticator{}).authenticate(req); user != nil || err != nil {
+		t.Errorf("Expected no opinion without TLS, got %+v, %v", user, err)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "alice", Organization: []string{"dev"}}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	user, err := (clientCertAuthenticator{}).authenticate(req)
+	if err != nil || user.name != "alice" || !user.inGroup("dev") {
+		t.Errorf("Unexpected user %+v, %v", user, err)
+	}
+}
+
+// allowAccessReviews answers SubjectAccessReviews of the fake client from
+// rules of user to namespace to verbs. It stands in for the API server's RBAC.
+func allowAccessReviews(client *fake.Clientset, rules map[string]map[string][]string) *int {
+	reviews := 0
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		verb := attrs.Verb
+		if attrs.Subresource != "" {
+			verb += "/" + attrs.Subresource
+		}
+		// Resources other than deployments follow the verb, as in "list:pods"
+		if attrs.Group != "apps" || attrs.Resource != "deployments" {
+			verb += ":" + attrs.Resource
+		}
+		// Rules of the empty namespace apply cluster-wide, like a ClusterRoleBinding
+		verbs := append(rules[review.Spec.User][""], rules[review.Spec.User][attrs.Namespace]...)
+		for _, allowed := range verbs {
+			if allowed == verb {
+				review.Status.Allowed = true
+			}
+		}
+		return true, review, nil
+	})
+	return &reviews
+}
+
+func TestSubjectAccessReviewAuthorizer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	reviews := allowAccessReviews(client, map[string]map[string][]string{
+		"alice": {"default": {"get", "patch/scale"}},
+	})
+	authz := newSubjectAccessReviewAuthorizer(client.AuthorizationV1().SubjectAccessReviews())
+	alice := &apiUser{name: "alice"}
+
+	tests := []struct {
+		attrs   resourceAttributes
+		allowed bool
+	}{
+		{resourceAttributes{verb: "get", namespace: "default", name: "web"}, true},
+		{resourceAttributes{verb: "get", namespace: "default", name: "web"}, true},
+		{resourceAttributes{verb: "get", namespace: "prod", name: "web"}, false},
+		{resourceAttributes{verb: "patch", namespace: "default", name: "web", subresource: "scale"}, true},
+		{resourceAttributes{verb: "patch", namespace: "default", name: "web"}, false},
+	}
+	for _, tt := range tests {
+		allowed, _, err := authz.authorize(context.TODO(), alice, tt.attrs)
+		if err != nil || allowed != tt.allowed {
+			t.Errorf("%+v: expected %t, got %t, %v", tt.attrs, tt.allowed, allowed, err)
+		}
+	}
+	if *reviews != 4 {
+		t.Errorf("Expected the repeated question to be cached, got %d reviews", *reviews)
+	}
+}
+
+func TestAPIAuthorization_Webhook(t *testing.T) {
+	other := newTestDeployment("web", 1, 1)
+	other.Namespace = "prod"
+	client := startTestAPI(t, newTestDeployment("web", 1, 1), newTestDeployment("api", 1, 1), other)
+	allowAccessReviews(client, map[string]map[string][]string{
+		"alice": {"default": {"get", "list", "list:pods"}},
+		"bob":   {"": {"get", "list", "patch/scale"}},
+	})
+
+	tokens, err := newTokenFileAuthenticator(writeTokenFile(t, "alice-token,alice,1\nbob-token,bob,2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousAuthn, previousAuthz, previousAnonymous := apiAuthn, apiAuthz, apiAnonymousReads
+	t.Cleanup(func() { apiAuthn, apiAuthz, apiAnonymousReads = previousAuthn, previousAuthz, previousAnonymous })
+	apiAuthn = unionAuthenticator{tokens}
+	apiAuthz = newSubjectAccessReviewAuthorizer(client.AuthorizationV1().SubjectAccessReviews())
+	apiAnonymousReads = false
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		if req.URL.Path == "/deployments" {
+			deploymentsHandler(rr, req)
+		} else {
+			deploymentHandler(rr, req)
+		}
+		return rr
+	}
+
+	if rr := serve(bearerRequest("GET", "/deployments", "")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected anonymous reads to be rejected, got %d", rr.Code)
+	}
+
+	// Lists only contain the namespaces the caller may list
+	for token, total := range map[string]string{"alice-token": "2", "bob-token": "3"} {
+		rr := serve(bearerRequest("GET", "/deployments", token))
+		if rr.Code != http.StatusOK || rr.Header().Get(api.TotalCountHeader) != total {
+			t.Errorf("%s: expected %s deployments, got %d with %s", token, total, rr.Code, rr.Header().Get(api.TotalCountHeader))
+		}
+	}
+
+	tests := []struct {
+		method, target, token string
+		code                  int
+	}{
+		{"GET", "/deployments/default/web", "alice-token", http.StatusOK},
+		{"GET", "/deployments/prod/web", "alice-token", http.StatusForbidden},
+		{"GET", "/deployments/default/web/pods", "alice-token", http.StatusOK},
+		{"GET", "/deployments/default/web/events", "alice-token", http.StatusForbidden},
+		{"PATCH", "/deployments/default/web/scale", "alice-token", http.StatusForbidden},
+		{"POST", "/deployments/default/web/restart", "bob-token", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if rr := serve(bearerRequest(tt.method, tt.target, tt.token)); rr.Code != tt.code {
+			t.Errorf("%s %s as %s: expected %d, got %d: %s", tt.method, tt.target, tt.token, tt.code, rr.Code, rr.Body)
+		}
+	}
+	rr := serveWrite("PATCH", "/deployments/default/web/scale", `{"replicas": 2}`, "Authorization", "Bearer bob-token")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected bob to scale, got %d: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestAPIAuthorization_Group(t *testing.T) {
+	startTestAPI(t, newTestDeployment("web", 1, 1))
+	tokens, err := newTokenFileAuthenticator(writeTokenFile(t, "alice-token,alice,1\nbob-token,bob,2,k8s-controller:writers\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousAuthn, previousAnonymous := apiAuthn, apiAnonymousReads
+	t.Cleanup(func() { apiAuthn, apiAnonymousReads = previousAuthn, previousAnonymous })
+	apiAuthn = unionAuthenticator{tokens}
+	apiAnonymousReads = false
+
+	if rr := serveWrite("GET", "/deployments/default/web", "", "Authorization", "Bearer alice-token"); rr.Code != http.StatusOK {
+		t.Errorf("Expected alice to read, got %d", rr.Code)
+	}
+	if rr := serveWrite("POST", "/deployments/default/web/restart", "", "Authorization", "Bearer alice-token"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected alice not to write, got %d", rr.Code)
+	}
+	if rr := serveWrite("POST", "/deployments/default/web/restart", "", "Authorization", "Bearer bob-token"); rr.Code != http.StatusOK {
+		t.Errorf("Expected a member of %s to write, got %d: %s", writersGroup, rr.Code, rr.Body)
+	}
+}
+
+func TestConfigureAPIAuth_WebhookRejectsWriteToken(t *testing.T) {
+	defer func(file, mode string, authn authenticator, authz authorizer, anonymous bool) {
+		apiWriteTokenFile, apiAuthorizationMode = file, mode
+		apiAuthn, apiAuthz, apiAnonymousReads = authn, authz, anonymous
+	}(apiWriteTokenFile, apiAuthorizationMode, apiAuthn, apiAuthz, apiAnonymousReads)
+
+	apiWriteTokenFile = writeTokenFile(t, "s3cret")
+	apiAuthorizationMode = authorizationModeWebhook
+	if err := configureAPIAuth(); err == nil || !strings.Contains(err.Error(), "--write-token-file") {
+		t.Errorf("Expected the shared write token to be rejected with Webhook, got %v", err)
+	}
+
+	apiAuthorizationMode = authorizationModeGroup
+	if err := configureAPIAuth(); err != nil {
+		t.Errorf("Expected the shared write token to work with Group, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// conditionalGet sends a GET through the api routes with an optional If-None-Match
+func conditionalGet(target, etag string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rr := httptest.NewRecorder()
+	newTestMux().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestListConditionalGet(t *testing.T) {
+	web := newTestDeployment("web", 2, 2)
+	web.ResourceVersion = "10"
+	worker := newTestDeployment("worker", 1, 1)
+	worker.ResourceVersion = "11"
+	client := startTestAPI(t, web, worker)
+
+	first := conditionalGet("/deployments", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", first.Code, etag)
+	}
+	if first.Header().Get("Last-Modified") == "" {
+		t.Error("expected Last-Modified once the cache has seen events")
+	}
+
+	rr := conditionalGet("/deployments", etag)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("expected an empty 304, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("ETag") != etag || rr.Header().Get("Content-Type") != "" {
+		t.Errorf("unexpected 304 headers %v", rr.Header())
+	}
+	if rr := conditionalGet("/deployments?namespace=default&sort=-name", etag); rr.Code != http.StatusOK {
+		t.Errorf("another query must not match the ETag, got %d", rr.Code)
+	}
+	if rr := conditionalGet("/deployments", `"other", `+etag); rr.Code != http.StatusNotModified {
+		t.Errorf("expected a match within a list of ETags, got %d", rr.Code)
+	}
+
+	worker.ResourceVersion = "12"
+	worker.Status.ReadyReplicas = 0
+	if _, err := client.AppsV1().Deployments("default").Update(context.TODO(), worker, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return conditionalGet("/deployments", etag).Code == http.StatusOK })
+
+	// A change outside the page keeps the ETag of the page
+	page := conditionalGet("/deployments?limit=1", "")
+	worker.ResourceVersion = "13"
+	worker.Status.ReadyReplicas = 1
+	if _, err := client.AppsV1().Deployments("default").Update(context.TODO(), worker, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		obj, _, _ := informer.GetIndexer().GetByKey("default/worker")
+		return obj.(metav1.Object).GetResourceVersion() == "13"
+	})
+	if rr := conditionalGet("/deployments?limit=1", page.Header().Get("ETag")); rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for an unchanged page, got %d", rr.Code)
+	}
+}
+
+func TestDetailConditionalGet(t *testing.T) {
+	deployment := newTestDeployment("web", 1, 1)
+	deployment.ResourceVersion = "5"
+	rs := newTestReplicaSet(deployment, "web-1", "1", "nginx:1.25")
+	pod := newTestPod("web-1-a", rs.Name, rs.UID, corev1.PodPending)
+	pod.Labels = deployment.Spec.Selector.MatchLabels
+	pod.ResourceVersion = "7"
+	client := startTestAPI(t, deployment, rs, pod)
+
+	first := conditionalGet("/deployments/default/web", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", first.Code, etag)
+	}
+	if rr := conditionalGet("/deployments/default/web", etag); rr.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rr.Code)
+	}
+
+	// Pods are part of the detail, so a pod change invalidates it
+	pod.ResourceVersion = "8"
+	pod.Status.Phase = corev1.PodRunning
+	if _, err := client.CoreV1().Pods("default").Update(context.TODO(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return conditionalGet("/deployments/default/web", etag).Code == http.StatusOK })
+	if _, relatedEvent := apiRelatedActivity.times(); relatedEvent == nil {
+		t.Error("expected the related caches to record events")
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := `W/"abc"`
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{"", false},
+		{`W/"abc"`, true},
+		{`"abc"`, true},
+		{`"other", W/"abc"`, true},
+		{"*", true},
+		{`"abcd"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+}
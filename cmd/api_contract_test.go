@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	apiclient "github.com/e1jefe/k8s-controller/pkg/client"
+)
+
+// openAPIDocument is the part of the OpenAPI document the contract tests check
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]json.RawMessage `json:"responses"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref"`
+	Type                 string                    `json:"type"`
+	Format               string                    `json:"format"`
+	Required             []string                  `json:"required"`
+	Properties           map[string]*openAPISchema `json:"properties"`
+	Items                *openAPISchema            `json:"items"`
+	AdditionalProperties json.RawMessage           `json:"additionalProperties"`
+}
+
+// openAPISchemaTypes are the Go types behind the schemas of the document
+var openAPISchemaTypes = map[string]reflect.Type{
+	"Deployment":          reflect.TypeOf(api.Deployment{}),
+	"DeploymentDetail":    reflect.TypeOf(api.DeploymentDetail{}),
+	"ReplicaCounts":       reflect.TypeOf(api.ReplicaCounts{}),
+	"DeploymentStrategy":  reflect.TypeOf(api.DeploymentStrategy{}),
+	"ContainerImage":      reflect.TypeOf(api.ContainerImage{}),
+	"DeploymentCondition": reflect.TypeOf(api.DeploymentCondition{}),
+	"ReplicaSetSummary":   reflect.TypeOf(api.ReplicaSetSummary{}),
+	"PodSummary":          reflect.TypeOf(api.PodSummary{}),
+	"ScaleRequest":        reflect.TypeOf(api.ScaleRequest{}),
+	"ServiceSummary":      reflect.TypeOf(api.ServiceSummary{}),
+	"ServicePort":         reflect.TypeOf(api.ServicePort{}),
+	"EventSummary":        reflect.TypeOf(api.EventSummary{}),
+	"ImageRequest":        reflect.TypeOf(api.ImageRequest{}),
+	"WatchEvent":          reflect.TypeOf(api.WatchEvent{}),
+	"Error":               reflect.TypeOf(api.Error{}),
+	"ProbeStatus":         reflect.TypeOf(probeStatus{}),
+	"CacheStatus":         reflect.TypeOf(cacheStatus{}),
+	"ConnectionStatus":    reflect.TypeOf(connectionStatus{}),
+	"ResourceConnection":  reflect.TypeOf(resourceConnection{}),
+}
+
+func loadOpenAPIDocument(t *testing.T) *openAPIDocument {
+	t.Helper()
+	doc := &openAPIDocument{}
+	if err := json.Unmarshal(api.OpenAPISpec, doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	return doc
+}
+
+// specOperation is one method of a path of the document
+type specOperation struct {
+	path, method string
+	responses    map[string]json.RawMessage
+}
+
+func (doc *openAPIDocument) operations(t *testing.T) []specOperation {
+	t.Helper()
+	var ops []specOperation
+	for path, item := range doc.Paths {
+		for key, raw := range item {
+			if key == "parameters" {
+				continue
+			}
+			var op openAPIOperation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				t.Fatalf("invalid operation %s %s: %v", key, path, err)
+			}
+			ops = append(ops, specOperation{path: path, method: strings.ToUpper(key), responses: op.Responses})
+		}
+	}
+	// Deleting last keeps the deployment around for the other operations
+	sort.Slice(ops, func(i, j int) bool {
+		if (ops[i].method == http.MethodDelete) != (ops[j].method == http.MethodDelete) {
+			return ops[j].method == http.MethodDelete
+		}
+		return ops[i].path+ops[i].method < ops[j].path+ops[j].method
+	})
+	return ops
+}
+
+func newTestMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	registerAPIRoutes(mux)
+	return mux
+}
+
+func TestOpenAPIOperationsAreRouted(t *testing.T) {
+	startTestAPI(t, newTestDeployment("web", 2, 2))
+	enableWrites(t)
+	mux := newTestMux()
+	doc := loadOpenAPIDocument(t)
+
+	for _, op := range doc.operations(t) {
+		target := strings.NewReplacer("{namespace}", "default", "{name}", "web").Replace(op.path)
+		if op.path == watchRoute {
+			// An unsupported parameter answers without opening a stream
+			target += "?limit=1"
+		}
+		req := httptest.NewRequest(op.method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+testWriteToken)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		switch {
+		case rr.Code == http.StatusNotFound || rr.Code == http.StatusMethodNotAllowed:
+			t.Errorf("%s %s is documented but not routed: %d %s", op.method, op.path, rr.Code, rr.Body.String())
+		case op.responses[strconv.Itoa(rr.Code)] == nil:
+			t.Errorf("%s %s answered %d, which is not documented", op.method, op.path, rr.Code)
+		}
+	}
+}
+
+func TestRoutesAreDocumented(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+	for path := range apiPaths {
+		if doc.Paths[path] == nil {
+			t.Errorf("route %s is missing from the OpenAPI document", path)
+		}
+	}
+	for action, methods := range deploymentRoutes {
+		path := "/deployments/{namespace}/{name}"
+		if action != "" {
+			path += "/" + action
+		}
+		for method := range methods {
+			if doc.Paths[path][strings.ToLower(method)] == nil {
+				t.Errorf("route %s %s is missing from the OpenAPI document", method, path)
+			}
+		}
+	}
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+	for name, schema := range doc.Components.Schemas {
+		goType, ok := openAPISchemaTypes[name]
+		if !ok {
+			// Manifests are decoded as apps/v1 Deployments
+			if name != "DeploymentManifest" {
+				t.Errorf("schema %s has no Go type", name)
+			}
+			continue
+		}
+		checkStructSchema(t, name, goType, schema)
+	}
+	for name := range openAPISchemaTypes {
+		if doc.Components.Schemas[name] == nil {
+			t.Errorf("schema %s is missing from the OpenAPI document", name)
+		}
+	}
+}
+
+// checkStructSchema compares the JSON fields of a struct with the properties
+// of its schema. Fields without omitempty are required.
+func checkStructSchema(t *testing.T, name string, goType reflect.Type, schema *openAPISchema) {
+	t.Helper()
+	required := make(map[string]bool)
+	for _, field := range schema.Required {
+		required[field] = true
+	}
+	fields := make(map[string]bool)
+	for i := 0; i < goType.NumField(); i++ {
+		field := goType.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "" || tag == "-" {
+			continue
+		}
+		jsonName, options, _ := strings.Cut(tag, ",")
+		fields[jsonName] = true
+		property := schema.Properties[jsonName]
+		if property == nil {
+			t.Errorf("%s.%s is missing from the schema", name, jsonName)
+			continue
+		}
+		if omitempty := options == "omitempty"; omitempty == required[jsonName] {
+			t.Errorf("%s.%s: required is %v, but omitempty is %v", name, jsonName, required[jsonName], omitempty)
+		}
+		checkPropertySchema(t, name+"."+jsonName, field.Type, property)
+	}
+	for property := range schema.Properties {
+		if !fields[property] {
+			t.Errorf("%s.%s is in the schema but not in %s", name, property, goType)
+		}
+	}
+}
+
+func checkPropertySchema(t *testing.T, name string, goType reflect.Type, schema *openAPISchema) {
+	t.Helper()
+	if goType.Kind() == reflect.Pointer {
+		goType = goType.Elem()
+	}
+	if schema.Ref != "" {
+		ref := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		if openAPISchemaTypes[ref] != goType {
+			t.Errorf("%s refers to %s, but is a %s", name, ref, goType)
+		}
+		return
+	}
+
+	var expected string
+	switch goType.Kind() {
+	case reflect.String:
+		expected = "string"
+	case reflect.Bool:
+		expected = "boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		expected = "integer"
+	case reflect.Slice:
+		expected = "array"
+		if schema.Items == nil {
+			t.Errorf("%s: array without items", name)
+		} else {
+			checkPropertySchema(t, name+"[]", goType.Elem(), schema.Items)
+		}
+	case reflect.Map:
+		expected = "object"
+		var values openAPISchema
+		if err := json.Unmarshal(schema.AdditionalProperties, &values); err != nil {
+			t.Errorf("%s: map without additionalProperties schema", name)
+		} else {
+			checkPropertySchema(t, name+"{}", goType.Elem(), &values)
+		}
+	case reflect.Struct:
+		if goType == reflect.TypeOf(time.Time{}) {
+			expected = "string"
+			if schema.Format != "date-time" {
+				t.Errorf("%s: times need format date-time, got %q", name, schema.Format)
+			}
+			break
+		}
+		t.Errorf("%s: structs must refer to a schema", name)
+		return
+	default:
+		t.Errorf("%s: unexpected Go type %s", name, goType)
+		return
+	}
+	if schema.Type != expected {
+		t.Errorf("%s: schema type %q, expected %q for %s", name, schema.Type, expected, goType)
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	newTestMux().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), api.OpenAPISpec) {
+		t.Error("served document differs from the embedded one")
+	}
+	if route := apiRoute("/openapi.json"); route != "/openapi.json" {
+		t.Errorf("apiRoute(/openapi.json) = %s", route)
+	}
+}
+
+func TestClientAgainstHandlers(t *testing.T) {
+	startTestAPI(t, newTestDeployment("web", 2, 2), newTestDeployment("worker", 1, 0))
+	enableWrites(t)
+	// The client is written by hand, so the test records the operations it
+	// calls and checks at the end that none of the document is left out
+	mux := newTestMux()
+	var mu sync.Mutex
+	called := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		called[r.Method+" "+apiRoute(r.URL.Path)] = true
+		mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	c, err := apiclient.New(server.URL, apiclient.WithToken(testWriteToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+
+	list, err := c.ListDeployments(ctx, apiclient.ListOptions{Sort: "name", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 2 || len(list.Items) != 1 || list.Items[0].Name != "web" || list.Continue == "" {
+		t.Fatalf("unexpected first page %+v", list)
+	}
+	list, err = c.ListDeployments(ctx, apiclient.ListOptions{Sort: "name", Limit: 1, Continue: list.Continue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "worker" || list.Continue != "" {
+		t.Fatalf("unexpected last page %+v", list)
+	}
+
+	detail, err := c.GetDeployment(ctx, "default", "web")
+	if err != nil || detail.Replicas.Desired != 2 {
+		t.Fatalf("unexpected detail %+v, %v", detail, err)
+	}
+	var statusErr *apiclient.StatusError
+	if _, err := c.GetDeployment(ctx, "default", "missing"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a 404 StatusError, got %v", err)
+	}
+	if _, err := c.ListPods(ctx, "default", "web"); err != nil {
+		t.Errorf("unexpected pods error: %v", err)
+	}
+	if _, err := c.ListReplicaSets(ctx, "default", "web"); err != nil {
+		t.Errorf("unexpected replicasets error: %v", err)
+	}
+	if _, err := c.ListServices(ctx, "default", "web"); err != nil {
+		t.Errorf("unexpected services error: %v", err)
+	}
+	if _, err := c.ListEvents(ctx, "default", "web"); err != nil {
+		t.Errorf("unexpected events error: %v", err)
+	}
+
+	if detail, err = c.Scale(ctx, "default", "web", 3, apiclient.WriteOptions{}); err != nil || detail.Replicas.Desired != 3 {
+		t.Fatalf("unexpected scale result %+v, %v", detail, err)
+	}
+	if _, err = c.Restart(ctx, "default", "web", apiclient.WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if detail, err = c.SetImage(ctx, "default", "web", "", "nginx:1.27", apiclient.WriteOptions{}); err != nil || detail.Images[0].Image != "nginx:1.27" {
+		t.Fatalf("unexpected image result %+v, %v", detail, err)
+	}
+
+	created, err := c.CreateDeployment(ctx, newTestDeployment("api", 1, 0), apiclient.WriteOptions{})
+	if err != nil || created.Namespace != "default" || created.Name != "api" {
+		t.Fatalf("unexpected create result %+v, %v", created, err)
+	}
+	if err := c.Delete(ctx, "default", "worker", apiclient.WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher, err := c.Watch(ctx, apiclient.WatchOptions{Filter: apiclient.Filter{Namespace: "default"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	for {
+		event, err := watcher.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Type == api.EventSynced {
+			break
+		}
+		if event.Deployment == nil || event.Deployment.Namespace != "default" {
+			t.Fatalf("unexpected initial event %+v", event)
+		}
+	}
+	if watcher.LastEventID() == "" {
+		t.Error("expected the watcher to track event IDs")
+	}
+
+	// Probes and debug endpoints are for operators, not for the client
+	mu.Lock()
+	defer mu.Unlock()
+	for _, op := range loadOpenAPIDocument(t).operations(t) {
+		if strings.HasPrefix(op.path, "/deployments") && !called[op.method+" "+op.path] {
+			t.Errorf("%s %s is documented but not called by the client", op.method, op.path)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	// apiReplicaSets and apiPods are the related caches behind the detail endpoint
+	apiReplicaSets appslisters.ReplicaSetLister
+	apiPods        corelisters.PodLister
+	// apiServices and apiEvents are the caches behind the related endpoints
+	apiServices corelisters.ServiceLister
+	apiEvents   corelisters.EventLister
+	// apiRelatedActivity records when the related caches last changed
+	apiRelatedActivity = newCacheActivity()
+	// apiRelatedSynced reports whether each related cache has synced
+	apiRelatedSynced []relatedSynced
+)
+
+// relatedSynced is the sync state of the related cache of a kind
+type relatedSynced struct {
+	kind   string
+	synced cache.InformerSynced
+}
+
+// writeJSONError responds with status and a JSON error message
+func writeJSONError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(api.Error{Error: fmt.Sprintf(format, args...)})
+}
+
+// deploymentPath returns the detail URL of a deployment
+func deploymentPath(namespace, name string) string {
+	return "/deployments/" + namespace + "/" + name
+}
+
+// addRelatedInformers registers the ReplicaSet, pod, service and event
+// informers of the detail and related endpoints on the api factory. It must
+// run before the factory starts.
+func addRelatedInformers(factory informers.SharedInformerFactory, namespace string) error {
+	replicaSets := factory.InformerFor(&appsv1.ReplicaSet{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return appsinformers.NewFilteredReplicaSetInformer(client, namespace, resync, relatedIndexers(), nil)
+	})
+	pods := factory.InformerFor(&corev1.Pod{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredPodInformer(client, namespace, resync, relatedIndexers(), nil)
+	})
+	services := factory.InformerFor(&corev1.Service{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredServiceInformer(client, namespace, resync, relatedIndexers(), nil)
+	})
+	events := factory.InformerFor(&corev1.Event{}, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return coreinformers.NewFilteredEventInformer(client, namespace, resync, relatedIndexers(), nil)
+	})
+	related := []struct {
+		informer       cache.SharedIndexInformer
+		kind, resource string
+	}{
+		{replicaSets, "ReplicaSet", "replicasets"},
+		{pods, "Pod", "pods"},
+		{services, "Service", "services"},
+		{events, "Event", "events"},
+	}
+	apiRelatedSynced = nil
+	for _, r := range related {
+		if err := handleWatchErrors(r.informer, r.kind, namespace, r.resource); err != nil {
+			return err
+		}
+		if _, err := r.informer.AddEventHandler(apiRelatedActivity.handler()); err != nil {
+			return err
+		}
+		apiRelatedSynced = append(apiRelatedSynced, relatedSynced{kind: r.kind, synced: r.informer.HasSynced})
+	}
+	apiReplicaSets = appslisters.NewReplicaSetLister(replicaSets.GetIndexer())
+	apiPods = corelisters.NewPodLister(pods.GetIndexer())
+	apiServices = corelisters.NewServiceLister(services.GetIndexer())
+	apiEvents = corelisters.NewEventLister(events.GetIndexer())
+	return nil
+}
+
+// relatedIndexers are the indexers of the related informers. They are built
+// without the list options of the factory: --label-selector and
+// --field-selector select deployments, and the ReplicaSets, pods, services and
+// events of a deployment rarely carry the same labels.
+func relatedIndexers() cache.Indexers {
+	return cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+}
+
+// deploymentDetailHandler serves GET /deployments/{namespace}/{name}
+func deploymentDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+	namespace, name, action, ok := parseDeploymentPath(r.URL.Path)
+	if !ok || action != "" {
+		writeJSONError(w, http.StatusNotFound, "not found: %s, expected /deployments/{namespace}/{name}", r.URL.Path)
+		return
+	}
+
+	deployment, snapshot, ok := cachedDeployment(w, namespace, name)
+	if !ok {
+		return
+	}
+
+	etag, err := detailETag(deployment, snapshot != nil)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if checkNotModified(w, r, etag, relatedLastModified(snapshot)) {
+		return
+	}
+
+	detail, err := describeDeployment(deployment, apiReplicaSets, apiPods)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if snapshot != nil {
+		detail.Stale = true
+		w.Header().Set(api.SnapshotAgeHeader, strconv.Itoa(int(snapshot.age().Seconds())))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(detail); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// cachedDeployment looks up a deployment in the cache, or in the snapshot
+// while it is served. It responds with an error if there is none.
+func cachedDeployment(w http.ResponseWriter, namespace, name string) (*appsv1.Deployment, *cacheSnapshot, bool) {
+	indexer, snapshot := apiCache()
+	obj, exists, err := indexer.GetByKey(namespace + "/" + name)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "%v", err)
+		return nil, nil, false
+	}
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "deployment %s/%s not found", namespace, name)
+		return nil, nil, false
+	}
+	deployment, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "unexpected object %T in the deployment cache", obj)
+		return nil, nil, false
+	}
+	return deployment, snapshot, true
+}
+
+// relatedLastModified is the time the deployment or the related caches last changed
+func relatedLastModified(snapshot *cacheSnapshot) time.Time {
+	lastModified := apiLastModified(snapshot)
+	if _, relatedEvent := apiRelatedActivity.times(); snapshot == nil && relatedEvent != nil && relatedEvent.After(lastModified) {
+		lastModified = *relatedEvent
+	}
+	return lastModified
+}
+
+// detailETag covers the deployment and the ReplicaSets and pods of its detail
+func detailETag(d *appsv1.Deployment, stale bool) (string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return "", fmt.Errorf("invalid selector of %s/%s: %w", d.Namespace, d.Name, err)
+	}
+	replicaSets, pods, err := relatedObjects(d, selector, apiReplicaSets, apiPods)
+	if err != nil {
+		return "", err
+	}
+	etag := newETag("detail")
+	etag.add(fmt.Sprintf("stale=%t", stale))
+	etag.addObject(d)
+	for _, rs := range replicaSets {
+		etag.addObject(rs)
+	}
+	for _, pod := range pods {
+		etag.addObject(pod)
+	}
+	return etag.String(), nil
+}
+
+// describeDeployment builds the detail of a deployment from the related caches
+func describeDeployment(d *appsv1.Deployment, replicaSetLister appslisters.ReplicaSetLister, podLister corelisters.PodLister) (*api.DeploymentDetail, error) {
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of %s/%s: %w", d.Namespace, d.Name, err)
+	}
+
+	detail := &api.DeploymentDetail{
+		Name:        d.Name,
+		Namespace:   d.Namespace,
+		Created:     d.CreationTimestamp.Time,
+		Labels:      d.Labels,
+		Annotations: d.Annotations,
+		Selector:    selector.String(),
+		Replicas: api.ReplicaCounts{
+			// The API server defaults unset replicas to 1
+			Desired:     1,
+			Updated:     d.Status.UpdatedReplicas,
+			Ready:       d.Status.ReadyReplicas,
+			Available:   d.Status.AvailableReplicas,
+			Unavailable: d.Status.UnavailableReplicas,
+		},
+		Strategy:   api.DeploymentStrategy{Type: string(d.Spec.Strategy.Type)},
+		Images:     []api.ContainerImage{},
+		Conditions: []api.DeploymentCondition{},
+	}
+	detail.Revision, _ = strconv.ParseInt(d.Annotations[revisionAnnotation], 10, 64)
+	if d.Spec.Replicas != nil {
+		detail.Replicas.Desired = *d.Spec.Replicas
+	}
+	if rolling := d.Spec.Strategy.RollingUpdate; rolling != nil {
+		if rolling.MaxSurge != nil {
+			detail.Strategy.MaxSurge = rolling.MaxSurge.String()
+		}
+		if rolling.MaxUnavailable != nil {
+			detail.Strategy.MaxUnavailable = rolling.MaxUnavailable.String()
+		}
+	}
+	for _, c := range d.Spec.Template.Spec.InitContainers {
+		detail.Images = append(detail.Images, api.ContainerImage{Container: c.Name, Image: c.Image, Init: true})
+	}
+	for _, c := range d.Spec.Template.Spec.Containers {
+		detail.Images = append(detail.Images, api.ContainerImage{Container: c.Name, Image: c.Image})
+	}
+	for _, c := range d.Status.Conditions {
+		detail.Conditions = append(detail.Conditions, api.DeploymentCondition{
+			Type:           string(c.Type),
+			Status:         string(c.Status),
+			Reason:         c.Reason,
+			Message:        c.Message,
+			LastTransition: c.LastTransitionTime.Time,
+		})
+	}
+
+	owned, pods, err := relatedObjects(d, selector, replicaSetLister, podLister)
+	if err != nil {
+		return nil, err
+	}
+	detail.ReplicaSets = replicaSetSummaries(owned, detail.Revision)
+	detail.Pods = podSummaries(owned, pods)
+	return detail, nil
+}
+
+// replicaSetSummaries summarises the ReplicaSets of a deployment at revision
+func replicaSetSummaries(replicaSets []*appsv1.ReplicaSet, revision int64) []api.ReplicaSetSummary {
+	summaries := []api.ReplicaSetSummary{}
+	for _, rs := range replicaSets {
+		summary := api.ReplicaSetSummary{
+			Name:     rs.Name,
+			Revision: replicaSetRevision(rs),
+			Current:  revision != 0 && replicaSetRevision(rs) == revision,
+			Ready:    rs.Status.ReadyReplicas,
+			Images:   []string{},
+			Created:  rs.CreationTimestamp.Time,
+		}
+		if rs.Spec.Replicas != nil {
+			summary.Replicas = *rs.Spec.Replicas
+		}
+		for _, c := range rs.Spec.Template.Spec.Containers {
+			summary.Images = append(summary.Images, c.Image)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// podSummaries summarises pods owned by the given ReplicaSets
+func podSummaries(replicaSets []*appsv1.ReplicaSet, pods []*corev1.Pod) []api.PodSummary {
+	names := make(map[types.UID]string, len(replicaSets))
+	for _, rs := range replicaSets {
+		names[rs.UID] = rs.Name
+	}
+	summaries := []api.PodSummary{}
+	for _, pod := range pods {
+		ready, total, restarts := podReadiness(pod)
+		summaries = append(summaries, api.PodSummary{
+			Name:       pod.Name,
+			ReplicaSet: names[metav1.GetControllerOf(pod).UID],
+			Phase:      string(pod.Status.Phase),
+			Ready:      ready,
+			Containers: total,
+			Restarts:   restarts,
+			Node:       pod.Spec.NodeName,
+			IP:         pod.Status.PodIP,
+			Created:    pod.CreationTimestamp.Time,
+		})
+	}
+	return summaries
+}
+
+// relatedObjects returns the ReplicaSets a deployment owns and the pods they
+// own, none without the related caches
+func relatedObjects(d *appsv1.Deployment, selector labels.Selector, replicaSetLister appslisters.ReplicaSetLister, podLister corelisters.PodLister) ([]*appsv1.ReplicaSet, []*corev1.Pod, error) {
+	if replicaSetLister == nil || podLister == nil {
+		return nil, nil, nil
+	}
+	replicaSets, err := replicaSetLister.ReplicaSets(d.Namespace).List(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	owned := ownedReplicaSets(d, replicaSets)
+	pods, err := podLister.Pods(d.Namespace).List(selector)
+	if err != nil {
+		return nil, nil, err
+	}
+	return owned, ownedPods(owned, pods), nil
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newTestReplicaSet returns a ReplicaSet revision controlled by deployment
+func newTestReplicaSet(deployment *appsv1.Deployment, name, revision, image string) *appsv1.ReplicaSet {
+	controller := true
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   deployment.Namespace,
+			UID:         types.UID(name + "-uid"),
+			Labels:      deployment.Spec.Selector.MatchLabels,
+			Annotations: map[string]string{revisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Deployment", Name: deployment.Name, UID: deployment.UID, Controller: &controller},
+			},
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Replicas: int32Ptr(1),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: image}}},
+			},
+		},
+	}
+}
+
+// startTestAPI serves the api handlers for the default namespace from a fake cluster holding objs
+func startTestAPI(t *testing.T, objs ...runtime.Object) *fake.Clientset {
+	t.Helper()
+	return startTestAPIWithOptions(t, informerOptions{namespaces: []string{"default"}}, objs...)
+}
+
+// startTestAPIWithOptions is startTestAPI with the selectors of opts applied
+// to the api factory
+func startTestAPIWithOptions(t *testing.T, opts informerOptions, objs ...runtime.Object) *fake.Clientset {
+	t.Helper()
+	client := fake.NewSimpleClientset(objs...)
+	previousClient, previousOpts := apiClient, apiInformerOpts
+	t.Cleanup(func() { apiClient, apiInformerOpts = previousClient, previousOpts })
+	apiClient, apiInformerOpts = client, opts
+
+	factory := opts.newFactory(client, metav1.NamespaceAll)
+	deploymentInformer := factory.Apps().V1().Deployments().Informer()
+	if err := addDeploymentIndexers(deploymentInformer); err != nil {
+		t.Fatal(err)
+	}
+
+	previousInformer, previousReplicaSets, previousPods := informer, apiReplicaSets, apiPods
+	t.Cleanup(func() { informer, apiReplicaSets, apiPods = previousInformer, previousReplicaSets, previousPods })
+	previousServices, previousEvents, previousSynced := apiServices, apiEvents, apiRelatedSynced
+	t.Cleanup(func() { apiServices, apiEvents, apiRelatedSynced = previousServices, previousEvents, previousSynced })
+	informer = deploymentInformer
+	if err := addRelatedInformers(factory, metav1.NamespaceAll); err != nil {
+		t.Fatal(err)
+	}
+	previousWatch := apiWatch
+	t.Cleanup(func() { apiWatch = previousWatch })
+	apiWatch = newWatchBroadcaster(100)
+	if _, err := informer.AddEventHandler(apiWatch.handler()); err != nil {
+		t.Fatal(err)
+	}
+	previousActivity := apiActivity
+	t.Cleanup(func() { apiActivity = previousActivity })
+	apiActivity = newCacheActivity()
+	if _, err := informer.AddEventHandler(apiActivity.handler()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	return client
+}
+
+func TestDeploymentDetailHandler(t *testing.T) {
+	deployment := newTestDeployment("web", 2, 1)
+	deployment.Annotations = map[string]string{revisionAnnotation: "2", "team": "storefront"}
+	maxSurge := intstr.FromString("25%")
+	deployment.Spec.Strategy = appsv1.DeploymentStrategy{
+		Type:          appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{MaxSurge: &maxSurge},
+	}
+	deployment.Status.Conditions = []appsv1.DeploymentCondition{
+		{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: "ReplicaSetUpdated"},
+	}
+	old := newTestReplicaSet(deployment, "web-1", "1", "nginx:1.24")
+	current := newTestReplicaSet(deployment, "web-2", "2", "nginx:1.25")
+	pod := newTestPod("web-2-a", current.Name, current.UID, corev1.PodRunning)
+	pod.Labels = map[string]string{"app": "web"}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "web", Ready: true, RestartCount: 2}}
+	// Pods of another deployment are not included
+	other := newTestPod("api-1-a", "api-1", "api-1-uid", corev1.PodRunning)
+	other.Labels = map[string]string{"app": "web"}
+
+	startTestAPI(t, deployment, old, current, pod, other)
+
+	rr := httptest.NewRecorder()
+	deploymentDetailHandler(rr, httptest.NewRequest("GET", "/deployments/default/web", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var detail api.DeploymentDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+
+	if detail.Revision != 2 || detail.Replicas.Desired != 2 || detail.Replicas.Ready != 1 {
+		t.Errorf("Unexpected revision or replicas: %+v", detail)
+	}
+	if detail.Selector != "app=web" || detail.Annotations["team"] != "storefront" {
+		t.Errorf("Unexpected selector or annotations: %q %v", detail.Selector, detail.Annotations)
+	}
+	if detail.Strategy.Type != "RollingUpdate" || detail.Strategy.MaxSurge != "25%" {
+		t.Errorf("Unexpected strategy %+v", detail.Strategy)
+	}
+	if len(detail.Images) != 1 || detail.Images[0].Image != "nginx:1.25" {
+		t.Errorf("Unexpected images %+v", detail.Images)
+	}
+	if len(detail.Conditions) != 1 || detail.Conditions[0].Reason != "ReplicaSetUpdated" {
+		t.Errorf("Unexpected conditions %+v", detail.Conditions)
+	}
+	if len(detail.ReplicaSets) != 2 || detail.ReplicaSets[0].Name != "web-2" || !detail.ReplicaSets[0].Current ||
+		detail.ReplicaSets[1].Current || detail.ReplicaSets[1].Images[0] != "nginx:1.24" {
+		t.Errorf("Expected revisions newest first with the current one marked, got %+v", detail.ReplicaSets)
+	}
+	if len(detail.Pods) != 1 {
+		t.Fatalf("Expected one pod, got %+v", detail.Pods)
+	}
+	if p := detail.Pods[0]; p.ReplicaSet != "web-2" || p.Ready != 1 || p.Containers != 1 || p.Restarts != 2 || p.Node != "node-1" {
+		t.Errorf("Unexpected pod %+v", p)
+	}
+}
+
+func TestDeploymentDetailHandler_LabelSelector(t *testing.T) {
+	// --label-selector selects deployments, their ReplicaSets and pods do not carry the label
+	deployment := newTestDeployment("web", 1, 1)
+	deployment.Labels = map[string]string{"team": "storefront"}
+	deployment.Annotations = map[string]string{revisionAnnotation: "1"}
+	rs := newTestReplicaSet(deployment, "web-1", "1", "nginx:1.25")
+	pod := newTestPod("web-1-a", rs.Name, rs.UID, corev1.PodRunning)
+	pod.Labels = map[string]string{"app": "web"}
+
+	startTestAPIWithOptions(t, informerOptions{namespaces: []string{"default"}, labelSelector: "team=storefront"}, deployment, rs, pod)
+
+	rr := httptest.NewRecorder()
+	deploymentDetailHandler(rr, httptest.NewRequest("GET", "/deployments/default/web", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var detail api.DeploymentDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(detail.ReplicaSets) != 1 || len(detail.Pods) != 1 {
+		t.Errorf("Expected the ReplicaSet and pod despite the selector, got %+v and %+v", detail.ReplicaSets, detail.Pods)
+	}
+}
+
+func TestDeploymentDetailHandler_NotFound(t *testing.T) {
+	startTestAPI(t, newTestDeployment("web", 1, 1))
+
+	for _, path := range []string{"/deployments/default/missing", "/deployments/default", "/deployments/default/web/extra"} {
+		rr := httptest.NewRecorder()
+		deploymentDetailHandler(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rr.Code)
+		}
+		var body api.Error
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.Error == "" {
+			t.Errorf("%s: expected a JSON error body, got %s", path, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	deploymentDetailHandler(rr, httptest.NewRequest("DELETE", "/deployments/default/web", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rr.Code)
+	}
+}
+
+func TestDeploymentDetailHandler_UnexpectedObject(t *testing.T) {
+	startTestAPI(t)
+	// A snapshot is served while the informer has not synced
+	informer = informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Apps().V1().Deployments().Informer()
+	previousSnapshot := apiSnapshot
+	t.Cleanup(func() { apiSnapshot = previousSnapshot })
+	apiSnapshot = &cacheSnapshot{indexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, nil), time: time.Now()}
+	if err := apiSnapshot.indexer.Add(newTestPod("web", "", "", corev1.PodRunning)); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	deploymentDetailHandler(rr, httptest.NewRequest("GET", "/deployments/default/web", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for a non-deployment in the cache, got %d", rr.Code)
+	}
+}
+
+func TestListDeploymentsHandler_LinksToDetail(t *testing.T) {
+	startTestAPI(t, newTestDeployment("web", 1, 1))
+
+	rr := httptest.NewRecorder()
+	listDeploymentsHandler(rr, httptest.NewRequest("GET", "/deployments", nil))
+	var deployments []api.Deployment
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployments); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(deployments) != 1 || deployments[0].Link != "/deployments/default/web" {
+		t.Errorf("Expected a link to the detail endpoint, got %+v", deployments)
+	}
+}
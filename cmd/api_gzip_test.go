@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func serveCompressed(handler http.Handler, target, acceptEncoding string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	compressAPI(handler).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCompressAPI_LargeList(t *testing.T) {
+	var objs []runtime.Object
+	for i := 0; i < 50; i++ {
+		objs = append(objs, newTestDeployment(fmt.Sprintf("service-%02d", i), 2, 2))
+	}
+	startTestAPI(t, objs...)
+	mux := newTestMux()
+
+	plain := serveCompressed(mux, "/deployments", "")
+	rr := serveCompressed(mux, "/deployments", "br, gzip;q=0.8")
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip response, got headers %v", rr.Header())
+	}
+	if rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q", rr.Header().Get("Vary"))
+	}
+	if rr.Body.Len() >= plain.Body.Len() {
+		t.Errorf("compressed body of %d bytes is not smaller than %d", rr.Body.Len(), plain.Body.Len())
+	}
+	reader, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != plain.Body.String() {
+		t.Error("decompressed body differs from the plain response")
+	}
+	if rr.Header().Get("ETag") != plain.Header().Get("ETag") {
+		t.Error("both encodings should share the weak ETag")
+	}
+
+	if rr := serveCompressed(mux, "/deployments", "gzip", "If-None-Match", plain.Header().Get("ETag")); rr.Code != http.StatusNotModified || rr.Header().Get("Content-Encoding") != "" || rr.Body.Len() != 0 {
+		t.Errorf("expected a plain empty 304, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestCompressAPI_PassThrough(t *testing.T) {
+	large := strings.Repeat("x", 2*gzipMinSize)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("small") != "" {
+			io.WriteString(w, "ok")
+			return
+		}
+		io.WriteString(w, large)
+	})
+
+	tests := []struct {
+		name, target, acceptEncoding string
+		headers                      []string
+	}{
+		{"small body", "/deployments?small=1", "gzip", nil},
+		{"gzip refused", "/deployments", "gzip;q=0, identity", nil},
+		{"no Accept-Encoding", "/deployments", "", nil},
+		{"watch stream", watchRoute, "gzip", nil},
+		{"websocket upgrade", "/deployments", "gzip", []string{"Upgrade", "websocket"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveCompressed(handler, tt.target, tt.acceptEncoding, tt.headers...)
+			if encoding := rr.Header().Get("Content-Encoding"); encoding != "" {
+				t.Errorf("expected no encoding, got %s", encoding)
+			}
+			if rr.Body.String() != large && rr.Body.String() != "ok" {
+				t.Errorf("unexpected body of %d bytes", rr.Body.Len())
+			}
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, gzip":     true,
+		"gzip;q=0":          false,
+		"gzip; q=0.5":       true,
+		"*":                 true,
+		"identity":          false,
+		"x-gzip, deflate":   false,
+		"br;q=1, *;q=0.1":   true,
+		"deflate, *;q=0.0":  false,
+		" GZIP ":            true,
+		"compress, gzip;q1": true,
+	}
+	for header, want := range tests {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
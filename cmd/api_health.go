@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+// apiActivity records when the api cache last synced and changed
+var apiActivity = newCacheActivity()
+
+// cacheActivity tracks the sync and event times of the deployment cache
+type cacheActivity struct {
+	mu        sync.Mutex
+	lastSync  time.Time
+	lastEvent time.Time
+}
+
+func newCacheActivity() *cacheActivity {
+	return &cacheActivity{}
+}
+
+// synced records that the cache finished its initial list
+func (a *cacheActivity) synced() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastSync = time.Now()
+}
+
+// handler returns the informer event handler that records the event times.
+// Resyncs of unchanged objects count as syncs, not as events.
+func (a *cacheActivity) handler() cache.ResourceEventHandler {
+	event := func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.lastEvent = time.Now()
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { event() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, errOld := meta.Accessor(oldObj)
+			newMeta, errNew := meta.Accessor(newObj)
+			if errOld == nil && errNew == nil && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				a.synced()
+				return
+			}
+			event()
+		},
+		DeleteFunc: func(obj interface{}) { event() },
+	}
+}
+
+// times returns the last sync and event times, nil if they never happened
+func (a *cacheActivity) times() (lastSync, lastEvent *time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.lastSync.IsZero() {
+		t := a.lastSync
+		lastSync = &t
+	}
+	if !a.lastEvent.IsZero() {
+		t := a.lastEvent
+		lastEvent = &t
+	}
+	return lastSync, lastEvent
+}
+
+// probeStatus is the body of the readiness and liveness endpoints
+type probeStatus struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// cacheStatus is the body of /debug/cache
+type cacheStatus struct {
+	Synced          bool            `json:"synced"`
+	ServingSnapshot bool            `json:"servingSnapshot,omitempty"`
+	Objects         int             `json:"objects"`
+	ResourceVersion string          `json:"resourceVersion,omitempty"`
+	LastSync        *time.Time      `json:"lastSync,omitempty"`
+	LastEvent       *time.Time      `json:"lastEvent,omitempty"`
+	Connection      connectionState `json:"connection"`
+}
+
+// healthHandler reports the API server connection of the informer. It fails
+// while the connection is lost, a degraded connection still serves the cache.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := watchConnection.status()
+	w.Header().Set("Content-Type", "application/json")
+	if status.State == stateDisconnected {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// readyHandler serves /readyz. The api is ready once the deployment cache and
+// the related caches have synced, and stops being ready while the deployment watch fails or the
+// connection is lost. A loaded snapshot is served precisely while the live
+// cache is not available, so the api stays ready with a stale reason then.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	var reasons []string
+	if !informer.HasSynced() {
+		reasons = append(reasons, "deployment cache has not synced")
+	}
+	for _, related := range apiRelatedSynced {
+		if !related.synced() {
+			reasons = append(reasons, related.kind+" cache has not synced")
+		}
+	}
+	connection := watchConnection.status()
+	key := "deployments"
+	if namespaces := apiInformerOpts.watchedNamespaces(); len(namespaces) > 0 {
+		key = connectionKey(namespaces[0], "deployments")
+	}
+	if failing, ok := connection.Resources[key]; ok {
+		reasons = append(reasons, fmt.Sprintf("deployment watch is failing (%s): %s", failing.Reason, failing.LastError))
+	}
+	if connection.State == stateDisconnected {
+		reasons = append(reasons, "API server connection is lost")
+	}
+
+	if _, snapshot := apiCache(); snapshot != nil {
+		stale := fmt.Sprintf("stale: serving the snapshot taken %s ago", snapshot.age().Round(time.Second))
+		writeProbe(w, http.StatusOK, probeStatus{Status: "ok", Reasons: append([]string{stale}, reasons...)})
+		return
+	}
+	if len(reasons) > 0 {
+		writeProbe(w, http.StatusServiceUnavailable, probeStatus{Status: "not ready", Reasons: reasons})
+		return
+	}
+	writeProbe(w, http.StatusOK, probeStatus{Status: "ok"})
+}
+
+// liveHandler serves /livez. Answering at all is proof of life: a lost
+// connection or an unsynced cache is not fixed by restarting the process.
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, http.StatusOK, probeStatus{Status: "ok"})
+}
+
+func writeProbe(w http.ResponseWriter, code int, status probeStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// debugCacheHandler serves /debug/cache with the state of the deployment cache
+func debugCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+	indexer, snapshot := apiCache()
+	status := cacheStatus{
+		Synced:          informer.HasSynced(),
+		ServingSnapshot: snapshot != nil,
+		Objects:         len(indexer.ListKeys()),
+		ResourceVersion: informer.LastSyncResourceVersion(),
+		Connection:      watchConnection.status().State,
+	}
+	status.LastSync, status.LastEvent = apiActivity.times()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
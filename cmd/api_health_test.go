@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestReadyHandler(t *testing.T) {
+	previousInformer, previousConnection := informer, watchConnection
+	defer func() { informer, watchConnection = previousInformer, previousConnection }()
+	watchConnection = newConnectionTracker()
+
+	probe := func() (int, probeStatus) {
+		rr := httptest.NewRecorder()
+		readyHandler(rr, httptest.NewRequest("GET", "/readyz", nil))
+		var status probeStatus
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		return rr.Code, status
+	}
+
+	// An informer that never started has not synced
+	informer = informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0).Apps().V1().Deployments().Informer()
+	if code, status := probe(); code != http.StatusServiceUnavailable || len(status.Reasons) != 1 {
+		t.Errorf("Expected 503 before the sync, got %d %+v", code, status)
+	}
+
+	// A loaded snapshot keeps the api ready until the sync
+	previousSnapshot := apiSnapshot
+	apiSnapshot = &cacheSnapshot{indexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, nil), time: time.Now().Add(-time.Minute)}
+	code, status := probe()
+	apiSnapshot = previousSnapshot
+	if code != http.StatusOK || len(status.Reasons) != 2 || status.Reasons[0] != "stale: serving the snapshot taken 1m0s ago" {
+		t.Errorf("Expected 200 with a stale reason while serving a snapshot, got %d %+v", code, status)
+	}
+
+	startTestAPI(t, newTestDeployment("web", 1, 1))
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("Expected 200 once synced, got %d", code)
+	}
+
+	// The related caches must have synced too
+	apiRelatedSynced = append(apiRelatedSynced, relatedSynced{kind: "Service", synced: func() bool { return false }})
+	if code, status := probe(); code != http.StatusServiceUnavailable || len(status.Reasons) != 1 || status.Reasons[0] != "Service cache has not synced" {
+		t.Errorf("Expected 503 until the related caches synced, got %d %+v", code, status)
+	}
+	apiRelatedSynced = apiRelatedSynced[:len(apiRelatedSynced)-1]
+
+	watchConnection.watchError("Deployment", "default/deployments", io.ErrUnexpectedEOF)
+	if code, status := probe(); code != http.StatusServiceUnavailable || len(status.Reasons) != 1 {
+		t.Errorf("Expected 503 while the deployment watch fails, got %d %+v", code, status)
+	}
+	watchConnection.succeeded("default/deployments")
+
+	// Other failing resources degrade the connection but keep the api ready
+	watchConnection.watchError("Pod", "default/pods", io.ErrUnexpectedEOF)
+	if code, _ := probe(); code != http.StatusOK {
+		t.Errorf("Expected 200 while only pods fail, got %d", code)
+	}
+	watchConnection.succeeded("default/pods")
+}
+
+func TestLiveHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	liveHandler(rr, httptest.NewRequest("GET", "/livez", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rr.Code)
+	}
+}
+
+func TestDebugCacheHandler(t *testing.T) {
+	startTestAPI(t, newTestDeployment("web", 1, 1), newTestDeployment("api", 2, 2))
+	apiActivity.synced()
+
+	rr := httptest.NewRecorder()
+	debugCacheHandler(rr, httptest.NewRequest("GET", "/debug/cache", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	var status cacheStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if !status.Synced || status.ServingSnapshot || status.Objects != 2 || status.Connection != stateConnected {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if status.LastSync == nil || status.LastEvent == nil {
+		t.Errorf("Expected sync and event times, got %+v", status)
+	}
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// API server metrics. Routes are the URL patterns of the api, so the label
+// values stay bounded no matter which paths clients request.
+var (
+	apiRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_requests_total",
+		Help:      "Requests to the api server by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of api server requests by route and method, excluding watch streams.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"route", "method"})
+
+	apiResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_response_size_bytes",
+		Help:      "Size of api server response bodies by route and method, excluding watch streams.",
+		Buckets:   prometheus.ExponentialBuckets(128, 4, 8),
+	}, []string{"route", "method"})
+
+	apiRequestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_requests_in_flight",
+		Help:      "Requests the api server is handling by route, including open watch streams.",
+	}, []string{"route"})
+)
+
+// routeOther labels requests that match no api route
+const routeOther = "other"
+
+// watchRoute is the route of the long-lived watch streams
+const watchRoute = "/deployments/watch"
+
+// apiRoute maps a request path to the api route it is served by
+func apiRoute(urlPath string) string {
+	if _, fixed := apiPaths[urlPath]; fixed {
+		return urlPath
+	}
+	_, _, action, ok := parseDeploymentPath(urlPath)
+	if !ok {
+		return routeOther
+	}
+	if _, known := deploymentRoutes[action]; !known {
+		return routeOther
+	}
+	if action == "" {
+		return "/deployments/{namespace}/{name}"
+	}
+	return "/deployments/{namespace}/{name}/" + action
+}
+
+// instrumentAPI records the request metrics of every request to next
+func instrumentAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := apiRoute(r.URL.Path)
+		inFlight := apiRequestsInFlight.WithLabelValues(route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		apiRequestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		if route == watchRoute {
+			// Streams last as long as the client stays, which says nothing about latency
+			return
+		}
+		apiRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(started).Seconds())
+		apiResponseSize.WithLabelValues(route, r.Method).Observe(float64(recorder.size))
+	})
+}
+
+// statusRecorder remembers the status code and body size of a response. It
+// passes flushes and hijacks through for the watch streams.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(data)
+	r.size += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not support hijacking")
+	}
+	r.status, r.wroteHeader = http.StatusSwitchingProtocols, true
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// deploymentCollector exports gauges derived from the deployments the api
+// serves. They are computed from the cache on every scrape.
+type deploymentCollector struct {
+	list func() []interface{}
+
+	deployments *prometheus.Desc
+	unready     *prometheus.Desc
+}
+
+func newDeploymentCollector(list func() []interface{}) *deploymentCollector {
+	return &deploymentCollector{
+		list: list,
+		deployments: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "deployments"),
+			"Deployments served by the api by namespace.", []string{"namespace"}, nil),
+		unready: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "deployments_unready"),
+			"Deployments served by the api with fewer ready than desired replicas, by namespace.", []string{"namespace"}, nil),
+	}
+}
+
+func (c *deploymentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.deployments
+	ch <- c.unready
+}
+
+func (c *deploymentCollector) Collect(ch chan<- prometheus.Metric) {
+	total := make(map[string]int)
+	unready := make(map[string]int)
+	for _, obj := range c.list() {
+		d, ok := obj.(*appsv1.Deployment)
+		if !ok {
+			continue
+		}
+		total[d.Namespace]++
+		switch readinessState(d) {
+		case readinessReady, readinessScaledDown:
+		default:
+			unready[d.Namespace]++
+		}
+	}
+	// Namespaces without unready deployments report 0 rather than disappearing
+	for namespace, count := range total {
+		ch <- prometheus.MustNewConstMetric(c.deployments, prometheus.GaugeValue, float64(count), namespace)
+		ch <- prometheus.MustNewConstMetric(c.unready, prometheus.GaugeValue, float64(unready[namespace]), namespace)
+	}
+}
+
+// registerAPIMetrics adds the deployment gauges of the api cache to the registry
+func registerAPIMetrics() {
+	collector := newDeploymentCollector(func() []interface{} {
+		indexer, _ := apiCache()
+		return indexer.List()
+	})
+	if err := metrics.Registry.Register(collector); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if !errors.As(err, &already) {
+			klog.Errorf("Failed to register api metrics: %v", err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/websocket"
+)
+
+func TestAPIRoute(t *testing.T) {
+	tests := map[string]string{
+		"/deployments":                      "/deployments",
+		"/deployments/watch":                "/deployments/watch",
+		"/readyz":                           "/readyz",
+		"/deployments/default/web":          "/deployments/{namespace}/{name}",
+		"/deployments/default/web/scale":    "/deployments/{namespace}/{name}/scale",
+		"/deployments/default/web/anything": routeOther,
+		"/deployments/default":              routeOther,
+		"/favicon.ico":                      routeOther,
+	}
+	for path, expected := range tests {
+		if got := apiRoute(path); got != expected {
+			t.Errorf("apiRoute(%q) = %q, expected %q", path, got, expected)
+		}
+	}
+}
+
+func TestInstrumentAPI(t *testing.T) {
+	startTestAPI(t, newTestDeployment("web", 1, 1))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/deployments/", deploymentHandler)
+	mux.HandleFunc("/deployments/watch", watchDeploymentsHandler)
+	server := httptest.NewServer(instrumentAPI(mux))
+	defer server.Close()
+
+	const route = "/deployments/{namespace}/{name}"
+	ok := apiRequestsTotal.WithLabelValues(route, "GET", "200")
+	notFound := apiRequestsTotal.WithLabelValues(route, "GET", "404")
+	okBefore, notFoundBefore := testutil.ToFloat64(ok), testutil.ToFloat64(notFound)
+
+	for _, path := range []string{"/deployments/default/web", "/deployments/default/web", "/deployments/default/missing"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if got := testutil.ToFloat64(ok) - okBefore; got != 2 {
+		t.Errorf("Expected 2 successful requests, got %v", got)
+	}
+	if got := testutil.ToFloat64(notFound) - notFoundBefore; got != 1 {
+		t.Errorf("Expected 1 not found request, got %v", got)
+	}
+	if testutil.CollectAndCount(apiRequestDuration, "k8s_controller_api_request_duration_seconds") == 0 {
+		t.Error("Expected latency observations")
+	}
+
+	// Watch streams still upgrade through the instrumentation and count as in flight
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/deployments/watch", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg api.WatchEvent
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(apiRequestsInFlight.WithLabelValues(watchRoute)); got != 1 {
+		t.Errorf("Expected 1 watch in flight, got %v", got)
+	}
+	ws.Close()
+}
+
+func TestDeploymentCollector(t *testing.T) {
+	scaledDown := newTestDeployment("batch", 0, 0)
+	scaledDown.Namespace = "jobs"
+	objs := []interface{}{
+		newTestDeployment("web", 2, 2),
+		newTestDeployment("api", 2, 1),
+		newTestDeployment("worker", 1, 0),
+		scaledDown,
+	}
+	collector := newDeploymentCollector(func() []interface{} { return objs })
+
+	expected := `
+# HELP k8s_controller_deployments Deployments served by the api by namespace.
+# TYPE k8s_controller_deployments gauge
+k8s_controller_deployments{namespace="default"} 3
+k8s_controller_deployments{namespace="jobs"} 1
+# HELP k8s_controller_deployments_unready Deployments served by the api with fewer ready than desired replicas, by namespace.
+# TYPE k8s_controller_deployments_unready gauge
+k8s_controller_deployments_unready{namespace="default"} 2
+k8s_controller_deployments_unready{namespace="jobs"} 0
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
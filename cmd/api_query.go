@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Sort orders of the deployment list. A leading "-" reverses them.
+const (
+	sortByName  = "name"
+	sortByAge   = "age"
+	sortByReady = "ready"
+)
+
+// deploymentQuery is a parsed list request
+type deploymentQuery struct {
+	filters    []indexFilter
+	selector   labels.Selector
+	namespace  string
+	ready      *bool
+	sortBy     string
+	descending bool
+	limit      int
+	after      *deploymentSortKey
+
+	// allowed limits the result to the namespaces the caller may read, nil for all
+	allowed func(namespace string) bool
+
+	// scope identifies the filters and order a continue token belongs to
+	scope string
+}
+
+// deploymentSortKey is the position of a deployment in any list order
+type deploymentSortKey struct {
+	Namespace string `json:"ns"`
+	Name      string `json:"n"`
+	Created   int64  `json:"c"`
+	Ready     int32  `json:"r"`
+	Desired   int32  `json:"d"`
+}
+
+// continueToken is the decoded form of the opaque continue parameter
+type continueToken struct {
+	Scope string            `json:"s"`
+	After deploymentSortKey `json:"a"`
+}
+
+// parseDeploymentQuery reads the filter, sort and paging parameters of GET /deployments
+func parseDeploymentQuery(query url.Values) (*deploymentQuery, error) {
+	filters, err := parseIndexFilters(query.Get("image"), query["label"], query.Get("team"), query.Get("readiness"))
+	if err != nil {
+		return nil, err
+	}
+	q := &deploymentQuery{filters: filters, namespace: query.Get("namespace"), sortBy: sortByName}
+
+	if selector := query.Get("labelSelector"); selector != "" {
+		if q.selector, err = labels.Parse(selector); err != nil {
+			return nil, fmt.Errorf("invalid labelSelector: %w", err)
+		}
+	}
+	if ready := query.Get("ready"); ready != "" {
+		value, err := strconv.ParseBool(ready)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ready %q, expected true or false", ready)
+		}
+		q.ready = &value
+	}
+	if order := query.Get("sort"); order != "" {
+		q.sortBy, q.descending = strings.TrimPrefix(order, "-"), strings.HasPrefix(order, "-")
+		switch q.sortBy {
+		case sortByName, sortByAge, sortByReady:
+		default:
+			return nil, fmt.Errorf("invalid sort %q, expected name, age or ready, optionally prefixed with -", order)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if q.limit, err = strconv.Atoi(limit); err != nil || q.limit < 1 {
+			return nil, fmt.Errorf("invalid limit %q, expected a positive number", limit)
+		}
+	}
+
+	// Tokens are only valid for the query they were issued for
+	scope := url.Values{}
+	for key, values := range query {
+		if key != "limit" && key != "continue" {
+			scope[key] = values
+		}
+	}
+	q.scope = scope.Encode()
+
+	if encoded := query.Get("continue"); encoded != "" {
+		var token continueToken
+		data, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err == nil {
+			err = json.Unmarshal(data, &token)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid continue token")
+		}
+		if token.Scope != q.scope {
+			return nil, fmt.Errorf("continue token was issued for a different query")
+		}
+		q.after = &token.After
+	}
+	return q, nil
+}
+
+// matches applies the filters that are not answered by the cache indexes
+func (q *deploymentQuery) matches(d *appsv1.Deployment) bool {
+	if q.namespace != "" && d.Namespace != q.namespace {
+		return false
+	}
+	if q.allowed != nil && !q.allowed(d.Namespace) {
+		return false
+	}
+	if q.selector != nil && !q.selector.Matches(labels.Set(d.Labels)) {
+		return false
+	}
+	if q.ready != nil {
+		state := readinessState(d)
+		if ready := state == readinessReady || state == readinessScaledDown; ready != *q.ready {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAll applies the index filters and the other filters to a single deployment
+func (q *deploymentQuery) matchesAll(d *appsv1.Deployment) bool {
+	return matchesIndexFilters(d, q.filters) && q.matches(d)
+}
+
+// apply filters and sorts deployments and cuts out the requested page. It
+// returns the page, the number of matching deployments and the token of the
+// next page, which is empty on the last page.
+func (q *deploymentQuery) apply(deployments []*appsv1.Deployment) ([]*appsv1.Deployment, int, string) {
+	var matched []*appsv1.Deployment
+	for _, d := range deployments {
+		if q.matches(d) {
+			matched = append(matched, d)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return q.less(sortKeyOf(matched[i]), sortKeyOf(matched[j]))
+	})
+
+	// Continue after the last item of the previous page, wherever it is now
+	page := matched
+	if q.after != nil {
+		start := sort.Search(len(matched), func(i int) bool {
+			return q.less(*q.after, sortKeyOf(matched[i]))
+		})
+		page = matched[start:]
+	}
+	if q.limit == 0 || len(page) <= q.limit {
+		return page, len(matched), ""
+	}
+
+	page = page[:q.limit]
+	data, _ := json.Marshal(continueToken{Scope: q.scope, After: sortKeyOf(page[len(page)-1])})
+	return page, len(matched), base64.RawURLEncoding.EncodeToString(data)
+}
+
+// less orders sort keys by the requested field, then by namespace and name
+func (q *deploymentQuery) less(a, b deploymentSortKey) bool {
+	if q.descending {
+		a, b = b, a
+	}
+	switch q.sortBy {
+	case sortByAge:
+		// Younger deployments first, so -age lists the oldest first
+		if a.Created != b.Created {
+			return a.Created > b.Created
+		}
+	case sortByReady:
+		// The least ready deployments first
+		if ra, rb := a.readyFraction(), b.readyFraction(); ra != rb {
+			return ra < rb
+		}
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+func sortKeyOf(d *appsv1.Deployment) deploymentSortKey {
+	key := deploymentSortKey{
+		Namespace: d.Namespace,
+		Name:      d.Name,
+		Created:   d.CreationTimestamp.Unix(),
+		Ready:     d.Status.ReadyReplicas,
+		Desired:   1,
+	}
+	if d.Spec.Replicas != nil {
+		key.Desired = *d.Spec.Replicas
+	}
+	return key
+}
+
+// readyFraction is the share of desired replicas that are ready. Scaled-down
+// deployments count as fully ready.
+func (k deploymentSortKey) readyFraction() float64 {
+	if k.Desired <= 0 {
+		return 1
+	}
+	return float64(k.Ready) / float64(k.Desired)
+}
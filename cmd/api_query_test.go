@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// queryTestDeployments returns deployments of different ages and readiness in two namespaces
+func queryTestDeployments() []*appsv1.Deployment {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var deployments []*appsv1.Deployment
+	for i, spec := range []struct {
+		namespace, name string
+		replicas, ready int32
+		tier            string
+	}{
+		{"production", "web", 3, 3, "frontend"},
+		{"production", "api", 4, 1, "backend"},
+		{"production", "worker", 2, 0, "backend"},
+		{"staging", "web", 1, 1, "frontend"},
+		{"staging", "idle", 0, 0, "backend"},
+	} {
+		d := newTestDeployment(spec.name, spec.replicas, spec.ready)
+		d.Namespace = spec.namespace
+		d.Labels = map[string]string{"tier": spec.tier}
+		d.CreationTimestamp = metav1.NewTime(created.Add(time.Duration(i) * time.Hour))
+		deployments = append(deployments, d)
+	}
+	return deployments
+}
+
+func keysOf(deployments []*appsv1.Deployment) string {
+	var keys []string
+	for _, d := range deployments {
+		keys = append(keys, d.Namespace+"/"+d.Name)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestDeploymentQuery_FiltersAndSorts(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"", "production/api,production/web,production/worker,staging/idle,staging/web"},
+		{"namespace=staging", "staging/idle,staging/web"},
+		{"labelSelector=tier+in+(backend)", "production/api,production/worker,staging/idle"},
+		{"ready=false", "production/api,production/worker"},
+		{"ready=true&labelSelector=tier=frontend", "production/web,staging/web"},
+		{"sort=-name", "staging/web,staging/idle,production/worker,production/web,production/api"},
+		{"sort=age", "staging/idle,staging/web,production/worker,production/api,production/web"},
+		{"sort=-age", "production/web,production/api,production/worker,staging/web,staging/idle"},
+		{"sort=ready", "production/worker,production/api,production/web,staging/idle,staging/web"},
+	}
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.query)
+		q, err := parseDeploymentQuery(values)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.query, err)
+		}
+		page, total, next := q.apply(queryTestDeployments())
+		if got := keysOf(page); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.query, tt.expected, got)
+		}
+		if total != len(page) || next != "" {
+			t.Errorf("%s: expected a single page, got total %d and token %q", tt.query, total, next)
+		}
+	}
+}
+
+func TestDeploymentQuery_Pagination(t *testing.T) {
+	deployments := queryTestDeployments()
+	values := url.Values{"sort": {"-age"}, "limit": {"2"}}
+
+	var pages []string
+	var totals []int
+	for {
+		q, err := parseDeploymentQuery(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, total, next := q.apply(deployments)
+		totals = append(totals, total)
+		pages = append(pages, keysOf(page))
+		if next == "" {
+			break
+		}
+		values.Set("continue", next)
+
+		// Deployments added before the current position don't shift later pages
+		if len(pages) == 1 {
+			added := newTestDeployment("cron", 1, 1)
+			added.Namespace = "production"
+			added.CreationTimestamp = metav1.NewTime(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+			deployments = append(deployments, added)
+		}
+	}
+	expected := []string{"production/web,production/api", "production/worker,staging/web", "staging/idle"}
+	if fmt.Sprint(pages) != fmt.Sprint(expected) {
+		t.Errorf("Expected pages %v, got %v", expected, pages)
+	}
+	if fmt.Sprint(totals) != "[5 6 6]" {
+		t.Errorf("Expected the total to count the added deployment, got %v", totals)
+	}
+}
+
+func TestParseDeploymentQuery_Errors(t *testing.T) {
+	q, _ := parseDeploymentQuery(url.Values{"limit": {"1"}})
+	_, _, token := q.apply(queryTestDeployments())
+
+	for _, values := range []url.Values{
+		{"labelSelector": {"tier in"}},
+		{"ready": {"maybe"}},
+		{"sort": {"replicas"}},
+		{"limit": {"0"}},
+		{"limit": {"ten"}},
+		{"continue": {"not-a-token"}},
+		{"continue": {token}, "limit": {"1"}, "namespace": {"staging"}},
+	} {
+		if _, err := parseDeploymentQuery(values); err == nil {
+			t.Errorf("Expected an error for %v", values)
+		}
+	}
+	if _, err := parseDeploymentQuery(url.Values{"continue": {token}, "limit": {"3"}}); err != nil {
+		t.Errorf("Expected the token to work with another limit, got %v", err)
+	}
+}
+
+func TestListDeploymentsHandler_Paging(t *testing.T) {
+	var objs []runtime.Object
+	for _, d := range queryTestDeployments() {
+		objs = append(objs, d)
+	}
+	startTestAPI(t, objs...)
+
+	rr := httptest.NewRecorder()
+	listDeploymentsHandler(rr, httptest.NewRequest("GET", "/deployments?ready=false&limit=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get(api.TotalCountHeader); got != "2" {
+		t.Errorf("Expected a total count of 2, got %q", got)
+	}
+	next := rr.Header().Get(api.ContinueHeader)
+	if next == "" {
+		t.Fatal("Expected a continue token")
+	}
+
+	rr = httptest.NewRecorder()
+	listDeploymentsHandler(rr, httptest.NewRequest("GET", "/deployments?ready=false&limit=1&continue="+next, nil))
+	var deployments []api.Deployment
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployments); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(deployments) != 1 || deployments[0].Name != "worker" || rr.Header().Get(api.ContinueHeader) != "" {
+		t.Errorf("Expected the last page to hold worker, got %+v", deployments)
+	}
+
+	rr = httptest.NewRecorder()
+	listDeploymentsHandler(rr, httptest.NewRequest("GET", "/deployments?sort=size", nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"error"`) {
+		t.Errorf("Expected a JSON 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// relatedList returns the objects related to a deployment and their response
+// items. The objects make up the ETag.
+type relatedList func(d *appsv1.Deployment) ([]metav1.Object, interface{}, error)
+
+// relatedHandler serves GET /deployments/{namespace}/{name}/{action} with the
+// objects list finds for the deployment, from the caches of the api factory
+func relatedHandler(action string, list relatedList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace, name, _, _ := parseDeploymentPath(r.URL.Path)
+		deployment, snapshot, ok := cachedDeployment(w, namespace, name)
+		if !ok {
+			return
+		}
+		objects, items, err := list(deployment)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		if snapshot != nil {
+			w.Header().Set(api.SnapshotAgeHeader, strconv.Itoa(int(snapshot.age().Seconds())))
+		}
+
+		// The deployment selects the objects, so it is part of the ETag
+		etag := newETag(action)
+		etag.addObject(deployment)
+		for _, obj := range objects {
+			etag.addObject(obj)
+		}
+		if checkNotModified(w, r, etag.String(), relatedLastModified(snapshot)) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(items); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// ownedObjects returns the ReplicaSets and pods of a deployment from the api caches
+func ownedObjects(d *appsv1.Deployment) ([]*appsv1.ReplicaSet, []*corev1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid selector of %s/%s: %w", d.Namespace, d.Name, err)
+	}
+	return relatedObjects(d, selector, apiReplicaSets, apiPods)
+}
+
+// listRelatedPods serves /pods, the pods of all revisions sorted by name
+func listRelatedPods(d *appsv1.Deployment) ([]metav1.Object, interface{}, error) {
+	replicaSets, pods, err := ownedObjects(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects := make([]metav1.Object, 0, len(pods))
+	for _, pod := range pods {
+		objects = append(objects, pod)
+	}
+	return objects, podSummaries(replicaSets, pods), nil
+}
+
+// listRelatedReplicaSets serves /replicasets, the revisions newest first
+func listRelatedReplicaSets(d *appsv1.Deployment) ([]metav1.Object, interface{}, error) {
+	replicaSets, _, err := ownedObjects(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects := make([]metav1.Object, 0, len(replicaSets))
+	for _, rs := range replicaSets {
+		objects = append(objects, rs)
+	}
+	revision, _ := strconv.ParseInt(d.Annotations[revisionAnnotation], 10, 64)
+	return objects, replicaSetSummaries(replicaSets, revision), nil
+}
+
+// listRelatedServices serves /services, the services whose selector matches
+// the pod template labels, sorted by name
+func listRelatedServices(d *appsv1.Deployment) ([]metav1.Object, interface{}, error) {
+	summaries := []api.ServiceSummary{}
+	if apiServices == nil {
+		return nil, summaries, nil
+	}
+	services, err := apiServices.Services(d.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+	matching := servicesFor(d, services)
+	objects := make([]metav1.Object, 0, len(matching))
+	for _, svc := range matching {
+		objects = append(objects, svc)
+		summaries = append(summaries, serviceSummary(svc))
+	}
+	return objects, summaries, nil
+}
+
+// servicesFor returns the services that select the pods of a deployment.
+// Services without a selector have manually managed endpoints and never match.
+func servicesFor(d *appsv1.Deployment, services []*corev1.Service) []*corev1.Service {
+	podLabels := labels.Set(d.Spec.Template.Labels)
+	var matching []*corev1.Service
+	for _, svc := range services {
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		if labels.SelectorFromSet(svc.Spec.Selector).Matches(podLabels) {
+			matching = append(matching, svc)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Name < matching[j].Name })
+	return matching
+}
+
+func serviceSummary(svc *corev1.Service) api.ServiceSummary {
+	summary := api.ServiceSummary{
+		Name:      svc.Name,
+		Type:      string(svc.Spec.Type),
+		ClusterIP: svc.Spec.ClusterIP,
+		Selector:  svc.Spec.Selector,
+		Ports:     []api.ServicePort{},
+		Created:   svc.CreationTimestamp.Time,
+	}
+	if summary.Type == "" {
+		summary.Type = string(corev1.ServiceTypeClusterIP)
+	}
+	for _, port := range svc.Spec.Ports {
+		servicePort := api.ServicePort{
+			Name:     port.Name,
+			Protocol: string(port.Protocol),
+			Port:     port.Port,
+			NodePort: port.NodePort,
+		}
+		if port.TargetPort.String() != "0" {
+			servicePort.TargetPort = port.TargetPort.String()
+		}
+		if servicePort.Protocol == "" {
+			servicePort.Protocol = string(corev1.ProtocolTCP)
+		}
+		summary.Ports = append(summary.Ports, servicePort)
+	}
+	return summary
+}
+
+// listRelatedEvents serves /events, the events of the deployment, its
+// ReplicaSets and their pods, most recent first
+func listRelatedEvents(d *appsv1.Deployment) ([]metav1.Object, interface{}, error) {
+	summaries := []api.EventSummary{}
+	if apiEvents == nil {
+		return nil, summaries, nil
+	}
+	replicaSets, pods, err := ownedObjects(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	uids := map[types.UID]bool{d.UID: true}
+	for _, rs := range replicaSets {
+		uids[rs.UID] = true
+	}
+	for _, pod := range pods {
+		uids[pod.UID] = true
+	}
+	events, err := apiEvents.Events(d.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	related := eventsFor(uids, events)
+	objects := make([]metav1.Object, 0, len(related))
+	for _, event := range related {
+		objects = append(objects, event)
+		summaries = append(summaries, eventSummary(event))
+	}
+	return objects, summaries, nil
+}
+
+func eventSummary(event *corev1.Event) api.EventSummary {
+	count := event.Count
+	if event.Series != nil {
+		count = event.Series.Count
+	}
+	if count == 0 {
+		count = 1
+	}
+	return api.EventSummary{
+		Type:     event.Type,
+		Reason:   event.Reason,
+		Message:  event.Message,
+		Kind:     event.InvolvedObject.Kind,
+		Name:     event.InvolvedObject.Name,
+		Count:    count,
+		LastSeen: eventTime(event).Time,
+	}
+}
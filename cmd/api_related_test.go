@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apiclient "github.com/e1jefe/k8s-controller/pkg/client"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func newTestService(name string, selector map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name + "-svc")},
+		Spec: corev1.ServiceSpec{
+			Selector: selector,
+			Ports:    []corev1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromString("http")}},
+		},
+	}
+}
+
+func newTestEvent(name, kind, object string, uid types.UID, age time.Duration) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "default"},
+		InvolvedObject: corev1.ObjectReference{Kind: kind, Name: object, Namespace: "default", UID: uid},
+		Type:           corev1.EventTypeNormal,
+		Reason:         "Test",
+		Message:        name,
+		LastTimestamp:  metav1.NewTime(time.Now().Add(-age)),
+	}
+}
+
+// relatedTestObjects is web with two revisions, a pod, services and events, next to an unrelated api deployment
+func relatedTestObjects() []runtime.Object {
+	web := newTestDeployment("web", 1, 1)
+	web.Annotations = map[string]string{revisionAnnotation: "2"}
+	old := newTestReplicaSet(web, "web-1", "1", "nginx:1.24")
+	current := newTestReplicaSet(web, "web-2", "2", "nginx:1.25")
+	pod := newTestPod("web-2-a", current.Name, current.UID, corev1.PodRunning)
+	pod.Labels = web.Spec.Selector.MatchLabels
+	other := newTestDeployment("api", 1, 1)
+	otherPod := newTestPod("api-1-a", "api-1", "api-1-uid", corev1.PodRunning)
+	otherPod.Labels = other.Spec.Selector.MatchLabels
+
+	return []runtime.Object{
+		web, old, current, pod, other, otherPod,
+		newTestService("web", map[string]string{"app": "web"}),
+		newTestService("api", map[string]string{"app": "api"}),
+		newTestService("external", nil),
+		newTestEvent("scaled", "Deployment", "web", web.UID, time.Minute),
+		newTestEvent("pulled", "Pod", pod.Name, pod.UID, time.Second),
+		newTestEvent("created", "ReplicaSet", old.Name, old.UID, time.Hour),
+		newTestEvent("unrelated", "Pod", otherPod.Name, otherPod.UID, 0),
+	}
+}
+
+func getRelated(t *testing.T, target string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	newTestMux().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	if rr.Code == http.StatusOK && out != nil {
+		if err := json.Unmarshal(rr.Body.Bytes(), out); err != nil {
+			t.Fatalf("invalid response of %s: %v", target, err)
+		}
+	}
+	return rr
+}
+
+func TestRelatedEndpoints(t *testing.T) {
+	startTestAPI(t, relatedTestObjects()...)
+
+	var pods []map[string]interface{}
+	getRelated(t, "/deployments/default/web/pods", &pods)
+	if len(pods) != 1 || pods[0]["name"] != "web-2-a" || pods[0]["replicaSet"] != "web-2" {
+		t.Errorf("unexpected pods %v", pods)
+	}
+
+	var replicaSets []map[string]interface{}
+	getRelated(t, "/deployments/default/web/replicasets", &replicaSets)
+	if len(replicaSets) != 2 || replicaSets[0]["name"] != "web-2" || replicaSets[0]["current"] != true || replicaSets[1]["current"] != false {
+		t.Errorf("unexpected ReplicaSets %v", replicaSets)
+	}
+
+	var services []struct {
+		Name  string
+		Type  string
+		Ports []struct {
+			Port       int32
+			Protocol   string
+			TargetPort string
+		}
+	}
+	getRelated(t, "/deployments/default/web/services", &services)
+	if len(services) != 1 || services[0].Name != "web" || services[0].Type != "ClusterIP" {
+		t.Fatalf("expected only the web service, got %+v", services)
+	}
+	if port := services[0].Ports[0]; port.Port != 80 || port.Protocol != "TCP" || port.TargetPort != "http" {
+		t.Errorf("unexpected port %+v", port)
+	}
+
+	var events []struct{ Message, Kind string }
+	getRelated(t, "/deployments/default/web/events", &events)
+	var messages []string
+	for _, event := range events {
+		messages = append(messages, event.Message)
+	}
+	if len(messages) != 3 || messages[0] != "pulled" || messages[1] != "scaled" || messages[2] != "created" {
+		t.Errorf("expected the events of web most recent first, got %v", messages)
+	}
+}
+
+func TestRelatedEndpoints_LabelSelector(t *testing.T) {
+	objects := relatedTestObjects()
+	web := objects[0].(*appsv1.Deployment)
+	web.Labels = map[string]string{"team": "storefront"}
+	startTestAPIWithOptions(t, informerOptions{namespaces: []string{"default"}, labelSelector: "team=storefront"}, objects...)
+
+	var services []map[string]interface{}
+	getRelated(t, "/deployments/default/web/services", &services)
+	if len(services) != 1 {
+		t.Errorf("expected the web service despite the selector, got %v", services)
+	}
+	var events []map[string]interface{}
+	getRelated(t, "/deployments/default/web/events", &events)
+	if len(events) != 3 {
+		t.Errorf("expected the events of web despite the selector, got %v", events)
+	}
+}
+
+func TestRelatedEndpoints_Errors(t *testing.T) {
+	startTestAPI(t, relatedTestObjects()...)
+
+	if rr := getRelated(t, "/deployments/default/missing/pods", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing deployment, got %d", rr.Code)
+	}
+	rr := httptest.NewRecorder()
+	newTestMux().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/deployments/default/web/services", nil))
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("expected 405 with Allow: GET, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestRelatedEndpoints_ConditionalGet(t *testing.T) {
+	client := startTestAPI(t, relatedTestObjects()...)
+
+	first := getRelated(t, "/deployments/default/web/services", nil)
+	etag := first.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/deployments/default/web/services", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr := httptest.NewRecorder()
+	newTestMux().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rr.Code)
+	}
+	if other := getRelated(t, "/deployments/default/web/pods", nil).Header().Get("ETag"); other == etag {
+		t.Error("each related endpoint needs its own ETag")
+	}
+
+	svc := newTestService("web-canary", map[string]string{"app": "web"})
+	if _, err := client.CoreV1().Services("default").Create(context.TODO(), svc, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return getRelated(t, "/deployments/default/web/services", nil).Header().Get("ETag") != etag
+	})
+}
+
+func TestRelatedEndpoints_Client(t *testing.T) {
+	startTestAPI(t, relatedTestObjects()...)
+	server := httptest.NewServer(newTestMux())
+	defer server.Close()
+	c, err := apiclient.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.TODO()
+
+	if pods, err := c.ListPods(ctx, "default", "web"); err != nil || len(pods) != 1 {
+		t.Errorf("unexpected pods %+v, %v", pods, err)
+	}
+	if replicaSets, err := c.ListReplicaSets(ctx, "default", "web"); err != nil || len(replicaSets) != 2 || replicaSets[0].Revision != 2 {
+		t.Errorf("unexpected ReplicaSets %+v, %v", replicaSets, err)
+	}
+	if services, err := c.ListServices(ctx, "default", "web"); err != nil || len(services) != 1 || services[0].Selector["app"] != "web" {
+		t.Errorf("unexpected services %+v, %v", services, err)
+	}
+	if events, err := c.ListEvents(ctx, "default", "web"); err != nil || len(events) != 3 || events[0].Kind != "Pod" || events[0].Count != 1 {
+		t.Errorf("unexpected events %+v, %v", events, err)
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
 )
 
 func TestDeploymentStruct(t *testing.T) {
 	// Test the Deployment struct
-	d := Deployment{
+	d := api.Deployment{
 		Name:      "test-deployment",
 		Namespace: "default",
 		Replicas:  3,
@@ -59,7 +61,7 @@ func TestListDeploymentsHandler_WithNilInformer(t *testing.T) {
 		}
 
 		// Return empty array when informer is not set up
-		var deployments []Deployment
+		var deployments []api.Deployment
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(deployments)
 	})
@@ -71,7 +73,7 @@ func TestListDeploymentsHandler_WithNilInformer(t *testing.T) {
 	}
 
 	// Check that response is valid JSON
-	var deployments []Deployment
+	var deployments []api.Deployment
 	if err := json.Unmarshal(rr.Body.Bytes(), &deployments); err != nil {
 		t.Errorf("Response is not valid JSON: %v", err)
 	}
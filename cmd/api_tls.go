@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+// certReloadInterval is how often the certificate files are checked for changes
+var certReloadInterval = 10 * time.Second
+
+// tlsVersions are the accepted values of --tls-min-version, named as in Kubernetes components
+var tlsVersions = map[string]uint16{
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+// apiTLSEnabled reports whether the api serves HTTPS
+func apiTLSEnabled() bool {
+	return apiTLSCertFile != "" || apiTLSSelfSigned
+}
+
+// validateTLSFlags checks the TLS flags of the api before anything starts
+func validateTLSFlags() error {
+	if (apiTLSCertFile == "") != (apiTLSKeyFile == "") {
+		return fmt.Errorf("--tls-cert-file and --tls-key-file must be set together")
+	}
+	if apiTLSSelfSigned && apiTLSCertFile != "" {
+		return fmt.Errorf("--tls-self-signed cannot be combined with --tls-cert-file")
+	}
+	if !apiTLSEnabled() {
+		switch {
+		case apiClientCAFile != "":
+			return fmt.Errorf("--client-ca-file requires --tls-cert-file or --tls-self-signed")
+		case apiHTTPRedirectPort != "":
+			return fmt.Errorf("--http-redirect-port requires --tls-cert-file or --tls-self-signed")
+		}
+	}
+	if apiHTTPRedirectPort != "" && apiHTTPRedirectPort == apiPort {
+		return fmt.Errorf("--http-redirect-port must differ from --port")
+	}
+	_, _, err := parseTLSOptions(apiTLSMinVersion, apiTLSCipherSuites)
+	return err
+}
+
+// parseTLSOptions resolves the minimum version and cipher suite names
+func parseTLSOptions(minVersion string, cipherNames []string) (uint16, []uint16, error) {
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid --tls-min-version %q, expected VersionTLS12 or VersionTLS13", minVersion)
+	}
+	if len(cipherNames) == 0 {
+		return version, nil, nil
+	}
+	if version == tls.VersionTLS13 {
+		return 0, nil, fmt.Errorf("--tls-cipher-suites only applies to TLS 1.2, TLS 1.3 suites are not configurable")
+	}
+
+	// Only the suites Go considers secure are accepted
+	available := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range cipherNames {
+		id, ok := available[strings.TrimSpace(name)]
+		if !ok {
+			return 0, nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return version, ids, nil
+}
+
+// apiTLSConfig builds the server TLS configuration from the flags. The
+// certificate comes from the reloaded files or the self-signed bootstrap
+// certificate. With --client-ca-file it asks for client certificates, but
+// callers without one can still authenticate with a bearer token.
+func apiTLSConfig(stopCh <-chan struct{}) (*tls.Config, error) {
+	minVersion, cipherSuites, err := parseTLSOptions(apiTLSMinVersion, apiTLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: minVersion, CipherSuites: cipherSuites}
+
+	if apiTLSSelfSigned {
+		certPEM, keyPEM, err := selfSignedCertificate(selfSignedHosts())
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint := sha256.Sum256(cert.Certificate[0])
+		klog.Warningf("Serving a self-signed certificate for development, SHA-256 fingerprint %s", hex.EncodeToString(fingerprint[:]))
+		config.Certificates = []tls.Certificate{cert}
+	} else {
+		reloader, err := newCertificateReloader(apiTLSCertFile, apiTLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		go reloader.run(certReloadInterval, stopCh)
+		config.GetCertificate = reloader.getCertificate
+	}
+
+	if apiClientCAFile != "" {
+		data, err := os.ReadFile(apiClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in client CA file %s", apiClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// certificateReloader serves a certificate and key pair from files and
+// picks up new versions, such as cert-manager renewals written to a mounted
+// secret. A pair that does not load, for example while only one of the
+// files has been replaced, keeps the previous certificate in use.
+type certificateReloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newCertificateReloader loads the initial certificate, which must be valid
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the files if their content changed and reports whether it did
+func (r *certificateReloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.certFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read TLS key: %w", err)
+	}
+
+	r.mu.RLock()
+	unchanged := bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("invalid TLS certificate %s: %w", r.certFile, err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return false, fmt.Errorf("invalid TLS certificate %s: %w", r.certFile, err)
+	}
+
+	r.mu.Lock()
+	r.cert, r.certPEM, r.keyPEM = &cert, certPEM, keyPEM
+	r.mu.Unlock()
+	return true, nil
+}
+
+// run checks the files every interval until stopCh is closed
+func (r *certificateReloader) run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		changed, err := r.reload()
+		if err != nil {
+			klog.Errorf("Keeping the current TLS certificate: %v", err)
+			return
+		}
+		if changed {
+			klog.Infof("Reloaded TLS certificate %s, valid until %s", r.certFile, r.current().Leaf.NotAfter.Format(time.RFC3339))
+		}
+	}, interval, stopCh)
+}
+
+func (r *certificateReloader) current() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// getCertificate is the tls.Config hook that hands out the current certificate
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+// selfSignedHosts are the names of the bootstrap certificate
+func selfSignedHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	return hosts
+}
+
+// selfSignedCertificate creates a PEM encoded certificate and key for hosts,
+// valid for a year
+func selfSignedCertificate(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"k8s-controller self-signed"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// httpsRedirectHandler sends plain HTTP requests to the same URL on the
+// HTTPS port. 308 keeps the method and body of writes.
+func httpsRedirectHandler(httpsPort string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	}
+}
+
+// serveHTTPRedirect redirects plain HTTP on port to the api in the background
+func serveHTTPRedirect(port string) {
+	go func() {
+		klog.Infof("Redirecting http://localhost:%s to HTTPS", port)
+		if err := http.ListenAndServe(":"+port, httpsRedirectHandler(apiPort)); err != nil {
+			klog.Errorf("HTTP redirect listener failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCertificate writes a new self-signed pair to the files and returns the certificate
+func writeCertificate(t *testing.T, certFile, keyFile string) []byte {
+	t.Helper()
+	certPEM, keyPEM, err := selfSignedCertificate([]string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPEM
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	firstPEM := writeCertificate(t, certFile, keyFile)
+
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: reloader.getCertificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(liveHandler)}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// get connects trusting only the given certificate
+	get := func(certPEM []byte) error {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(certPEM)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+		resp, err := client.Get("https://" + listener.Addr().String() + "/livez")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(firstPEM); err != nil {
+		t.Fatalf("Expected the first certificate to be served: %v", err)
+	}
+
+	if changed, err := reloader.reload(); changed || err != nil {
+		t.Errorf("Expected unchanged files to be skipped, got %t, %v", changed, err)
+	}
+	secondPEM := writeCertificate(t, certFile, keyFile)
+	if changed, err := reloader.reload(); !changed || err != nil {
+		t.Fatalf("Expected the new files to load, got %t, %v", changed, err)
+	}
+	if err := get(secondPEM); err != nil {
+		t.Errorf("Expected the rotated certificate to be served: %v", err)
+	}
+
+	// Half of a rotation keeps the last good pair
+	otherKey := filepath.Join(dir, "other.key")
+	writeCertificate(t, filepath.Join(dir, "other.crt"), otherKey)
+	data, _ := os.ReadFile(otherKey)
+	os.WriteFile(keyFile, data, 0o600)
+	if _, err := reloader.reload(); err == nil {
+		t.Error("Expected a mismatched key to fail")
+	}
+	if err := get(secondPEM); err != nil {
+		t.Errorf("Expected the previous certificate to stay in use: %v", err)
+	}
+}
+
+func TestParseTLSOptions(t *testing.T) {
+	version, suites, err := parseTLSOptions("VersionTLS12", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil || version != tls.VersionTLS12 || len(suites) != 1 || suites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Unexpected options %x %v %v", version, suites, err)
+	}
+	for _, tt := range []struct {
+		version string
+		suites  []string
+	}{
+		{"VersionTLS10", nil},
+		{"VersionTLS12", []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{"VersionTLS12", []string{"TLS_MADE_UP"}},
+		{"VersionTLS13", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+	} {
+		if _, _, err := parseTLSOptions(tt.version, tt.suites); err == nil {
+			t.Errorf("Expected %s %v to be rejected", tt.version, tt.suites)
+		}
+	}
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	certPEM, keyPEM, err := selfSignedCertificate([]string{"localhost", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("localhost"); err != nil {
+		t.Error(err)
+	}
+	if !leaf.IPAddresses[0].Equal(net.ParseIP("::1")) {
+		t.Errorf("Expected the IP SAN, got %v", leaf.IPAddresses)
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	tests := []struct {
+		host, port, expected string
+	}{
+		{"api.example.com:8080", "8443", "https://api.example.com:8443/deployments?ready=false"},
+		{"api.example.com", "443", "https://api.example.com/deployments?ready=false"},
+		{"[::1]:8080", "8443", "https://[::1]:8443/deployments?ready=false"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/deployments?ready=false", nil)
+		req.Host = tt.host
+		rr := httptest.NewRecorder()
+		httpsRedirectHandler(tt.port)(rr, req)
+		if rr.Code != http.StatusPermanentRedirect || rr.Header().Get("Location") != tt.expected {
+			t.Errorf("%s: expected 308 to %s, got %d to %s", tt.host, tt.expected, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+}
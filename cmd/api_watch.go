@@ -0,0 +1,427 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	"golang.org/x/net/websocket"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// watchSubscriberQueue is how many events a slow client may fall behind
+	// before its stream is closed and it has to resume
+	watchSubscriberQueue = 256
+)
+
+var (
+	// apiWatch fans the informer events out to the watch streams
+	apiWatch *watchBroadcaster
+	// apiWatchBuffer is how many recent events a reconnecting client can resume from
+	apiWatchBuffer int
+	// watchHeartbeatInterval is how often idle streams send a heartbeat
+	watchHeartbeatInterval = 15 * time.Second
+)
+
+// watchEvent is a deployment change as kept in the resume buffer
+type watchEvent struct {
+	seq        uint64
+	eventType  watch.EventType
+	deployment *appsv1.Deployment
+	// old is the previous state of a MODIFIED deployment, so that filtered
+	// streams can tell deployments entering and leaving the filter
+	old *appsv1.Deployment
+}
+
+// watchSubscriber is the queue of one open stream
+type watchSubscriber struct {
+	events chan watchEvent
+}
+
+// watchBroadcaster numbers the informer events, keeps the most recent ones
+// for resuming clients and hands them to the open streams
+type watchBroadcaster struct {
+	// epoch tells event IDs of this process from those of an earlier one
+	epoch string
+
+	mu          sync.Mutex
+	seq         uint64
+	buffer      []watchEvent
+	size        int
+	subscribers map[*watchSubscriber]bool
+}
+
+func newWatchBroadcaster(size int) *watchBroadcaster {
+	return &watchBroadcaster{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:        size,
+		subscribers: make(map[*watchSubscriber]bool),
+	}
+}
+
+// eventID renders a sequence number as a stream event ID
+func (b *watchBroadcaster) eventID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", b.epoch, seq)
+}
+
+// parseEventID returns the sequence number of an event ID of this process
+func (b *watchBroadcaster) parseEventID(id string) (uint64, bool) {
+	epoch, seq, found := strings.Cut(id, "-")
+	if !found || epoch != b.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
+
+// handler returns the informer event handler that feeds the broadcaster
+func (b *watchBroadcaster) handler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if d, ok := obj.(*appsv1.Deployment); ok {
+				b.publish(watchEvent{eventType: watch.Added, deployment: d})
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, ok1 := oldObj.(*appsv1.Deployment)
+			d, ok2 := newObj.(*appsv1.Deployment)
+			// Resyncs carry no change
+			if ok1 && ok2 && old.ResourceVersion != d.ResourceVersion {
+				b.publish(watchEvent{eventType: watch.Modified, deployment: d, old: old})
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if d, _, err := deploymentFromEvent(obj); err == nil {
+				b.publish(watchEvent{eventType: watch.Deleted, deployment: d})
+			}
+		},
+	}
+}
+
+// publish buffers an event and queues it for every stream. Streams that
+// cannot keep up are closed and resume from the buffer when they reconnect.
+func (b *watchBroadcaster) publish(event watchEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.seq = b.seq
+	b.buffer = append(b.buffer, event)
+	if len(b.buffer) > b.size {
+		b.buffer = append(b.buffer[:0:0], b.buffer[len(b.buffer)-b.size:]...)
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// subscribe opens a stream. A resuming stream gets the buffered events after
+// the given sequence number, unless some of them are no longer buffered, in
+// which case resumed is false. seq is the position the stream starts at.
+func (b *watchBroadcaster) subscribe(after uint64, resume bool) (sub *watchSubscriber, backlog []watchEvent, seq uint64, resumed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &watchSubscriber{events: make(chan watchEvent, watchSubscriberQueue)}
+	b.subscribers[sub] = true
+	if resume && after <= b.seq && (after == b.seq || (len(b.buffer) > 0 && after+1 >= b.buffer[0].seq)) {
+		for _, event := range b.buffer {
+			if event.seq > after {
+				backlog = append(backlog, event)
+			}
+		}
+		return sub, backlog, b.seq, true
+	}
+	return sub, nil, b.seq, false
+}
+
+// unsubscribe closes a stream the client has left
+func (b *watchBroadcaster) unsubscribe(sub *watchSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// watchStream sends the events matching a query to one client
+type watchStream struct {
+	query *deploymentQuery
+	send  func(api.WatchEvent) error
+	// heartbeat keeps an idle connection open
+	heartbeat func() error
+
+	// listed are the resourceVersions of the cached deployments the initial
+	// list was sent from, by namespace/name. The stream subscribes before it
+	// lists, so its first events may already be part of the list.
+	listed map[string]string
+}
+
+// run streams until the client leaves or falls too far behind. lastEventID is
+// the ID the client resumes from, empty for a new stream.
+func (s *watchStream) run(ctx context.Context, b *watchBroadcaster, lastEventID string) error {
+	after, resume := b.parseEventID(lastEventID)
+	sub, backlog, seq, resumed := b.subscribe(after, resume)
+	defer b.unsubscribe(sub)
+
+	if !resumed {
+		// New streams, and streams whose position is gone, start with the cache contents
+		if lastEventID != "" {
+			if err := s.send(api.WatchEvent{Type: api.EventReset}); err != nil {
+				return err
+			}
+		}
+		if err := s.sendInitial(b.eventID(seq)); err != nil {
+			return err
+		}
+	}
+	for _, event := range backlog {
+		if err := s.sendEvent(b, event); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(watchHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, open := <-sub.events:
+			if !open {
+				return fmt.Errorf("client fell more than %d events behind", watchSubscriberQueue)
+			}
+			if err := s.sendEvent(b, event); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := s.heartbeat(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendInitial sends an ADDED event for every matching cached deployment, then SYNCED
+func (s *watchStream) sendInitial(id string) error {
+	indexer, snapshot := apiCache()
+	matches, err := queryDeployments(indexer, s.query.filters)
+	if err != nil {
+		return err
+	}
+	s.listed = make(map[string]string)
+	for _, obj := range indexer.List() {
+		if d, ok := obj.(*appsv1.Deployment); ok {
+			s.listed[d.Namespace+"/"+d.Name] = d.ResourceVersion
+		}
+	}
+	page, _, _ := s.query.apply(matches)
+	for _, d := range page {
+		item := deploymentItem(d, snapshot != nil)
+		if err := s.send(api.WatchEvent{Type: watch.Added, Deployment: &item}); err != nil {
+			return err
+		}
+	}
+	return s.send(api.WatchEvent{ID: id, Type: api.EventSynced})
+}
+
+// sendEvent sends an event as seen through the query. A deployment that starts
+// or stops matching a filter is sent as ADDED or DELETED.
+func (s *watchStream) sendEvent(b *watchBroadcaster, event watchEvent) error {
+	if s.coveredByList(event) {
+		return nil
+	}
+	matches := s.query.matchesAll(event.deployment)
+	eventType := event.eventType
+	if event.old != nil {
+		matchedBefore := s.query.matchesAll(event.old)
+		switch {
+		case matches && !matchedBefore:
+			eventType = watch.Added
+		case !matches && matchedBefore:
+			eventType, matches = watch.Deleted, true
+		}
+	}
+	if !matches {
+		return nil
+	}
+	item := deploymentItem(event.deployment, false)
+	return s.send(api.WatchEvent{ID: b.eventID(event.seq), Type: eventType, Deployment: &item})
+}
+
+// coveredByList reports whether the initial list already contained the
+// event's version of the deployment, or a later one. Deletions are never
+// covered, since the list only holds deployments that still existed.
+func (s *watchStream) coveredByList(event watchEvent) bool {
+	if event.eventType == watch.Deleted {
+		return false
+	}
+	key := event.deployment.Namespace + "/" + event.deployment.Name
+	listed, ok := s.listed[key]
+	if !ok || listed == "" {
+		return false
+	}
+	if !resourceVersionAtMost(event.deployment.ResourceVersion, listed) {
+		// Every later event of the deployment is newer than the list
+		delete(s.listed, key)
+		return false
+	}
+	return true
+}
+
+// resourceVersionAtMost reports whether version is not newer than other.
+// The API server's resourceVersions are numbers; others only compare equal.
+func resourceVersionAtMost(version, other string) bool {
+	v, err1 := strconv.ParseUint(version, 10, 64)
+	o, err2 := strconv.ParseUint(other, 10, 64)
+	if err1 != nil || err2 != nil {
+		return version == other
+	}
+	return v <= o
+}
+
+// parseWatchQuery reads the list filters of a watch request. Paging does not apply to streams.
+func parseWatchQuery(r *http.Request) (*deploymentQuery, error) {
+	values := r.URL.Query()
+	for _, param := range []string{"limit", "continue", "sort"} {
+		if values.Has(param) {
+			return nil, fmt.Errorf("%s is not supported when watching", param)
+		}
+	}
+	values.Del("lastEventId")
+	return parseDeploymentQuery(values)
+}
+
+// watchDeploymentsHandler serves GET /deployments/watch as Server-Sent Events,
+// or as a WebSocket when the client asks to upgrade
+func watchDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+	query, err := parseWatchQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	query.allowed = namespaceAccess(r, "watch")
+	// Browsers resend the last ID as a header, other clients may pass it as a parameter
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		serveWatchWebSocket(w, r, query, lastEventID)
+		return
+	}
+	serveWatchSSE(w, r, query, lastEventID)
+}
+
+// serveWatchSSE streams events as text/event-stream
+func serveWatchSSE(w http.ResponseWriter, r *http.Request, query *deploymentQuery, lastEventID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming is not supported by this connection")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+	flusher.Flush()
+
+	stream := &watchStream{
+		query: query,
+		send: func(msg api.WatchEvent) error {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			if msg.ID != "" {
+				fmt.Fprintf(w, "id: %s\n", msg.ID)
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		},
+		heartbeat: func() error {
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		},
+	}
+	if err := stream.run(r.Context(), apiWatch, lastEventID); err != nil {
+		klog.V(2).Infof("Watch stream to %s ended: %v", r.RemoteAddr, err)
+	}
+}
+
+// checkWebSocketOrigin rejects browser pages of other origins while callers
+// are authenticated. Browsers send cookies and client certificates with the
+// WebSocket requests of any page, and the same-origin policy does not apply
+// to them, so another site could read the stream with the caller's identity.
+// Without an authenticator the stream is as public as the list endpoint.
+// Clients other than browsers usually send no Origin and are not affected.
+func checkWebSocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	config.Origin = origin
+	if apiAuthn == nil || origin == nil {
+		return nil
+	}
+	if origin.Host != r.Host {
+		return fmt.Errorf("origin %s is not allowed", origin)
+	}
+	return nil
+}
+
+// serveWatchWebSocket streams events as one JSON text message each
+func serveWatchWebSocket(w http.ResponseWriter, r *http.Request, query *deploymentQuery, lastEventID string) {
+	server := websocket.Server{
+		Handshake: checkWebSocketOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			// The client only sends to close the stream
+			go func() {
+				io.Copy(io.Discard, ws)
+				cancel()
+			}()
+
+			stream := &watchStream{
+				query:     query,
+				send:      func(msg api.WatchEvent) error { return websocket.JSON.Send(ws, msg) },
+				heartbeat: func() error { return websocket.JSON.Send(ws, api.WatchEvent{Type: api.EventHeartbeat}) },
+			}
+			if err := stream.run(ctx, apiWatch, lastEventID); err != nil {
+				klog.V(2).Infof("Watch stream to %s ended: %v", r.RemoteAddr, err)
+			}
+		},
+	}
+	server.ServeHTTP(w, r)
+}
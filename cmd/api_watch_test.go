@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	"golang.org/x/net/websocket"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestWatchBroadcaster_Resume(t *testing.T) {
+	b := newWatchBroadcaster(3)
+	for i := 0; i < 5; i++ {
+		b.publish(watchEvent{eventType: watch.Added, deployment: newTestDeployment("web", 1, 1)})
+	}
+
+	// Events 3 to 5 are buffered, so streams can resume from 2 on
+	tests := []struct {
+		after   uint64
+		resumed bool
+		backlog int
+	}{
+		{1, false, 0},
+		{2, true, 3},
+		{4, true, 1},
+		{5, true, 0},
+		{9, false, 0},
+	}
+	for _, tt := range tests {
+		sub, backlog, seq, resumed := b.subscribe(tt.after, true)
+		if resumed != tt.resumed || len(backlog) != tt.backlog || seq != 5 {
+			t.Errorf("after %d: got resumed=%t, %d events, seq %d", tt.after, resumed, len(backlog), seq)
+		}
+		b.unsubscribe(sub)
+	}
+
+	if seq, ok := b.parseEventID(b.eventID(4)); !ok || seq != 4 {
+		t.Errorf("Expected to parse our own event ID, got %d %t", seq, ok)
+	}
+	if _, ok := b.parseEventID("earlier-4"); ok {
+		t.Error("Expected IDs of another process to be rejected")
+	}
+}
+
+func TestWatchBroadcaster_ClosesSlowSubscribers(t *testing.T) {
+	b := newWatchBroadcaster(10)
+	sub, _, _, _ := b.subscribe(0, false)
+	for i := 0; i <= watchSubscriberQueue; i++ {
+		b.publish(watchEvent{eventType: watch.Added, deployment: newTestDeployment("web", 1, 1)})
+	}
+	received := 0
+	for range sub.events {
+		received++
+	}
+	if received != watchSubscriberQueue {
+		t.Errorf("Expected the queue to be closed after %d events, got %d", watchSubscriberQueue, received)
+	}
+	b.unsubscribe(sub)
+}
+
+func TestWatchStream_FilterTransitions(t *testing.T) {
+	b := newWatchBroadcaster(10)
+	query, err := parseDeploymentQuery(map[string][]string{"ready": {"false"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	stream := &watchStream{query: query, send: func(msg api.WatchEvent) error {
+		sent = append(sent, string(msg.Type)+" "+msg.Deployment.Name)
+		return nil
+	}}
+
+	ready := newTestDeployment("web", 2, 2)
+	degraded := ready.DeepCopy()
+	degraded.Status.ReadyReplicas = 1
+	for _, event := range []watchEvent{
+		{eventType: watch.Added, deployment: ready},
+		{eventType: watch.Modified, deployment: degraded, old: ready},
+		{eventType: watch.Modified, deployment: degraded, old: degraded},
+		{eventType: watch.Modified, deployment: ready, old: degraded},
+	} {
+		if err := stream.sendEvent(b, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "ADDED web,MODIFIED web,DELETED web"
+	if got := strings.Join(sent, ","); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestWatchStream_SkipsEventsCoveredByInitialList(t *testing.T) {
+	// Each version has as many replicas as its resourceVersion, to tell them apart
+	web := newTestDeployment("web", 5, 5)
+	web.ResourceVersion = "5"
+	startTestAPI(t, web)
+	b := newWatchBroadcaster(10)
+	query, err := parseDeploymentQuery(map[string][]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	stream := &watchStream{query: query, send: func(msg api.WatchEvent) error {
+		if msg.Deployment != nil {
+			sent = append(sent, fmt.Sprintf("%s %s@%d", msg.Type, msg.Deployment.Name, msg.Deployment.Replicas))
+		}
+		return nil
+	}}
+	if err := stream.sendInitial(b.eventID(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events published between subscribing and listing are already in the list
+	version := func(rv int32) *appsv1.Deployment {
+		d := newTestDeployment("web", rv, rv)
+		d.ResourceVersion = fmt.Sprint(rv)
+		return d
+	}
+	for _, event := range []watchEvent{
+		{eventType: watch.Added, deployment: version(4)},
+		{eventType: watch.Modified, deployment: version(5), old: version(4)},
+		{eventType: watch.Modified, deployment: version(6), old: version(5)},
+		{eventType: watch.Deleted, deployment: version(6)},
+	} {
+		if err := stream.sendEvent(b, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "ADDED web@5,MODIFIED web@6,DELETED web@6"
+	if got := strings.Join(sent, ","); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+// sseReader reads the messages of an event stream
+type sseReader struct {
+	scanner *bufio.Scanner
+}
+
+// next returns the ID and message of the next event, skipping comments
+func (r *sseReader) next(t *testing.T) (string, api.WatchEvent) {
+	t.Helper()
+	var id string
+	var msg api.WatchEvent
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg); err != nil {
+				t.Fatalf("Invalid event data %q: %v", line, err)
+			}
+			return id, msg
+		}
+	}
+	t.Fatalf("Stream ended: %v", r.scanner.Err())
+	return "", msg
+}
+
+func openSSE(t *testing.T, url, lastEventID string) (*sseReader, func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+	return &sseReader{scanner: bufio.NewScanner(resp.Body)}, func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+func TestWatchDeploymentsHandler_SSE(t *testing.T) {
+	client := startTestAPI(t, newTestDeployment("web", 1, 1), newTestDeployment("api", 1, 0))
+	server := httptest.NewServer(http.HandlerFunc(watchDeploymentsHandler))
+	defer server.Close()
+	url := server.URL + "/deployments/watch?ready=true"
+
+	stream, stop := openSSE(t, url, "")
+	if _, msg := stream.next(t); msg.Type != watch.Added || msg.Deployment.Name != "web" {
+		t.Fatalf("Expected the matching deployment first, got %+v", msg)
+	}
+	id, msg := stream.next(t)
+	if msg.Type != api.EventSynced || id == "" {
+		t.Fatalf("Expected SYNCED with an ID, got %q %+v", id, msg)
+	}
+
+	// api becomes ready and enters the filter
+	ready, _ := client.AppsV1().Deployments("default").Get(context.TODO(), "api", metav1.GetOptions{})
+	ready.Status.ReadyReplicas = 1
+	ready.ResourceVersion = "2"
+	client.AppsV1().Deployments("default").UpdateStatus(context.TODO(), ready, metav1.UpdateOptions{})
+	id, msg = stream.next(t)
+	if msg.Type != watch.Added || msg.Deployment.Name != "api" {
+		t.Fatalf("Expected api to be ADDED, got %+v", msg)
+	}
+	stop()
+
+	// A change while disconnected is replayed on resume, without the initial list
+	client.AppsV1().Deployments("default").Delete(context.TODO(), "web", metav1.DeleteOptions{})
+	waitFor(t, func() bool {
+		apiWatch.mu.Lock()
+		defer apiWatch.mu.Unlock()
+		return apiWatch.seq >= 4
+	})
+	stream, stop = openSSE(t, url, id)
+	if _, msg := stream.next(t); msg.Type != watch.Deleted || msg.Deployment.Name != "web" {
+		t.Errorf("Expected the missed DELETED event, got %+v", msg)
+	}
+	stop()
+
+	// An unknown position starts over
+	stream, stop = openSSE(t, url, "previous-process-7")
+	defer stop()
+	if _, msg := stream.next(t); msg.Type != api.EventReset {
+		t.Errorf("Expected RESET, got %+v", msg)
+	}
+	if _, msg := stream.next(t); msg.Type != watch.Added || msg.Deployment.Name != "api" {
+		t.Errorf("Expected the current deployments after RESET, got %+v", msg)
+	}
+}
+
+func TestWatchDeploymentsHandler_WebSocket(t *testing.T) {
+	previous := watchHeartbeatInterval
+	watchHeartbeatInterval = 20 * time.Millisecond
+	defer func() { watchHeartbeatInterval = previous }()
+
+	startTestAPI(t, newTestDeployment("web", 1, 1))
+	server := httptest.NewServer(http.HandlerFunc(watchDeploymentsHandler))
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/deployments/watch", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	var types []string
+	for len(types) < 3 {
+		var msg api.WatchEvent
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, string(msg.Type))
+	}
+	if got := strings.Join(types, ","); got != "ADDED,SYNCED,HEARTBEAT" {
+		t.Errorf("Unexpected messages %s", got)
+	}
+}
+
+func TestWatchDeploymentsHandler_WebSocketOrigin(t *testing.T) {
+	startTestAPI(t, newTestDeployment("web", 1, 1))
+	server := httptest.NewServer(http.HandlerFunc(watchDeploymentsHandler))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/deployments/watch"
+
+	// Any origin may read the stream while callers are not authenticated
+	ws, err := websocket.Dial(url, "", "https://dashboard.example.com")
+	if err != nil {
+		t.Fatalf("Expected any origin without an authenticator, got %v", err)
+	}
+	ws.Close()
+
+	previousAuthn := apiAuthn
+	t.Cleanup(func() { apiAuthn = previousAuthn })
+	apiAuthn = unionAuthenticator{}
+	if _, err := websocket.Dial(url, "", "https://dashboard.example.com"); err == nil {
+		t.Error("Expected another origin to be rejected with an authenticator")
+	}
+	ws, err = websocket.Dial(url, "", server.URL)
+	if err != nil {
+		t.Fatalf("Expected the api's own origin to be accepted, got %v", err)
+	}
+	ws.Close()
+}
+
+func TestWatchDeploymentsHandler_RejectsPaging(t *testing.T) {
+	startTestAPI(t)
+	rr := httptest.NewRecorder()
+	watchDeploymentsHandler(rr, httptest.NewRequest("GET", "/deployments/watch?limit=10", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rr.Code)
+	}
+}
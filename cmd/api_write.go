@@ -0,0 +1,386 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// maxRequestBody limits the size of request bodies of the write endpoints
+const maxRequestBody = 1 << 20
+
+// deploymentRoutes maps the action below /deployments/{namespace}/{name} and
+// the method to their handlers. The empty action is the deployment itself.
+var deploymentRoutes = map[string]map[string]http.HandlerFunc{
+	"": {
+		http.MethodGet:    requireReader("get", deploymentDetailHandler),
+		http.MethodDelete: requireWriter("delete", "", deleteDeploymentHandler),
+	},
+	"scale":   {http.MethodPatch: requireWriter("patch", "scale", scaleDeploymentHandler)},
+	"restart": {http.MethodPost: requireWriter("patch", "", restartDeploymentHandler)},
+	"image":   {http.MethodPut: requireWriter("patch", "", setImageHandler)},
+
+	"pods":        {http.MethodGet: requireRelatedReader("", "pods", relatedHandler("pods", listRelatedPods))},
+	"replicasets": {http.MethodGet: requireRelatedReader("apps", "replicasets", relatedHandler("replicasets", listRelatedReplicaSets))},
+	"services":    {http.MethodGet: requireRelatedReader("", "services", relatedHandler("services", listRelatedServices))},
+	"events":      {http.MethodGet: requireRelatedReader("", "events", relatedHandler("events", listRelatedEvents))},
+}
+
+// deploymentsHandler serves /deployments: GET lists, POST creates
+func deploymentsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		requireReader("list", listDeploymentsHandler)(w, r)
+	case http.MethodPost:
+		requireWriter("create", "", createDeploymentHandler)(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+// deploymentHandler routes /deployments/{namespace}/{name}[/{action}] through deploymentRoutes
+func deploymentHandler(w http.ResponseWriter, r *http.Request) {
+	_, _, action, ok := parseDeploymentPath(r.URL.Path)
+	methods, found := deploymentRoutes[action]
+	if !ok || !found {
+		writeJSONError(w, http.StatusNotFound, "not found: %s", r.URL.Path)
+		return
+	}
+	handler, allowed := methods[r.Method]
+	if !allowed {
+		var allow []string
+		for method := range methods {
+			allow = append(allow, method)
+		}
+		sort.Strings(allow)
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		writeJSONError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+	handler(w, r)
+}
+
+// parseDeploymentPath splits /deployments/{namespace}/{name}[/{action}]
+func parseDeploymentPath(path string) (namespace, name, action string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/deployments/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", false
+	}
+	if len(parts) == 3 {
+		if parts[2] == "" {
+			return "", "", "", false
+		}
+		action = parts[2]
+	}
+	return parts[0], parts[1], action, true
+}
+
+// apiServesNamespace reports whether the api caches, and so may change, deployments of namespace
+func apiServesNamespace(namespace string) bool {
+	if apiInformerOpts.allNamespaces {
+		return true
+	}
+	for _, ns := range apiInformerOpts.watchedNamespaces() {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// apiSelects reports whether a deployment matches the --label-selector and
+// --field-selector of the api. The api does not serve deployments outside
+// them, so it does not change them either.
+func apiSelects(d *appsv1.Deployment) bool {
+	labelSelector, err := labels.Parse(apiInformerOpts.labelSelector)
+	if err != nil || !labelSelector.Matches(labels.Set(d.Labels)) {
+		return false
+	}
+	fieldSelector, err := fields.ParseSelector(apiInformerOpts.fieldSelector)
+	if err != nil {
+		return false
+	}
+	return fieldSelector.Matches(fields.Set{"metadata.name": d.Name, "metadata.namespace": d.Namespace})
+}
+
+// parseWriteOptions reads the dryRun parameter and the If-Match header. If-Match
+// carries the resourceVersion the change is based on, quoted or not.
+func parseWriteOptions(r *http.Request) (writeOptions, error) {
+	var opts writeOptions
+	switch dryRun := r.URL.Query().Get("dryRun"); dryRun {
+	case "", "false":
+	case "true", metav1.DryRunAll:
+		opts.dryRun = true
+	default:
+		return opts, fmt.Errorf("invalid dryRun %q, expected true or All", dryRun)
+	}
+	if match := r.Header.Get("If-Match"); match != "" && match != "*" {
+		opts.resourceVersion = strings.Trim(strings.TrimPrefix(match, "W/"), `"`)
+	}
+	return opts, nil
+}
+
+// writeTarget checks the path and options of a request that changes an
+// existing deployment, and that the deployment is one the api serves
+func writeTarget(w http.ResponseWriter, r *http.Request) (namespace, name string, opts writeOptions, ok bool) {
+	namespace, name, _, _ = parseDeploymentPath(r.URL.Path)
+	if !apiServesNamespace(namespace) {
+		writeJSONError(w, http.StatusForbidden, "namespace %s is not served by this api", namespace)
+		return "", "", opts, false
+	}
+	opts, err := parseWriteOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "%v", err)
+		return "", "", opts, false
+	}
+	// The cache only holds selected deployments, so the live object is checked
+	if apiInformerOpts.labelSelector != "" || apiInformerOpts.fieldSelector != "" {
+		current, err := apiClient.AppsV1().Deployments(namespace).Get(r.Context(), name, metav1.GetOptions{})
+		if err != nil {
+			writeKubernetesError(w, err)
+			return "", "", opts, false
+		}
+		if !apiSelects(current) {
+			writeJSONError(w, http.StatusForbidden, "deployment %s/%s is not selected by this api", namespace, name)
+			return "", "", opts, false
+		}
+		// The change must apply to the version that was checked, so that a
+		// label change in between cannot move it out of the selector
+		if opts.resourceVersion == "" {
+			opts.resourceVersion = current.ResourceVersion
+		}
+	}
+	return namespace, name, opts, true
+}
+
+// decodeJSONBody strictly decodes a small JSON request body into v
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return false
+	}
+	return true
+}
+
+// writeKubernetesError passes on the status of an API server error
+func writeKubernetesError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Code != 0 {
+		code = int(status.Status().Code)
+	}
+	writeJSONError(w, code, "%v", err)
+}
+
+// writeChangeError reports a failed change of an existing deployment. A
+// conflict with the resourceVersion the change was based on is a failed
+// precondition.
+func writeChangeError(w http.ResponseWriter, err error, opts writeOptions) {
+	if opts.resourceVersion != "" && apierrors.IsConflict(err) {
+		writeJSONError(w, http.StatusPreconditionFailed, "%v", err)
+		return
+	}
+	writeKubernetesError(w, err)
+}
+
+// writeDeployment responds with the detail of a changed deployment
+func writeDeployment(w http.ResponseWriter, status int, d *appsv1.Deployment) {
+	detail, err := describeDeployment(d, apiReplicaSets, apiPods)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(detail)
+}
+
+// createDeploymentHandler serves POST /deployments with a JSON or YAML manifest
+func createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseWriteOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	var deployment appsv1.Deployment
+	decoder := utilyaml.NewYAMLOrJSONDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody), 4096)
+	if err := decoder.Decode(&deployment); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid manifest: %v", err)
+		return
+	}
+
+	if deployment.Namespace == "" {
+		deployment.Namespace = apiInformerOpts.watchedNamespaces()[0]
+		if deployment.Namespace == metav1.NamespaceAll {
+			deployment.Namespace = metav1.NamespaceDefault
+		}
+	}
+	if !apiServesNamespace(deployment.Namespace) {
+		writeJSONError(w, http.StatusForbidden, "namespace %s is not served by this api", deployment.Namespace)
+		return
+	}
+	if !apiSelects(&deployment) {
+		writeJSONError(w, http.StatusForbidden, "deployment %s/%s is not selected by this api", deployment.Namespace, deployment.Name)
+		return
+	}
+	if !authorizeRequest(w, r, resourceAttributes{verb: "create", namespace: deployment.Namespace, name: deployment.Name}) {
+		return
+	}
+	if errs := validateDeployment(&deployment); len(errs) > 0 {
+		writeKubernetesError(w, invalidDeployment(deployment.Name, errs...))
+		return
+	}
+
+	created, err := createDeployment(r.Context(), apiClient, &deployment, opts)
+	if err != nil {
+		writeKubernetesError(w, err)
+		return
+	}
+	w.Header().Set("Location", deploymentPath(created.Namespace, created.Name))
+	writeDeployment(w, http.StatusCreated, created)
+}
+
+// validateDeployment catches the common mistakes in a manifest before it is sent
+func validateDeployment(d *appsv1.Deployment) field.ErrorList {
+	var errs field.ErrorList
+	if d.APIVersion != "" && d.APIVersion != appsv1.SchemeGroupVersion.String() {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), d.APIVersion, []string{appsv1.SchemeGroupVersion.String()}))
+	}
+	if d.Kind != "" && d.Kind != "Deployment" {
+		errs = append(errs, field.NotSupported(field.NewPath("kind"), d.Kind, []string{"Deployment"}))
+	}
+
+	metadata := field.NewPath("metadata")
+	switch {
+	case d.Name == "" && d.GenerateName == "":
+		errs = append(errs, field.Required(metadata.Child("name"), "name or generateName is required"))
+	case d.Name != "":
+		for _, msg := range validation.IsDNS1123Subdomain(d.Name) {
+			errs = append(errs, field.Invalid(metadata.Child("name"), d.Name, msg))
+		}
+	}
+	if d.ResourceVersion != "" {
+		errs = append(errs, field.Forbidden(metadata.Child("resourceVersion"), "must not be set when creating"))
+	}
+
+	spec := field.NewPath("spec")
+	if d.Spec.Replicas != nil && *d.Spec.Replicas < 0 {
+		errs = append(errs, field.Invalid(spec.Child("replicas"), *d.Spec.Replicas, "must not be negative"))
+	}
+	if d.Spec.Selector == nil || (len(d.Spec.Selector.MatchLabels) == 0 && len(d.Spec.Selector.MatchExpressions) == 0) {
+		errs = append(errs, field.Required(spec.Child("selector"), ""))
+	} else if selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector); err != nil {
+		errs = append(errs, field.Invalid(spec.Child("selector"), d.Spec.Selector, err.Error()))
+	} else if !selector.Matches(labels.Set(d.Spec.Template.Labels)) {
+		errs = append(errs, field.Invalid(spec.Child("template", "metadata", "labels"), d.Spec.Template.Labels,
+			"must match the selector "+selector.String()))
+	}
+
+	containers := spec.Child("template", "spec", "containers")
+	if len(d.Spec.Template.Spec.Containers) == 0 {
+		errs = append(errs, field.Required(containers, "at least one container is required"))
+	}
+	for i, c := range d.Spec.Template.Spec.Containers {
+		if c.Name == "" {
+			errs = append(errs, field.Required(containers.Index(i).Child("name"), ""))
+		}
+		if c.Image == "" {
+			errs = append(errs, field.Required(containers.Index(i).Child("image"), ""))
+		}
+	}
+	return errs
+}
+
+// scaleDeploymentHandler serves PATCH /deployments/{namespace}/{name}/scale
+func scaleDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, name, opts, ok := writeTarget(w, r)
+	if !ok {
+		return
+	}
+	var req api.ScaleRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	path := field.NewPath("replicas")
+	if req.Replicas == nil {
+		writeKubernetesError(w, invalidDeployment(name, field.Required(path, "")))
+		return
+	}
+	if *req.Replicas < 0 {
+		writeKubernetesError(w, invalidDeployment(name, field.Invalid(path, *req.Replicas, "must not be negative")))
+		return
+	}
+
+	deployment, err := scaleDeployment(r.Context(), apiClient, namespace, name, *req.Replicas, opts)
+	if err != nil {
+		writeChangeError(w, err, opts)
+		return
+	}
+	writeDeployment(w, http.StatusOK, deployment)
+}
+
+// restartDeploymentHandler serves POST /deployments/{namespace}/{name}/restart
+func restartDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, name, opts, ok := writeTarget(w, r)
+	if !ok {
+		return
+	}
+	deployment, err := restartDeployment(r.Context(), apiClient, namespace, name, opts)
+	if err != nil {
+		writeChangeError(w, err, opts)
+		return
+	}
+	writeDeployment(w, http.StatusOK, deployment)
+}
+
+// setImageHandler serves PUT /deployments/{namespace}/{name}/image
+func setImageHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, name, opts, ok := writeTarget(w, r)
+	if !ok {
+		return
+	}
+	var req api.ImageRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Image) == "" {
+		writeKubernetesError(w, invalidDeployment(name, field.Required(field.NewPath("image"), "")))
+		return
+	}
+
+	deployment, err := setDeploymentImage(r.Context(), apiClient, namespace, name, req.Container, req.Image, opts)
+	if err != nil {
+		writeChangeError(w, err, opts)
+		return
+	}
+	writeDeployment(w, http.StatusOK, deployment)
+}
+
+// deleteDeploymentHandler serves DELETE /deployments/{namespace}/{name}
+func deleteDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, name, opts, ok := writeTarget(w, r)
+	if !ok {
+		return
+	}
+	if err := deleteDeployment(r.Context(), apiClient, namespace, name, opts); err != nil {
+		writeChangeError(w, err, opts)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testWriteToken = "s3cret"
+
+// enableWrites installs the shared token authenticator for the test
+func enableWrites(t *testing.T) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(testWriteToken+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := newSharedTokenAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := apiAuthn
+	apiAuthn = auth
+	t.Cleanup(func() { apiAuthn = previous })
+}
+
+// serveWrite sends an authenticated request through the api routes
+func serveWrite(method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testWriteToken)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	if target == "/deployments" || strings.HasPrefix(target, "/deployments?") {
+		deploymentsHandler(rr, req)
+	} else {
+		deploymentHandler(rr, req)
+	}
+	return rr
+}
+
+func TestWriteEndpoints_Authentication(t *testing.T) {
+	startTestAPI(t, newTestDeployment("web", 1, 1))
+
+	rr := serveWrite("POST", "/deployments/default/web/restart", "")
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 while writes are disabled, got %d", rr.Code)
+	}
+
+	enableWrites(t)
+	for _, authorization := range []string{"", "Bearer wrong", "Basic czNjcmV0"} {
+		req := httptest.NewRequest("POST", "/deployments/default/web/restart", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rr := httptest.NewRecorder()
+		deploymentHandler(rr, req)
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%q: expected 401 with a challenge, got %d", authorization, rr.Code)
+		}
+	}
+
+	// Reads stay open
+	rr = httptest.NewRecorder()
+	deploymentHandler(rr, httptest.NewRequest("GET", "/deployments/default/web", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected reads without a token, got %d", rr.Code)
+	}
+}
+
+func TestCreateDeploymentHandler(t *testing.T) {
+	client := startTestAPI(t)
+	enableWrites(t)
+
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  selector:
+    matchLabels: {app: web}
+  template:
+    metadata:
+      labels: {app: web}
+    spec:
+      containers:
+      - name: web
+        image: nginx:1.25
+`
+	rr := serveWrite("POST", "/deployments", manifest, "Content-Type", "application/yaml")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Location") != "/deployments/default/web" {
+		t.Errorf("Unexpected Location %q", rr.Header().Get("Location"))
+	}
+	created, err := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil || *created.Spec.Replicas != 2 {
+		t.Errorf("Expected the deployment to be created in the api namespace, got %v", err)
+	}
+
+	rr = serveWrite("POST", "/deployments", `{"metadata":{"name":"Bad_Name"},"spec":{"replicas":-1,"selector":{"matchLabels":{"app":"x"}},"template":{"metadata":{"labels":{"app":"y"}}}}}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for _, field := range []string{"metadata.name", "spec.replicas", "spec.template.metadata.labels", "spec.template.spec.containers"} {
+		if !strings.Contains(rr.Body.String(), field) {
+			t.Errorf("Expected an error for %s, got %s", field, rr.Body.String())
+		}
+	}
+
+	rr = serveWrite("POST", "/deployments", strings.Replace(manifest, "name: web\nspec", "name: web\n  namespace: kube-system\nspec", 1))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a namespace the api does not serve, got %d", rr.Code)
+	}
+
+	rr = serveWrite("POST", "/deployments", "{not json")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unreadable manifest, got %d", rr.Code)
+	}
+}
+
+func TestScaleDeploymentHandler_IfMatch(t *testing.T) {
+	deployment := newTestDeployment("web", 1, 1)
+	deployment.ResourceVersion = "41"
+	client := startTestAPI(t, deployment)
+	enableWrites(t)
+
+	var patch map[string]interface{}
+	client.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch = nil
+		json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &patch)
+		if patch["metadata"] != nil {
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "web",
+				errors.New("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+
+	rr := serveWrite("PATCH", "/deployments/default/web/scale", `{"replicas":4}`, "If-Match", `"40"`)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected 412 for a stale resourceVersion, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rv := patch["metadata"].(map[string]interface{})["resourceVersion"]; rv != "40" {
+		t.Errorf("Expected the resourceVersion in the patch, got %v", rv)
+	}
+
+	rr = serveWrite("PATCH", "/deployments/default/web/scale", `{"replicas":4}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var detail api.DeploymentDetail
+	json.Unmarshal(rr.Body.Bytes(), &detail)
+	if detail.Replicas.Desired != 4 {
+		t.Errorf("Expected 4 desired replicas, got %+v", detail.Replicas)
+	}
+
+	for _, body := range []string{`{}`, `{"replicas":-1}`} {
+		if rr := serveWrite("PATCH", "/deployments/default/web/scale", body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected 422, got %d", body, rr.Code)
+		}
+	}
+	if rr := serveWrite("PATCH", "/deployments/default/web/scale", `{"replica":4}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown field, got %d", rr.Code)
+	}
+	if rr := serveWrite("PATCH", "/deployments/default/missing/scale", `{"replicas":4}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown deployment, got %d", rr.Code)
+	}
+}
+
+func TestSetImageAndRestartHandlers(t *testing.T) {
+	client := startTestAPI(t, newTestDeployment("web", 1, 1))
+	enableWrites(t)
+
+	if rr := serveWrite("PUT", "/deployments/default/web/image", `{"container":"sidecar","image":"nginx:1.26"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an unknown container, got %d", rr.Code)
+	}
+	rr := serveWrite("PUT", "/deployments/default/web/image", `{"image":"nginx:1.26"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = serveWrite("POST", "/deployments/default/web/restart", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	updated, _ := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	if image := updated.Spec.Template.Spec.Containers; len(image) != 1 || image[0].Image != "nginx:1.26" {
+		t.Errorf("Expected the single container to run nginx:1.26, got %+v", image)
+	}
+	if updated.Spec.Template.Annotations[restartedAtAnnotation] == "" {
+		t.Error("Expected the restart annotation to be set")
+	}
+}
+
+func TestDeleteDeploymentHandler_DryRun(t *testing.T) {
+	client := startTestAPI(t, newTestDeployment("web", 1, 1))
+	enableWrites(t)
+
+	var options metav1.DeleteOptions
+	client.PrependReactor("delete", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		options = action.(k8stesting.DeleteAction).GetDeleteOptions()
+		// The API server validates a dry-run delete without deleting
+		return len(options.DryRun) > 0, nil, nil
+	})
+
+	rr := serveWrite("DELETE", "/deployments/default/web?dryRun=true", "", "If-Match", "7")
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(options.DryRun) != 1 || options.DryRun[0] != metav1.DryRunAll {
+		t.Errorf("Expected a dry-run delete, got %v", options.DryRun)
+	}
+	if options.Preconditions == nil || *options.Preconditions.ResourceVersion != "7" {
+		t.Errorf("Expected a resourceVersion precondition, got %+v", options.Preconditions)
+	}
+	if _, err := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the dry run to keep the deployment, got %v", err)
+	}
+
+	if rr := serveWrite("DELETE", "/deployments/default/web?dryRun=maybe", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid dryRun, got %d", rr.Code)
+	}
+	if rr := serveWrite("DELETE", "/deployments/default/web", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rr.Code)
+	}
+	if _, err := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected the deployment to be deleted, got %v", err)
+	}
+}
+
+func TestWriteEndpoints_Selectors(t *testing.T) {
+	selected := newTestDeployment("web", 1, 1)
+	selected.Labels = map[string]string{"team": "storefront"}
+	other := newTestDeployment("api", 1, 1)
+	other.Labels = map[string]string{"team": "payments"}
+	client := startTestAPIWithOptions(t, informerOptions{namespaces: []string{"default"}, labelSelector: "team=storefront"}, selected, other)
+	enableWrites(t)
+
+	for _, tt := range []struct{ method, target, body string }{
+		{"PATCH", "/deployments/default/api/scale", `{"replicas":3}`},
+		{"POST", "/deployments/default/api/restart", ""},
+		{"PUT", "/deployments/default/api/image", `{"image":"nginx:1.26"}`},
+		{"DELETE", "/deployments/default/api", ""},
+	} {
+		if rr := serveWrite(tt.method, tt.target, tt.body); rr.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected 403 outside the label selector, got %d", tt.method, tt.target, rr.Code)
+		}
+	}
+	if d, err := client.AppsV1().Deployments("default").Get(context.TODO(), "api", metav1.GetOptions{}); err != nil || *d.Spec.Replicas != 1 {
+		t.Errorf("Expected the unselected deployment to be unchanged, got %v", err)
+	}
+	if rr := serveWrite("PATCH", "/deployments/default/web/scale", `{"replicas":3}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected the selected deployment to scale, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The change is based on the version whose labels were checked
+	client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		current := selected.DeepCopy()
+		current.ResourceVersion = "7"
+		return true, current, nil
+	})
+	var patch map[string]interface{}
+	client.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &patch)
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "web",
+			errors.New("the object has been modified"))
+	})
+	if rr := serveWrite("PATCH", "/deployments/default/web/scale", `{"replicas":4}`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 when the deployment changed since the check, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if metadata, _ := patch["metadata"].(map[string]interface{}); metadata["resourceVersion"] != "7" {
+		t.Errorf("Expected the checked resourceVersion in the patch, got %v", patch["metadata"])
+	}
+
+	manifest := `{"metadata":{"name":"worker","labels":{"team":"%s"}},"spec":{"selector":{"matchLabels":{"app":"worker"}},` +
+		`"template":{"metadata":{"labels":{"app":"worker"}},"spec":{"containers":[{"name":"worker","image":"busybox"}]}}}}`
+	if rr := serveWrite("POST", "/deployments", fmt.Sprintf(manifest, "payments")); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 creating outside the label selector, got %d", rr.Code)
+	}
+	if rr := serveWrite("POST", "/deployments", fmt.Sprintf(manifest, "storefront")); rr.Code != http.StatusCreated {
+		t.Errorf("Expected 201 creating inside the label selector, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeploymentHandler_Routing(t *testing.T) {
+	startTestAPI(t)
+	enableWrites(t)
+
+	if rr := serveWrite("POST", "/deployments/default/web/rollout", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown action, got %d", rr.Code)
+	}
+	rr := serveWrite("POST", "/deployments/default/web/scale", "")
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "PATCH" {
+		t.Errorf("Expected 405 allowing PATCH, got %d %q", rr.Code, rr.Header().Get("Allow"))
+	}
+	if rr := serveWrite("PUT", "/deployments", ""); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rr.Code)
+	}
+	if rr := serveWrite("PATCH", "/deployments/kube-system/dns/scale", `{"replicas":1}`); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a namespace the api does not serve, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// eventCoalescer merges the updates of an object within a window into one
+// event carrying the update count and the net diff from the first old object
+// to the last new one. ADDED and DELETED events are not delayed; they flush
+// the object's pending updates first so the order of events is kept.
+type eventCoalescer struct {
+	window time.Duration
+	emit   func(informerEvent)
+
+	mu      sync.Mutex
+	pending map[string]*coalescedUpdates
+}
+
+// coalescedUpdates are the updates of one object collected in the current window
+type coalescedUpdates struct {
+	first informerEvent
+	last  informerEvent
+	count int
+	timer *time.Timer
+}
+
+// newEventCoalescer creates a coalescer that passes merged events to emit
+func newEventCoalescer(window time.Duration, emit func(informerEvent)) *eventCoalescer {
+	return &eventCoalescer{
+		window:  window,
+		emit:    emit,
+		pending: make(map[string]*coalescedUpdates),
+	}
+}
+
+// add collects an event; it is safe to call from informer handlers
+func (c *eventCoalescer) add(event informerEvent) {
+	// Events are emitted under the lock so a timer flush cannot overtake them
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addLocked(event)
+}
+
+// addLocked collects an event; c.mu must be held
+func (c *eventCoalescer) addLocked(event informerEvent) {
+	key := event.Kind + "/" + event.Namespace + "/" + event.Name
+	if event.Type != eventUpdated {
+		c.flushLocked(key)
+		c.emit(event)
+		return
+	}
+
+	if updates, ok := c.pending[key]; ok {
+		updates.last = event
+		updates.count++
+		return
+	}
+	updates := &coalescedUpdates{first: event, last: event, count: 1}
+	updates.timer = time.AfterFunc(c.window, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// The updates may have been flushed while the timer fired, and the
+		// key may already collect a new window
+		if c.pending[key] == updates {
+			c.flushLocked(key)
+		}
+	})
+	c.pending[key] = updates
+}
+
+// flush emits all pending updates, on shutdown
+func (c *eventCoalescer) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.pending))
+	for key := range c.pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		c.flushLocked(key)
+	}
+}
+
+// flushLocked emits the pending updates of key, if any; c.mu must be held
+func (c *eventCoalescer) flushLocked(key string) {
+	updates, ok := c.pending[key]
+	if !ok {
+		return
+	}
+	delete(c.pending, key)
+	updates.timer.Stop()
+	c.emit(updates.merge())
+}
+
+// merge returns the single event that stands for the collected updates
+func (u *coalescedUpdates) merge() informerEvent {
+	if u.count == 1 {
+		return u.first
+	}
+
+	event := u.last
+	event.Updates = u.count
+	event.previous = u.first.previous
+	event.Changes = nil
+	if event.diff != nil && event.previous != nil {
+		for _, change := range event.diff(event.previous, event.Object) {
+			event.Changes = append(event.Changes, change.String())
+		}
+	}
+	return event
+}
+
+// namespaceTotals counts the deployments of one namespace by readiness
+type namespaceTotals struct {
+	namespace string
+	ready     int
+	unready   int
+}
+
+// String renders the totals, e.g. "default: 12 deployments, 10 ready, 2 unready"
+func (t namespaceTotals) String() string {
+	return fmt.Sprintf("%s: %d deployments, %d ready, %d unready", t.namespace, t.ready+t.unready, t.ready, t.unready)
+}
+
+// summarizeDeployments totals the cached deployments per namespace, sorted by
+// namespace. Deployments scaled to zero count as ready.
+func summarizeDeployments(stores ...cache.Store) []namespaceTotals {
+	byNamespace := make(map[string]*namespaceTotals)
+	for _, store := range stores {
+		for _, obj := range store.List() {
+			d, _, err := deploymentFromEvent(obj)
+			if err != nil {
+				continue
+			}
+			totals, ok := byNamespace[d.Namespace]
+			if !ok {
+				totals = &namespaceTotals{namespace: d.Namespace}
+				byNamespace[d.Namespace] = totals
+			}
+			switch readinessState(d) {
+			case readinessReady, readinessScaledDown:
+				totals.ready++
+			default:
+				totals.unready++
+			}
+		}
+	}
+
+	summary := make([]namespaceTotals, 0, len(byNamespace))
+	for _, totals := range byNamespace {
+		summary = append(summary, *totals)
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].namespace < summary[j].namespace })
+	return summary
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// eventCollector records emitted event lines
+type eventCollector struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *eventCollector) emit(event informerEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, event.String())
+}
+
+func (c *eventCollector) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+// nextVersion returns a copy of d with a new resourceVersion, changed by mutate
+func nextVersion(d *appsv1.Deployment, version string, mutate func(*appsv1.Deployment)) *appsv1.Deployment {
+	next := d.DeepCopy()
+	next.ResourceVersion = version
+	mutate(next)
+	return next
+}
+
+func TestEventCoalescer_MergesUpdatesInWindow(t *testing.T) {
+	collector := &eventCollector{}
+	coalescer := newEventCoalescer(50*time.Millisecond, collector.emit)
+	handler := resourceEventHandler(watchableResources["deployments"], nil, coalescer.add)
+
+	v1 := newTestDeployment("web", 3, 3)
+	v1.ResourceVersion = "1"
+	v2 := nextVersion(v1, "2", func(d *appsv1.Deployment) { d.Spec.Replicas = int32Ptr(4) })
+	v3 := nextVersion(v2, "3", func(d *appsv1.Deployment) { d.Spec.Template.Spec.Containers[0].Image = "nginx:1.26" })
+	v4 := nextVersion(v3, "4", func(d *appsv1.Deployment) {
+		d.Spec.Replicas = int32Ptr(5)
+		d.Status.ReadyReplicas = 5
+	})
+
+	handler.OnAdd(v1, false)
+	handler.OnUpdate(v1, v2)
+	handler.OnUpdate(v2, v3)
+	handler.OnUpdate(v3, v4)
+
+	// ADDED is not delayed, the updates wait for the window
+	if lines := collector.get(); len(lines) != 1 || !strings.HasPrefix(lines[0], "Deployment ADDED") {
+		t.Fatalf("Expected only the ADDED event before the window ends, got %v", lines)
+	}
+
+	waitFor(t, func() bool { return len(collector.get()) == 2 })
+	expected := "Deployment UPDATED: default/web 3 updates, replicas: 3→5, image[web]: nginx:1.25→nginx:1.26, status.ready: 3→5"
+	if got := collector.get()[1]; got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestEventCoalescer_DeleteFlushesPendingUpdates(t *testing.T) {
+	collector := &eventCollector{}
+	coalescer := newEventCoalescer(time.Hour, collector.emit)
+	handler := resourceEventHandler(watchableResources["deployments"], nil, coalescer.add)
+
+	v1 := newTestDeployment("web", 3, 3)
+	v1.ResourceVersion = "1"
+	v2 := nextVersion(v1, "2", func(d *appsv1.Deployment) { d.Spec.Replicas = int32Ptr(4) })
+
+	handler.OnUpdate(v1, v2)
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "default/web", Obj: v2})
+
+	lines := collector.get()
+	if len(lines) != 2 {
+		t.Fatalf("Expected the update and the delete, got %v", lines)
+	}
+	// A single update is passed on unchanged
+	if lines[0] != "Deployment UPDATED: default/web replicas: 3→4" {
+		t.Errorf("Unexpected update line %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "Deployment DELETED: default/web") {
+		t.Errorf("Expected DELETED after the flushed update, got %q", lines[1])
+	}
+
+	// Nothing is left for the timer or a final flush
+	coalescer.flush()
+	if len(collector.get()) != 2 {
+		t.Errorf("Expected no further events, got %v", collector.get())
+	}
+}
+
+func TestEventCoalescer_StaleTimer(t *testing.T) {
+	collector := &eventCollector{}
+	coalescer := newEventCoalescer(10*time.Millisecond, collector.emit)
+	handler := resourceEventHandler(watchableResources["deployments"], nil, coalescer.add)
+
+	v1 := newTestDeployment("web", 3, 3)
+	v1.ResourceVersion = "1"
+	v2 := nextVersion(v1, "2", func(d *appsv1.Deployment) { d.Spec.Replicas = int32Ptr(4) })
+	v3 := nextVersion(v2, "3", func(d *appsv1.Deployment) { d.Spec.Replicas = int32Ptr(5) })
+	handler.OnUpdate(v1, v2)
+
+	// The timer of the first window fires while a delete flushes it and the
+	// next window starts
+	coalescer.mu.Lock()
+	time.Sleep(50 * time.Millisecond)
+	coalescer.window = time.Hour
+	coalescer.addLocked(newObjectEvent(eventDeleted, "Deployment", v2))
+	coalescer.addLocked(newObjectEvent(eventUpdated, "Deployment", v3))
+	coalescer.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	if lines := collector.get(); len(lines) != 2 {
+		t.Errorf("Expected the stale timer to leave the new window alone, got %v", lines)
+	}
+}
+
+func TestEventCoalescer_MetadataOnly(t *testing.T) {
+	collector := &eventCollector{}
+	coalescer := newEventCoalescer(time.Hour, collector.emit)
+	handler := resourceEventHandler(watchableResources["deployments"].metadataOnly(), nil, coalescer.add)
+
+	v1 := newTestDeployment("web", 3, 3)
+	v1.ResourceVersion = "1"
+	v2 := nextVersion(v1, "2", func(d *appsv1.Deployment) { d.Labels = map[string]string{"tier": "frontend"} })
+	v3 := nextVersion(v2, "3", func(d *appsv1.Deployment) { d.Labels["tier"] = "web" })
+	metadata := func(d *appsv1.Deployment) *metav1.PartialObjectMetadata { return meta.AsPartialObjectMetadata(d) }
+
+	handler.OnUpdate(metadata(v1), metadata(v2))
+	handler.OnUpdate(metadata(v2), metadata(v3))
+	coalescer.flush()
+
+	expected := []string{"Deployment UPDATED: default/web 2 updates, label.tier: +web"}
+	if got := collector.get(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestSummarizeDeployments(t *testing.T) {
+	production := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	staging := cache.NewStore(cache.MetaNamespaceKeyFunc)
+
+	for _, d := range []*appsv1.Deployment{
+		newTestDeployment("web", 3, 3),
+		newTestDeployment("api", 3, 1),
+		newTestDeployment("worker", 2, 0),
+	} {
+		d.Namespace = "production"
+		production.Add(d)
+	}
+	idle := newTestDeployment("idle", 0, 0)
+	idle.Namespace = "staging"
+	staging.Add(idle)
+
+	var lines []string
+	for _, totals := range summarizeDeployments(staging, production) {
+		lines = append(lines, totals.String())
+	}
+	expected := "production: 3 deployments, 1 ready, 2 unready|staging: 1 deployments, 1 ready, 0 unready"
+	if got := strings.Join(lines, "|"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// connectionState is the health of the connection to the API server as seen by the informers
+type connectionState string
+
+const (
+	stateConnected    connectionState = "connected"
+	stateDegraded     connectionState = "degraded"
+	stateDisconnected connectionState = "disconnected"
+)
+
+// connectionStates lists the states in order of severity
+var connectionStates = []connectionState{stateConnected, stateDegraded, stateDisconnected}
+
+// watchErrorReason classifies a list or watch error
+type watchErrorReason string
+
+const (
+	// reasonExpired means the resource version was compacted away; the reflector relists
+	reasonExpired watchErrorReason = "expired"
+	// reasonClosed means the watch ended without an error worth reporting
+	reasonClosed watchErrorReason = "closed"
+	// reasonForbidden means the credentials are rejected or lack RBAC permissions
+	reasonForbidden watchErrorReason = "forbidden"
+	// reasonNetwork means the API server could not be reached or the connection broke
+	reasonNetwork watchErrorReason = "network"
+	// reasonTooLargeResourceVersion means the API server is behind the resource version asked for
+	reasonTooLargeResourceVersion watchErrorReason = "too-large-resource-version"
+	reasonOther                   watchErrorReason = "other"
+)
+
+// disconnectAfter is the number of consecutive network errors of a resource
+// after which the connection counts as lost rather than degraded
+const disconnectAfter = 3
+
+// classifyWatchError tells apart the errors a reflector reports
+func classifyWatchError(err error) watchErrorReason {
+	switch {
+	case apierrors.IsResourceExpired(err) || apierrors.IsGone(err):
+		return reasonExpired
+	case errors.Is(err, io.EOF):
+		return reasonClosed
+	case isTooLargeResourceVersion(err):
+		return reasonTooLargeResourceVersion
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err):
+		return reasonForbidden
+	case errors.Is(err, io.ErrUnexpectedEOF), utilnet.IsConnectionRefused(err), utilnet.IsConnectionReset(err),
+		utilnet.IsProbableEOF(err), utilnet.IsTimeout(err), utilnet.IsHTTP2ConnectionLost(err),
+		apierrors.IsServiceUnavailable(err), isNetError(err):
+		return reasonNetwork
+	default:
+		return reasonOther
+	}
+}
+
+// isTooLargeResourceVersion matches the status cause newer API servers set.
+// client-go keeps its own check unexported.
+func isTooLargeResourceVersion(err error) bool {
+	return apierrors.HasStatusCause(err, metav1.CauseTypeResourceVersionTooLarge)
+}
+
+func isNetError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// resourceConnection is the error history of the reflectors of one resource
+type resourceConnection struct {
+	Failures  int              `json:"failures"`
+	Reason    watchErrorReason `json:"reason,omitempty"`
+	LastError string           `json:"lastError,omitempty"`
+}
+
+// connectionStatus is a snapshot of the tracker, as served by the api health endpoint
+type connectionStatus struct {
+	State     connectionState               `json:"state"`
+	Since     time.Time                     `json:"since"`
+	Resources map[string]resourceConnection `json:"resources,omitempty"`
+}
+
+// connectionTracker is the state machine behind the watch error handlers.
+// Errors move the connection to degraded, repeated network errors to
+// disconnected, and a successful list or watch of every failing resource
+// back to connected. It only records errors: the reflectors retry on their
+// own backoff, and the watch error handler must not block them.
+type connectionTracker struct {
+	mu        sync.Mutex
+	state     connectionState
+	since     time.Time
+	resources map[string]*resourceConnection
+}
+
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{
+		state:     stateConnected,
+		since:     time.Now(),
+		resources: make(map[string]*resourceConnection),
+	}
+}
+
+// watchConnection tracks the connection of all informers of the process
+var watchConnection = newConnectionTracker()
+
+func init() {
+	connectionStateGauge.WithLabelValues(string(stateConnected)).Set(1)
+	connectionStateGauge.WithLabelValues(string(stateDegraded)).Set(0)
+	connectionStateGauge.WithLabelValues(string(stateDisconnected)).Set(0)
+}
+
+// handleWatchErrors installs the tracker as the watch error handler of an
+// informer of namespace ("" for all namespaces) that has not started yet.
+// resource is the plural resource name; together with the namespace it is how
+// the tracker recognises successful requests.
+func handleWatchErrors(informer cache.SharedIndexInformer, kind, namespace, resource string) error {
+	key := connectionKey(namespace, resource)
+	return informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		watchConnection.watchError(kind, key, err)
+	})
+}
+
+// connectionKey identifies the reflectors of a resource in one namespace, or
+// in all namespaces, e.g. "team-a/deployments" or "deployments"
+func connectionKey(namespace, resource string) string {
+	if namespace == "" {
+		return resource
+	}
+	return namespace + "/" + resource
+}
+
+// watchError records an error of the reflectors of a connection key and logs it
+func (t *connectionTracker) watchError(kind, resource string, err error) {
+	reason := classifyWatchError(err)
+	informerWatchErrorsTotal.WithLabelValues(kind, string(reason)).Inc()
+
+	switch reason {
+	case reasonExpired:
+		klog.V(2).Infof("Watch of %s expired, relisting: %v", kind, err)
+		return
+	case reasonClosed:
+		klog.V(4).Infof("Watch of %s closed: %v", kind, err)
+		return
+	}
+
+	t.mu.Lock()
+	conn, ok := t.resources[resource]
+	if !ok {
+		conn = &resourceConnection{}
+		t.resources[resource] = conn
+	}
+	if conn.Reason != reason {
+		conn.Failures = 0
+	}
+	conn.Failures++
+	conn.Reason = reason
+	conn.LastError = err.Error()
+	t.updateLocked()
+	state := t.state
+	t.mu.Unlock()
+
+	switch reason {
+	case reasonNetwork, reasonTooLargeResourceVersion:
+		klog.Warningf("Watch of %s failed (%s, connection %s): %v", kind, reason, state, err)
+	default:
+		klog.Errorf("Watch of %s failed (%s, connection %s): %v", kind, reason, state, err)
+	}
+}
+
+// succeeded records a successful list or watch request of resource
+func (t *connectionTracker) succeeded(resource string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, failing := t.resources[resource]; !failing {
+		return
+	}
+	delete(t.resources, resource)
+	t.updateLocked()
+}
+
+// updateLocked derives the state from the failing resources and logs transitions
+func (t *connectionTracker) updateLocked() {
+	state := stateConnected
+	for _, conn := range t.resources {
+		switch {
+		case conn.Reason == reasonNetwork && conn.Failures >= disconnectAfter:
+			state = stateDisconnected
+		case state == stateConnected:
+			state = stateDegraded
+		}
+	}
+	if state == t.state {
+		return
+	}
+
+	if state == stateConnected {
+		klog.Infof("API server connection %s after %s %s", state, t.state, time.Since(t.since).Round(time.Second))
+	} else {
+		klog.Warningf("API server connection %s (was %s): %s", state, t.state, t.describeLocked())
+	}
+	t.state, t.since = state, time.Now()
+	for _, s := range connectionStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		connectionStateGauge.WithLabelValues(string(s)).Set(value)
+	}
+}
+
+// describeLocked lists the failing resources and their reasons
+func (t *connectionTracker) describeLocked() string {
+	var failing []string
+	for resource, conn := range t.resources {
+		failing = append(failing, resource+" "+string(conn.Reason))
+	}
+	sort.Strings(failing)
+	return strings.Join(failing, ", ")
+}
+
+// status returns a copy of the current state
+func (t *connectionTracker) status() connectionStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := connectionStatus{State: t.state, Since: t.since}
+	if len(t.resources) > 0 {
+		status.Resources = make(map[string]resourceConnection, len(t.resources))
+		for resource, conn := range t.resources {
+			status.Resources[resource] = *conn
+		}
+	}
+	return status
+}
+
+// trackConnection wraps the client transport so that successful list and
+// watch requests move their resource back to connected
+func trackConnection(config *rest.Config) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &connectionObserver{next: rt, tracker: watchConnection}
+	})
+}
+
+// connectionObserver is the round tripper installed by trackConnection
+type connectionObserver struct {
+	next    http.RoundTripper
+	tracker *connectionTracker
+}
+
+func (o *connectionObserver) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := o.next.RoundTrip(req)
+	if err == nil && req.Method == http.MethodGet && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if namespace, resource, ok := collectionPath(req.URL.Path); ok {
+			o.tracker.succeeded(connectionKey(namespace, resource))
+		}
+	}
+	return resp, err
+}
+
+// collectionPath parses the path of a list or watch request, such as
+// /apis/apps/v1/namespaces/default/deployments or /api/v1/pods. Requests for
+// a named object or a subresource are not collection requests.
+func collectionPath(p string) (namespace, resource string, ok bool) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	switch {
+	case len(parts) > 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) > 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return "", "", false
+	}
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		namespace, parts = parts[1], parts[2:]
+	}
+	if len(parts) != 1 {
+		return "", "", false
+	}
+	return namespace, parts[0], true
+}
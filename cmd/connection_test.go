@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestClassifyWatchError(t *testing.T) {
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	tooLarge := apierrors.NewTimeoutError("Too large resource version", 1)
+	tooLarge.ErrStatus.Details.Causes = []metav1.StatusCause{{Type: metav1.CauseTypeResourceVersionTooLarge}}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: &net.OpError{Err: syscall.ECONNREFUSED}}
+
+	tests := []struct {
+		err  error
+		want watchErrorReason
+	}{
+		{apierrors.NewResourceExpired("too old resource version: 1 (42)"), reasonExpired},
+		{apierrors.NewGone("gone"), reasonExpired},
+		{io.EOF, reasonClosed},
+		{tooLarge, reasonTooLargeResourceVersion},
+		{apierrors.NewForbidden(deployments, "", errors.New("RBAC")), reasonForbidden},
+		{apierrors.NewUnauthorized("token expired"), reasonForbidden},
+		{fmt.Errorf("Get deployments: %w", refused), reasonNetwork},
+		{io.ErrUnexpectedEOF, reasonNetwork},
+		{apierrors.NewServiceUnavailable("etcd is down"), reasonNetwork},
+		{apierrors.NewInternalError(errors.New("boom")), reasonOther},
+	}
+	for _, tt := range tests {
+		if got := classifyWatchError(tt.err); got != tt.want {
+			t.Errorf("classifyWatchError(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestConnectionTracker_StateMachine(t *testing.T) {
+	tracker := newConnectionTracker()
+	networkErr := &net.OpError{Op: "dial", Net: "tcp", Err: &net.OpError{Err: syscall.ECONNREFUSED}}
+
+	// Expired watches are routine and leave the state alone
+	tracker.watchError("Deployment", "deployments", apierrors.NewResourceExpired("too old"))
+	if got := tracker.status().State; got != stateConnected {
+		t.Fatalf("Expected expired watches to be ignored, got %s", got)
+	}
+
+	tracker.watchError("Deployment", "deployments", networkErr)
+	if got := tracker.status().State; got != stateDegraded {
+		t.Errorf("Expected degraded after one network error, got %s", got)
+	}
+	for i := 1; i < disconnectAfter; i++ {
+		tracker.watchError("Deployment", "deployments", networkErr)
+	}
+	status := tracker.status()
+	if status.State != stateDisconnected || status.Resources["deployments"].Failures != disconnectAfter {
+		t.Errorf("Expected disconnected after %d network errors, got %+v", disconnectAfter, status)
+	}
+	if got := testutil.ToFloat64(connectionStateGauge.WithLabelValues(string(stateDisconnected))); got != 1 {
+		t.Errorf("Expected the disconnected state gauge to be 1, got %v", got)
+	}
+
+	// Another resource failing for a different reason keeps the worst state
+	tracker.watchError("Pod", "pods", apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("RBAC")))
+	tracker.succeeded("deployments")
+	if got := tracker.status().State; got != stateDegraded {
+		t.Errorf("Expected degraded while pods are forbidden, got %s", got)
+	}
+	tracker.succeeded("pods")
+	if status := tracker.status(); status.State != stateConnected || len(status.Resources) != 0 {
+		t.Errorf("Expected connected once every resource recovered, got %+v", status)
+	}
+	if got := testutil.ToFloat64(connectionStateGauge.WithLabelValues(string(stateConnected))); got != 1 {
+		t.Errorf("Expected the connected state gauge to be 1, got %v", got)
+	}
+}
+
+func TestConnectionObserver(t *testing.T) {
+	tracker := newConnectionTracker()
+	tracker.watchError("Deployment", "team-a/deployments", io.ErrUnexpectedEOF)
+	tracker.watchError("Pod", "team-a/pods", io.ErrUnexpectedEOF)
+
+	status := http.StatusForbidden
+	observer := &connectionObserver{tracker: tracker, next: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status}, nil
+	})}
+	send := func(url string) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		observer.RoundTrip(req)
+	}
+	failing := func() int { return len(tracker.status().Resources) }
+
+	send("https://cluster/apis/apps/v1/namespaces/team-a/deployments?watch=true")
+	if failing() != 2 {
+		t.Errorf("Expected a failed request to keep the failures, got %+v", tracker.status())
+	}
+	status = http.StatusOK
+	send("https://cluster/apis/apps/v1/namespaces/team-a/deployments/pods")
+	send("https://cluster/api/v1/namespaces/team-a/pods/web-1/log")
+	send("https://cluster/api/v1/namespaces/team-b/pods?limit=500")
+	if failing() != 2 {
+		t.Errorf("Expected named objects and other namespaces to keep the failures, got %+v", tracker.status())
+	}
+	send("https://cluster/api/v1/namespaces/team-a/pods?limit=500")
+	if _, ok := tracker.status().Resources["team-a/pods"]; ok || failing() != 1 {
+		t.Errorf("Expected a successful list to clear its namespace, got %+v", tracker.status())
+	}
+	send("https://cluster/apis/apps/v1/namespaces/team-a/deployments?watch=true")
+	if got := tracker.status().State; got != stateConnected {
+		t.Errorf("Expected a successful watch to reconnect, got %s", got)
+	}
+}
+
+func TestCollectionPath(t *testing.T) {
+	tests := []struct {
+		path, namespace, resource string
+		ok                        bool
+	}{
+		{"/apis/apps/v1/namespaces/default/deployments", "default", "deployments", true},
+		{"/api/v1/pods", "", "pods", true},
+		{"/api/v1/namespaces", "", "namespaces", true},
+		{"/api/v1/namespaces/default", "", "", false},
+		{"/apis/apps/v1/namespaces/default/deployments/web", "", "", false},
+		{"/apis/apps/v1/namespaces/default/deployments/web/scale", "", "", false},
+		{"/version", "", "", false},
+	}
+	for _, tt := range tests {
+		namespace, resource, ok := collectionPath(tt.path)
+		if namespace != tt.namespace || resource != tt.resource || ok != tt.ok {
+			t.Errorf("collectionPath(%q) = %q, %q, %t, want %q, %q, %t", tt.path, namespace, resource, ok, tt.namespace, tt.resource, tt.ok)
+		}
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	previous := watchConnection
+	watchConnection = newConnectionTracker()
+	defer func() { watchConnection = previous }()
+
+	rr := httptest.NewRecorder()
+	healthHandler(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 while connected, got %d", rr.Code)
+	}
+
+	for i := 0; i < disconnectAfter; i++ {
+		watchConnection.watchError("Deployment", "deployments", io.ErrUnexpectedEOF)
+	}
+	rr = httptest.NewRecorder()
+	healthHandler(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while disconnected, got %d", rr.Code)
+	}
+	var status connectionStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if status.State != stateDisconnected || status.Resources["deployments"].Reason != reasonNetwork {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	watchConnection.succeeded("deployments")
+}
@@ -0,0 +1,590 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+var dashboardRefresh time.Duration
+
+// dashboardCmd represents the dashboard command
+var dashboardCmd = &cobra.Command{
+	Use:   "dashboard",
+	Short: "Interactive terminal dashboard for deployments",
+	Long: `Open an interactive terminal dashboard backed by the same informer cache as the api command.
+
+Keys:
+  up/down, j/k   move the selection
+  /              filter by namespace or name
+  enter          toggle the detail pane
+  s              scale the selected deployment
+  r              restart the selected deployment
+  u              undo the last rollout of the selected deployment
+  esc            clear the filter
+  q, ctrl+c      quit
+
+Every action asks for confirmation before it is sent to the cluster.
+
+Examples:
+  k8s-controller dashboard                         # Deployments in default namespace
+  k8s-controller dashboard --namespace=production  # Deployments in production
+  k8s-controller dashboard --refresh=5s            # Redraw every 5 seconds`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDashboard(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dashboardCmd)
+
+	dashboardCmd.Flags().StringVar(&apiKubeconfig, "kubeconfig", "", "path to kubeconfig file")
+	dashboardCmd.Flags().StringVar(&apiNamespace, "namespace", "default", "namespace to watch")
+	dashboardCmd.Flags().DurationVar(&dashboardRefresh, "refresh", time.Second, "screen refresh interval")
+	apiInformerOpts.addFlags(dashboardCmd.Flags())
+}
+
+type dashboardMode int
+
+const (
+	modeBrowse dashboardMode = iota
+	modeFilter
+	modeScaleInput
+	modeConfirm
+)
+
+// dashboardAction is a pending cluster change waiting for confirmation
+type dashboardAction struct {
+	kind      string
+	namespace string
+	name      string
+	replicas  int32
+}
+
+// dashboard holds the state of the terminal UI
+type dashboard struct {
+	client      kubernetes.Interface
+	deployments cache.Store
+	replicaSets cache.Store
+	pods        cache.Store
+	events      cache.Store
+
+	mode        dashboardMode
+	filter      string
+	input       string
+	selectedKey string
+	showDetail  bool
+	pending     *dashboardAction
+	status      string
+}
+
+// runDashboard starts the informer cache and runs the dashboard until the user quits
+func runDashboard() error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("dashboard requires an interactive terminal")
+	}
+
+	fmt.Printf("Syncing deployments in %s...\n", apiInformerOpts.describeNamespaces())
+	if err := setupInformer(); err != nil {
+		return err
+	}
+
+	d, synced := newDashboard(apiClient, apiFactory)
+	stopCh := context.Background().Done()
+	apiFactory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return fmt.Errorf("failed to sync cache")
+	}
+
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to switch terminal to raw mode: %w", err)
+	}
+	defer term.Restore(fd, oldState)
+
+	return d.run(os.Stdin, os.Stdout)
+}
+
+// newDashboard registers the informers the detail pane needs on the api factory
+func newDashboard(client kubernetes.Interface, factory informers.SharedInformerFactory) (*dashboard, []cache.InformerSynced) {
+	rsInformer := factory.Apps().V1().ReplicaSets().Informer()
+	podInformer := factory.Core().V1().Pods().Informer()
+	eventInformer := factory.Core().V1().Events().Informer()
+
+	d := &dashboard{
+		client:      client,
+		deployments: informer.GetStore(),
+		replicaSets: rsInformer.GetStore(),
+		pods:        podInformer.GetStore(),
+		events:      eventInformer.GetStore(),
+	}
+	return d, []cache.InformerSynced{informer.HasSynced, rsInformer.HasSynced, podInformer.HasSynced, eventInformer.HasSynced}
+}
+
+// run draws the dashboard and processes key presses until the user quits
+func (d *dashboard) run(in io.Reader, out *os.File) error {
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string, 16)
+	go readKeys(in, keys)
+
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+
+	for {
+		width, height, err := term.GetSize(int(out.Fd()))
+		if err != nil {
+			width, height = 120, 40
+		}
+		fmt.Fprint(out, d.render(width, height))
+
+		select {
+		case key, ok := <-keys:
+			if !ok || d.handleKey(key) {
+				return nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// readKeys decodes raw terminal input into key names
+func readKeys(in io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// parseKeys converts a chunk of raw terminal input into key names
+func parseKeys(b []byte) []string {
+	var keys []string
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == 0x1b && i+2 < len(b) && b[i+1] == '[':
+			switch b[i+2] {
+			case 'A':
+				keys = append(keys, "up")
+			case 'B':
+				keys = append(keys, "down")
+			}
+			i += 2
+		case c == 0x1b:
+			keys = append(keys, "esc")
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c == 0x03:
+			keys = append(keys, "ctrl+c")
+		case c >= 0x20 && c < 0x7f:
+			keys = append(keys, string(c))
+		}
+	}
+	return keys
+}
+
+// handleKey updates the dashboard state for a key press and reports whether to quit
+func (d *dashboard) handleKey(key string) bool {
+	if key == "ctrl+c" {
+		return true
+	}
+
+	switch d.mode {
+	case modeFilter:
+		switch key {
+		case "enter":
+			d.mode = modeBrowse
+		case "esc":
+			d.filter = ""
+			d.mode = modeBrowse
+		case "backspace":
+			d.filter = trimLast(d.filter)
+		default:
+			if len(key) == 1 {
+				d.filter += key
+			}
+		}
+	case modeScaleInput:
+		switch key {
+		case "enter":
+			replicas, err := strconv.ParseInt(d.input, 10, 32)
+			if err != nil || replicas < 0 {
+				d.status = fmt.Sprintf("invalid replica count %q", d.input)
+				d.pending = nil
+				d.mode = modeBrowse
+				return false
+			}
+			d.pending.replicas = int32(replicas)
+			d.mode = modeConfirm
+		case "esc":
+			d.pending = nil
+			d.mode = modeBrowse
+		case "backspace":
+			d.input = trimLast(d.input)
+		default:
+			if len(key) == 1 && key[0] >= '0' && key[0] <= '9' {
+				d.input += key
+			}
+		}
+	case modeConfirm:
+		if key == "y" || key == "Y" {
+			d.status = d.execute(d.pending)
+		} else {
+			d.status = "cancelled"
+		}
+		d.pending = nil
+		d.mode = modeBrowse
+	default:
+		return d.handleBrowseKey(key)
+	}
+	return false
+}
+
+// handleBrowseKey handles key presses while navigating the table
+func (d *dashboard) handleBrowseKey(key string) bool {
+	switch key {
+	case "q":
+		return true
+	case "up", "k":
+		d.moveSelection(-1)
+	case "down", "j":
+		d.moveSelection(1)
+	case "/":
+		d.mode = modeFilter
+	case "esc":
+		d.filter = ""
+	case "enter":
+		d.showDetail = !d.showDetail
+	case "s", "r", "u":
+		selected := d.selected()
+		if selected == nil {
+			return false
+		}
+		d.pending = &dashboardAction{namespace: selected.Namespace, name: selected.Name}
+		switch key {
+		case "s":
+			d.pending.kind = "scale"
+			d.input = ""
+			if selected.Spec.Replicas != nil {
+				d.input = strconv.Itoa(int(*selected.Spec.Replicas))
+			}
+			d.mode = modeScaleInput
+		case "r":
+			d.pending.kind = "restart"
+			d.mode = modeConfirm
+		case "u":
+			d.pending.kind = "undo"
+			d.mode = modeConfirm
+		}
+	}
+	return false
+}
+
+// execute sends a confirmed action to the cluster and returns a status message
+func (d *dashboard) execute(action *dashboardAction) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var err error
+	switch action.kind {
+	case "scale":
+		_, err = scaleDeployment(ctx, d.client, action.namespace, action.name, action.replicas, writeOptions{})
+	case "restart":
+		_, err = restartDeployment(ctx, d.client, action.namespace, action.name, writeOptions{})
+	case "undo":
+		var revision int64
+		revision, err = rollbackDeployment(ctx, d.client, action.namespace, action.name)
+		if err == nil {
+			return fmt.Sprintf("rolled back %s/%s to revision %d", action.namespace, action.name, revision)
+		}
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s %s/%s: done", action.kind, action.namespace, action.name)
+}
+
+// rows returns the deployments matching the filter, sorted by namespace and name
+func (d *dashboard) rows() []*appsv1.Deployment {
+	var rows []*appsv1.Deployment
+	for _, obj := range d.deployments.List() {
+		deployment, _, err := deploymentFromEvent(obj)
+		if err != nil {
+			continue
+		}
+		if d.filter != "" && !strings.Contains(deployment.Namespace+"/"+deployment.Name, d.filter) {
+			continue
+		}
+		rows = append(rows, deployment)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Namespace != rows[j].Namespace {
+			return rows[i].Namespace < rows[j].Namespace
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	return rows
+}
+
+// selectedIndex returns the row index of the selection, falling back to the first row
+func (d *dashboard) selectedIndex(rows []*appsv1.Deployment) int {
+	for i, deployment := range rows {
+		if deployment.Namespace+"/"+deployment.Name == d.selectedKey {
+			return i
+		}
+	}
+	return 0
+}
+
+// selected returns the currently selected deployment, if any
+func (d *dashboard) selected() *appsv1.Deployment {
+	rows := d.rows()
+	if len(rows) == 0 {
+		return nil
+	}
+	return rows[d.selectedIndex(rows)]
+}
+
+// moveSelection moves the selection by delta rows, clamped to the table
+func (d *dashboard) moveSelection(delta int) {
+	rows := d.rows()
+	if len(rows) == 0 {
+		return
+	}
+	i := d.selectedIndex(rows) + delta
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(rows) {
+		i = len(rows) - 1
+	}
+	d.selectedKey = rows[i].Namespace + "/" + rows[i].Name
+}
+
+// render draws the full screen for the given terminal size
+func (d *dashboard) render(width, height int) string {
+	rows := d.rows()
+	selectedIdx := d.selectedIndex(rows)
+
+	var detail []string
+	if d.showDetail && len(rows) > 0 {
+		detail = d.detailLines(rows[selectedIdx])
+	}
+
+	// Header, column titles, status and help lines take four rows
+	tableHeight := height - 4
+	if len(detail) > 0 {
+		tableHeight = (height - 4) / 2
+		if rest := height - 4 - len(detail) - 1; rest > tableHeight {
+			tableHeight = rest
+		}
+	}
+	if tableHeight < 1 {
+		tableHeight = 1
+	}
+	if shown := len(rows); shown < tableHeight {
+		tableHeight = shown
+	}
+	if rest := height - 4 - tableHeight - 1; len(detail) > rest {
+		if rest < 0 {
+			rest = 0
+		}
+		detail = detail[:rest]
+	}
+
+	offset := 0
+	if selectedIdx >= tableHeight {
+		offset = selectedIdx - tableHeight + 1
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+
+	header := fmt.Sprintf("k8s-controller dashboard | %s | %d deployments", apiInformerOpts.describeNamespaces(), len(rows))
+	if d.filter != "" || d.mode == modeFilter {
+		header += fmt.Sprintf(" | filter: %s", d.filter)
+	}
+	writeLine(&b, "\x1b[1m", header, width)
+	writeLine(&b, "\x1b[1m", fmt.Sprintf("%-20s %-30s %-22s %-10s %-10s %-6s",
+		"NAMESPACE", "NAME", "READY", "UP-TO-DATE", "AVAILABLE", "AGE"), width)
+
+	for i := offset; i < len(rows) && i < offset+tableHeight; i++ {
+		deployment := rows[i]
+		var desired int32
+		if deployment.Spec.Replicas != nil {
+			desired = *deployment.Spec.Replicas
+		}
+		line := fmt.Sprintf("%-20s %-30s %-22s %-10d %-10d %-6s",
+			deployment.Namespace,
+			deployment.Name,
+			readinessBar(deployment.Status.ReadyReplicas, desired, 10),
+			deployment.Status.UpdatedReplicas,
+			deployment.Status.AvailableReplicas,
+			formatAge(time.Since(deployment.CreationTimestamp.Time)))
+
+		style := ""
+		if deployment.Status.ReadyReplicas < desired {
+			style = "\x1b[33m"
+		}
+		if i == selectedIdx {
+			style += "\x1b[7m"
+		}
+		writeLine(&b, style, line, width)
+	}
+
+	if len(detail) > 0 {
+		writeLine(&b, "", strings.Repeat("-", width), width)
+		for _, line := range detail {
+			writeLine(&b, "", line, width)
+		}
+	}
+
+	b.WriteString(fmt.Sprintf("\x1b[%d;1H", height-1))
+	writeLine(&b, "\x1b[1m", d.prompt(), width)
+	writeLine(&b, "\x1b[2m", "j/k move  / filter  enter details  s scale  r restart  u undo  q quit", width)
+	return strings.TrimSuffix(b.String(), "\r\n")
+}
+
+// prompt returns the text of the status line for the current mode
+func (d *dashboard) prompt() string {
+	switch d.mode {
+	case modeFilter:
+		return "filter: " + d.filter + "_"
+	case modeScaleInput:
+		return fmt.Sprintf("scale %s/%s to replicas: %s_", d.pending.namespace, d.pending.name, d.input)
+	case modeConfirm:
+		if d.pending.kind == "scale" {
+			return fmt.Sprintf("scale %s/%s to %d replicas? [y/N]", d.pending.namespace, d.pending.name, d.pending.replicas)
+		}
+		return fmt.Sprintf("%s %s/%s? [y/N]", d.pending.kind, d.pending.namespace, d.pending.name)
+	}
+	return d.status
+}
+
+// detailLines renders conditions, ReplicaSets, pods and events of a deployment
+func (d *dashboard) detailLines(deployment *appsv1.Deployment) []string {
+	lines := []string{fmt.Sprintf("%s/%s  revision %s  strategy %s  image %s",
+		deployment.Namespace, deployment.Name,
+		deployment.Annotations[revisionAnnotation],
+		deployment.Spec.Strategy.Type,
+		getImage(deployment))}
+
+	lines = append(lines, "Conditions:")
+	for _, condition := range deployment.Status.Conditions {
+		lines = append(lines, fmt.Sprintf("  %-16s %-6s %-28s %s",
+			condition.Type, condition.Status, condition.Reason, condition.Message))
+	}
+
+	var replicaSets []*appsv1.ReplicaSet
+	for _, obj := range d.replicaSets.List() {
+		if rs, ok := obj.(*appsv1.ReplicaSet); ok {
+			replicaSets = append(replicaSets, rs)
+		}
+	}
+	owned := ownedReplicaSets(deployment, replicaSets)
+
+	uids := map[types.UID]bool{deployment.UID: true}
+	lines = append(lines, "ReplicaSets:")
+	for _, rs := range owned {
+		uids[rs.UID] = true
+		image := ""
+		if len(rs.Spec.Template.Spec.Containers) > 0 {
+			image = rs.Spec.Template.Spec.Containers[0].Image
+		}
+		lines = append(lines, fmt.Sprintf("  %-40s rev %-4d %d/%d ready  %s",
+			rs.Name, replicaSetRevision(rs), rs.Status.ReadyReplicas, rs.Status.Replicas, image))
+	}
+
+	var pods []*corev1.Pod
+	for _, obj := range d.pods.List() {
+		if pod, ok := obj.(*corev1.Pod); ok {
+			pods = append(pods, pod)
+		}
+	}
+	lines = append(lines, "Pods:")
+	for _, pod := range ownedPods(owned, pods) {
+		uids[pod.UID] = true
+		ready, total, restarts := podReadiness(pod)
+		lines = append(lines, fmt.Sprintf("  %-50s %-10s %d/%d ready  %d restarts  %s",
+			pod.Name, pod.Status.Phase, ready, total, restarts,
+			formatAge(time.Since(pod.CreationTimestamp.Time))))
+	}
+
+	var events []*corev1.Event
+	for _, obj := range d.events.List() {
+		if event, ok := obj.(*corev1.Event); ok {
+			events = append(events, event)
+		}
+	}
+	lines = append(lines, "Events:")
+	for i, event := range eventsFor(uids, events) {
+		if i == 10 {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("  %-6s %-8s %-24s %s",
+			formatAge(time.Since(eventTime(event).Time)), event.Type, event.Reason, event.Message))
+	}
+	return lines
+}
+
+// readinessBar renders ready/desired replicas as a fixed-width bar
+func readinessBar(ready, desired int32, width int) string {
+	filled := width
+	if desired > 0 {
+		filled = int(ready) * width / int(desired)
+	}
+	if filled > width {
+		filled = width
+	}
+	if filled < 0 {
+		filled = 0
+	}
+	return fmt.Sprintf("[%s%s] %d/%d", strings.Repeat("#", filled), strings.Repeat("-", width-filled), ready, desired)
+}
+
+// writeLine writes one screen line truncated to the terminal width
+func writeLine(b *strings.Builder, style, text string, width int) {
+	if width > 0 && len(text) > width {
+		text = text[:width]
+	}
+	if style != "" {
+		text = style + text + "\x1b[0m"
+	}
+	b.WriteString(text)
+	b.WriteString("\x1b[K\r\n")
+}
+
+// trimLast removes the last byte of s, if any
+func trimLast(s string) string {
+	if s == "" {
+		return s
+	}
+	return s[:len(s)-1]
+}
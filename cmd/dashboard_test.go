@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestDeployment(name string, replicas, ready int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name + "-uid"),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(replicas),
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": name}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: name, Image: "nginx:1.25"}},
+				},
+			},
+		},
+		Status: appsv1.DeploymentStatus{ReadyReplicas: ready},
+	}
+}
+
+func newTestDashboard(objs ...*appsv1.Deployment) *dashboard {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	client := fake.NewSimpleClientset()
+	for _, d := range objs {
+		store.Add(d)
+		client.Tracker().Add(d)
+	}
+	return &dashboard{
+		client:      client,
+		deployments: store,
+		replicaSets: cache.NewStore(cache.MetaNamespaceKeyFunc),
+		pods:        cache.NewStore(cache.MetaNamespaceKeyFunc),
+		events:      cache.NewStore(cache.MetaNamespaceKeyFunc),
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("j\x1b[A\x1b[B\r\x7f\x03/"))
+	expected := []string{"j", "up", "down", "enter", "backspace", "ctrl+c", "/"}
+
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected keys %v, got %v", expected, keys)
+	}
+}
+
+func TestReadinessBar(t *testing.T) {
+	cases := []struct {
+		ready, desired int32
+		expected       string
+	}{
+		{0, 4, "[----] 0/4"},
+		{2, 4, "[##--] 2/4"},
+		{4, 4, "[####] 4/4"},
+		{0, 0, "[####] 0/0"},
+	}
+
+	for _, c := range cases {
+		if bar := readinessBar(c.ready, c.desired, 4); bar != c.expected {
+			t.Errorf("readinessBar(%d, %d) = %q, expected %q", c.ready, c.desired, bar, c.expected)
+		}
+	}
+}
+
+func TestDashboard_FilterAndSelection(t *testing.T) {
+	d := newTestDashboard(newTestDeployment("api", 2, 2), newTestDeployment("web", 3, 1), newTestDeployment("worker", 1, 1))
+
+	d.handleKey("down")
+	if selected := d.selected(); selected.Name != "web" {
+		t.Errorf("Expected web to be selected, got %s", selected.Name)
+	}
+
+	for _, key := range []string{"/", "w", "o", "enter"} {
+		d.handleKey(key)
+	}
+	rows := d.rows()
+	if len(rows) != 1 || rows[0].Name != "worker" {
+		t.Fatalf("Expected only worker to match the filter, got %d rows", len(rows))
+	}
+	if selected := d.selected(); selected.Name != "worker" {
+		t.Errorf("Expected selection to fall back to worker, got %s", selected.Name)
+	}
+
+	if !strings.Contains(d.render(120, 20), "filter: wo") {
+		t.Error("Expected the header to show the active filter")
+	}
+}
+
+func TestDashboard_ScaleRequiresConfirmation(t *testing.T) {
+	d := newTestDashboard(newTestDeployment("web", 3, 3))
+
+	for _, key := range []string{"s", "backspace", "5", "enter"} {
+		d.handleKey(key)
+	}
+	if d.mode != modeConfirm {
+		t.Fatalf("Expected confirmation prompt, got mode %d", d.mode)
+	}
+
+	d.handleKey("n")
+	scaled, _ := d.client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	if *scaled.Spec.Replicas != 3 {
+		t.Errorf("Expected cancelled scale to leave 3 replicas, got %d", *scaled.Spec.Replicas)
+	}
+
+	for _, key := range []string{"s", "backspace", "5", "enter", "y"} {
+		d.handleKey(key)
+	}
+	scaled, _ = d.client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	if *scaled.Spec.Replicas != 5 {
+		t.Errorf("Expected 5 replicas after confirmed scale, got %d (status %q)", *scaled.Spec.Replicas, d.status)
+	}
+}
+
+func TestDashboard_InvalidScaleClearsPending(t *testing.T) {
+	d := newTestDashboard(newTestDeployment("web", 3, 3))
+
+	// An empty replica count does not parse
+	for _, key := range []string{"s", "backspace", "enter"} {
+		d.handleKey(key)
+	}
+	if d.mode != modeBrowse || d.pending != nil {
+		t.Errorf("Expected an invalid count to drop the pending action, got mode %d and %+v", d.mode, d.pending)
+	}
+}
+
+func TestRollbackDeployment(t *testing.T) {
+	deployment := newTestDeployment("web", 1, 1)
+	deployment.Annotations = map[string]string{revisionAnnotation: "2"}
+	deployment.Spec.Template.Spec.Containers[0].Image = "nginx:1.26"
+
+	controller := true
+	newReplicaSet := func(name, revision, image string) *appsv1.ReplicaSet {
+		return &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				UID:         types.UID(name),
+				Labels:      map[string]string{"app": "web"},
+				Annotations: map[string]string{revisionAnnotation: revision},
+				OwnerReferences: []metav1.OwnerReference{
+					{UID: deployment.UID, Controller: &controller},
+				},
+			},
+			Spec: appsv1.ReplicaSetSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+						"app": "web", appsv1.DefaultDeploymentUniqueLabelKey: name,
+					}},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: image}}},
+				},
+			},
+		}
+	}
+
+	client := fake.NewSimpleClientset(deployment,
+		newReplicaSet("web-1", "1", "nginx:1.25"),
+		newReplicaSet("web-2", "2", "nginx:1.26"))
+
+	revision, err := rollbackDeployment(context.TODO(), client, "default", "web")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if revision != 1 {
+		t.Errorf("Expected rollback to revision 1, got %d", revision)
+	}
+
+	rolledBack, _ := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	if image := getImage(rolledBack); image != "nginx:1.25" {
+		t.Errorf("Expected image nginx:1.25 after rollback, got %s", image)
+	}
+	if _, ok := rolledBack.Spec.Template.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; ok {
+		t.Error("Expected pod-template-hash label to be removed from the restored template")
+	}
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// maxDiffValueLength keeps long values such as last-applied annotations out of the logs
+const maxDiffValueLength = 40
+
+// fieldChange describes a single changed field between two versions of an object
+type fieldChange struct {
+	Field  string
+	Old    string
+	New    string
+	Status bool
+}
+
+// String renders the change compactly, e.g. "replicas: 3→5" or "label.tier: +web"
+func (c fieldChange) String() string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("%s: +%s", c.Field, truncateValue(c.New))
+	case c.New == "":
+		return fmt.Sprintf("%s: -%s", c.Field, truncateValue(c.Old))
+	}
+	return fmt.Sprintf("%s: %s→%s", c.Field, truncateValue(c.Old), truncateValue(c.New))
+}
+
+// diffDeployments returns the field-level changes between two versions of a deployment
+func diffDeployments(oldDep, newDep *appsv1.Deployment) []fieldChange {
+	var changes []fieldChange
+
+	if oldReplicas, newReplicas := replicasString(oldDep.Spec.Replicas), replicasString(newDep.Spec.Replicas); oldReplicas != newReplicas {
+		changes = append(changes, fieldChange{Field: "replicas", Old: oldReplicas, New: newReplicas})
+	}
+
+	changes = append(changes, diffContainers(oldDep.Spec.Template.Spec.Containers, newDep.Spec.Template.Spec.Containers)...)
+	changes = append(changes, diffMaps("label", oldDep.Labels, newDep.Labels)...)
+	changes = append(changes, diffMaps("annotation", oldDep.Annotations, newDep.Annotations)...)
+
+	statusCounters := []struct {
+		field    string
+		old, new int32
+	}{
+		{"status.replicas", oldDep.Status.Replicas, newDep.Status.Replicas},
+		{"status.ready", oldDep.Status.ReadyReplicas, newDep.Status.ReadyReplicas},
+		{"status.updated", oldDep.Status.UpdatedReplicas, newDep.Status.UpdatedReplicas},
+		{"status.available", oldDep.Status.AvailableReplicas, newDep.Status.AvailableReplicas},
+		{"status.unavailable", oldDep.Status.UnavailableReplicas, newDep.Status.UnavailableReplicas},
+	}
+	for _, counter := range statusCounters {
+		if counter.old != counter.new {
+			changes = append(changes, fieldChange{
+				Field:  counter.field,
+				Old:    fmt.Sprint(counter.old),
+				New:    fmt.Sprint(counter.new),
+				Status: true,
+			})
+		}
+	}
+
+	return changes
+}
+
+// diffContainers compares images, env and resources of containers matched by name
+func diffContainers(oldContainers, newContainers []corev1.Container) []fieldChange {
+	var changes []fieldChange
+
+	oldByName := make(map[string]corev1.Container, len(oldContainers))
+	for _, c := range oldContainers {
+		oldByName[c.Name] = c
+	}
+	newNames := make(map[string]bool, len(newContainers))
+
+	for _, newC := range newContainers {
+		newNames[newC.Name] = true
+		oldC, ok := oldByName[newC.Name]
+		if !ok {
+			changes = append(changes, fieldChange{Field: "container[" + newC.Name + "]", New: newC.Image})
+			continue
+		}
+
+		if oldC.Image != newC.Image {
+			changes = append(changes, fieldChange{Field: "image[" + newC.Name + "]", Old: oldC.Image, New: newC.Image})
+		}
+		changes = append(changes, diffMaps("env["+newC.Name+"]", envMap(oldC.Env), envMap(newC.Env))...)
+		changes = append(changes, diffMaps("requests["+newC.Name+"]",
+			resourceMap(oldC.Resources.Requests), resourceMap(newC.Resources.Requests))...)
+		changes = append(changes, diffMaps("limits["+newC.Name+"]",
+			resourceMap(oldC.Resources.Limits), resourceMap(newC.Resources.Limits))...)
+	}
+
+	for _, oldC := range oldContainers {
+		if !newNames[oldC.Name] {
+			changes = append(changes, fieldChange{Field: "container[" + oldC.Name + "]", Old: oldC.Image})
+		}
+	}
+
+	return changes
+}
+
+// diffMaps returns one change per added, removed or modified key, sorted by key
+func diffMaps(prefix string, oldMap, newMap map[string]string) []fieldChange {
+	keys := make(map[string]bool, len(oldMap)+len(newMap))
+	for k := range oldMap {
+		keys[k] = true
+	}
+	for k := range newMap {
+		keys[k] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []fieldChange
+	for _, k := range sorted {
+		oldValue, inOld := oldMap[k]
+		newValue, inNew := newMap[k]
+		if inOld == inNew && oldValue == newValue {
+			continue
+		}
+		changes = append(changes, fieldChange{Field: prefix + "." + k, Old: mapValue(oldValue, inOld), New: mapValue(newValue, inNew)})
+	}
+	return changes
+}
+
+// mapValue renders a present but empty map value as "", so that adding or
+// removing it is still shown as a change
+func mapValue(value string, present bool) string {
+	if present && value == "" {
+		return `""`
+	}
+	return value
+}
+
+// envMap flattens container env vars into name/value pairs
+func envMap(env []corev1.EnvVar) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, e := range env {
+		switch {
+		case e.ValueFrom != nil:
+			m[e.Name] = "<valueFrom>"
+		case e.Value == "":
+			m[e.Name] = `""`
+		default:
+			m[e.Name] = e.Value
+		}
+	}
+	return m
+}
+
+// resourceMap flattens a resource list into name/quantity pairs
+func resourceMap(resources corev1.ResourceList) map[string]string {
+	m := make(map[string]string, len(resources))
+	for name, quantity := range resources {
+		m[string(name)] = quantity.String()
+	}
+	return m
+}
+
+// replicasString renders an optional replica count
+func replicasString(replicas *int32) string {
+	if replicas == nil {
+		return "<unset>"
+	}
+	return fmt.Sprint(*replicas)
+}
+
+// hasSpecChanges reports whether any change touches more than status counters
+func hasSpecChanges(changes []fieldChange) bool {
+	for _, c := range changes {
+		if !c.Status {
+			return true
+		}
+	}
+	return false
+}
+
+// formatChanges joins changes into a single log-friendly line
+func formatChanges(changes []fieldChange) string {
+	parts := make([]string, len(changes))
+	for i, c := range changes {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// truncateValue shortens long values for log output
+func truncateValue(v string) string {
+	if len(v) > maxDiffValueLength {
+		return v[:maxDiffValueLength] + "..."
+	}
+	return v
+}
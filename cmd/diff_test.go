@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestDiffDeployments_SpecChanges(t *testing.T) {
+	oldDep := newTestDeployment("web", 3, 3)
+	oldDep.Labels = map[string]string{"app": "web", "tier": "frontend"}
+	oldDep.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "MODE", Value: "blue"}}
+
+	newDep := oldDep.DeepCopy()
+	newDep.Spec.Replicas = int32Ptr(5)
+	newDep.Spec.Template.Spec.Containers[0].Image = "nginx:1.26"
+	newDep.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "MODE", Value: "green"}, {Name: "DEBUG", Value: "1"}}
+	newDep.Spec.Template.Spec.Containers[0].Resources.Limits = corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("500m"),
+	}
+	delete(newDep.Labels, "tier")
+
+	changes := diffDeployments(oldDep, newDep)
+	expected := "replicas: 3→5, image[web]: nginx:1.25→nginx:1.26, env[web].DEBUG: +1, env[web].MODE: blue→green, " +
+		"limits[web].cpu: +500m, label.tier: -frontend"
+
+	if got := formatChanges(changes); got != expected {
+		t.Errorf("Expected diff %q, got %q", expected, got)
+	}
+	if !hasSpecChanges(changes) {
+		t.Error("Expected spec changes to be detected")
+	}
+}
+
+func TestDiffDeployments_StatusOnly(t *testing.T) {
+	oldDep := newTestDeployment("web", 3, 1)
+	newDep := oldDep.DeepCopy()
+	newDep.Status.ReadyReplicas = 3
+	newDep.Status.AvailableReplicas = 3
+
+	changes := diffDeployments(oldDep, newDep)
+	if got := formatChanges(changes); got != "status.ready: 1→3, status.available: 0→3" {
+		t.Errorf("Unexpected status diff %q", got)
+	}
+	if hasSpecChanges(changes) {
+		t.Error("Expected status-only changes not to count as spec changes")
+	}
+}
+
+func TestDiffMaps_EmptyValues(t *testing.T) {
+	cases := []struct {
+		name           string
+		oldMap, newMap map[string]string
+		expected       string
+	}{
+		{"added empty", nil, map[string]string{"canary": ""}, `label.canary: +""`},
+		{"removed empty", map[string]string{"canary": ""}, map[string]string{}, `label.canary: -""`},
+		{"emptied", map[string]string{"canary": "yes"}, map[string]string{"canary": ""}, `label.canary: yes→""`},
+		{"unchanged empty", map[string]string{"canary": ""}, map[string]string{"canary": ""}, ""},
+	}
+	for _, c := range cases {
+		if got := formatChanges(diffMaps("label", c.oldMap, c.newMap)); got != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestDiffDeployments_NoChanges(t *testing.T) {
+	oldDep := newTestDeployment("web", 3, 3)
+	if changes := diffDeployments(oldDep, oldDep.DeepCopy()); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Deployment cache index names
+const (
+	indexByImage     = "image"
+	indexByLabel     = "label"
+	indexByTeam      = "team"
+	indexByReadiness = "readiness"
+)
+
+// Readiness states of the readiness index
+const (
+	readinessReady       = "ready"
+	readinessPartial     = "partial"
+	readinessUnavailable = "unavailable"
+	readinessScaledDown  = "scaled-down"
+)
+
+// teamAnnotations are the annotations that name the team owning a deployment
+var teamAnnotations = []string{"team", "owner"}
+
+// deploymentIndexers lets cache lookups by image, label, team and readiness
+// cost O(result) instead of a scan of the whole store
+var deploymentIndexers = cache.Indexers{
+	indexByImage:     deploymentIndexFunc(imageIndexValues),
+	indexByLabel:     deploymentIndexFunc(labelIndexValues),
+	indexByTeam:      deploymentIndexFunc(teamIndexValues),
+	indexByReadiness: deploymentIndexFunc(func(d *appsv1.Deployment) []string { return []string{readinessState(d)} }),
+}
+
+// addDeploymentIndexers registers the deployment indexers on an informer that has not started yet
+func addDeploymentIndexers(informer cache.SharedIndexInformer) error {
+	if err := informer.AddIndexers(deploymentIndexers); err != nil {
+		return fmt.Errorf("failed to add deployment indexers: %w", err)
+	}
+	return nil
+}
+
+// deploymentIndexFunc adapts a deployment index function to cache.IndexFunc
+func deploymentIndexFunc(values func(*appsv1.Deployment) []string) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		d, _, err := deploymentFromEvent(obj)
+		if err != nil {
+			return nil, err
+		}
+		return values(d), nil
+	}
+}
+
+// imageIndexValues indexes every container image both as written and without
+// its tag or digest, so "nginx" finds deployments running "nginx:1.25"
+func imageIndexValues(d *appsv1.Deployment) []string {
+	seen := make(map[string]bool)
+	var values []string
+	add := func(value string) {
+		if value != "" && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	containers := append(append([]corev1.Container(nil), d.Spec.Template.Spec.InitContainers...), d.Spec.Template.Spec.Containers...)
+	for _, c := range containers {
+		add(c.Image)
+		add(imageRepository(c.Image))
+	}
+	return values
+}
+
+// imageRepository strips the tag and digest from an image reference
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// labelIndexValues indexes every label as key=value
+func labelIndexValues(d *appsv1.Deployment) []string {
+	values := make([]string, 0, len(d.Labels))
+	for k, v := range d.Labels {
+		values = append(values, k+"="+v)
+	}
+	return values
+}
+
+// teamIndexValues indexes the values of the team annotations
+func teamIndexValues(d *appsv1.Deployment) []string {
+	var values []string
+	for _, key := range teamAnnotations {
+		if v := d.Annotations[key]; v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// readinessState classifies a deployment by its ready replicas
+func readinessState(d *appsv1.Deployment) string {
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	switch {
+	case desired == 0:
+		return readinessScaledDown
+	case d.Status.ReadyReplicas >= desired:
+		return readinessReady
+	case d.Status.ReadyReplicas == 0:
+		return readinessUnavailable
+	default:
+		return readinessPartial
+	}
+}
+
+// indexFilter selects deployments whose index values include value
+type indexFilter struct {
+	index string
+	value string
+}
+
+func (f indexFilter) String() string {
+	return f.index + "=" + f.value
+}
+
+// parseIndexFilters builds index filters from the image, label, team and readiness options
+func parseIndexFilters(image string, labels []string, team, readiness string) ([]indexFilter, error) {
+	var filters []indexFilter
+	if image != "" {
+		filters = append(filters, indexFilter{indexByImage, image})
+	}
+	for _, label := range labels {
+		if k, _, ok := strings.Cut(label, "="); !ok || k == "" {
+			return nil, fmt.Errorf("invalid label filter %q, expected key=value", label)
+		}
+		filters = append(filters, indexFilter{indexByLabel, label})
+	}
+	if team != "" {
+		filters = append(filters, indexFilter{indexByTeam, team})
+	}
+	if readiness != "" {
+		switch readiness {
+		case readinessReady, readinessPartial, readinessUnavailable, readinessScaledDown:
+		default:
+			return nil, fmt.Errorf("invalid readiness %q, expected %s, %s, %s or %s",
+				readiness, readinessReady, readinessPartial, readinessUnavailable, readinessScaledDown)
+		}
+		filters = append(filters, indexFilter{indexByReadiness, readiness})
+	}
+	return filters, nil
+}
+
+// queryDeployments returns the cached deployments matching all filters, sorted
+// by namespace and name. The first filter is answered by its index, the rest
+// are checked against the candidates only.
+func queryDeployments(indexer cache.Indexer, filters []indexFilter) ([]*appsv1.Deployment, error) {
+	var objects []interface{}
+	if len(filters) == 0 {
+		objects = indexer.List()
+	} else {
+		var err error
+		if objects, err = indexer.ByIndex(filters[0].index, filters[0].value); err != nil {
+			return nil, err
+		}
+	}
+
+	var deployments []*appsv1.Deployment
+	for _, obj := range objects {
+		d, _, err := deploymentFromEvent(obj)
+		if err != nil {
+			continue
+		}
+		if len(filters) > 0 && !matchesIndexFilters(d, filters[1:]) {
+			continue
+		}
+		deployments = append(deployments, d)
+	}
+	sort.Slice(deployments, func(i, j int) bool {
+		if deployments[i].Namespace != deployments[j].Namespace {
+			return deployments[i].Namespace < deployments[j].Namespace
+		}
+		return deployments[i].Name < deployments[j].Name
+	})
+	return deployments, nil
+}
+
+// matchesIndexFilters reports whether a deployment matches all filters, using the index functions
+func matchesIndexFilters(d *appsv1.Deployment, filters []indexFilter) bool {
+	for _, f := range filters {
+		values, err := deploymentIndexers[f.index](d)
+		if err != nil {
+			return false
+		}
+		found := false
+		for _, v := range values {
+			if v == f.value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// indexedTestDeployments returns deployments covering every index
+func indexedTestDeployments() []*appsv1.Deployment {
+	web := newTestDeployment("web", 3, 3)
+	web.Labels = map[string]string{"app": "web", "tier": "frontend"}
+	web.Annotations = map[string]string{"team": "storefront"}
+
+	api := newTestDeployment("api", 3, 1)
+	api.Labels = map[string]string{"app": "api", "tier": "backend"}
+	api.Annotations = map[string]string{"owner": "payments"}
+	api.Spec.Template.Spec.Containers[0].Image = "registry.local:5000/payments/api@sha256:abc"
+
+	worker := newTestDeployment("worker", 0, 0)
+	worker.Labels = map[string]string{"tier": "backend"}
+	worker.Annotations = map[string]string{"team": "payments"}
+	worker.Spec.Template.Spec.Containers[0].Image = "nginx:1.24"
+
+	return []*appsv1.Deployment{web, api, worker}
+}
+
+func deploymentNames(deployments []*appsv1.Deployment) string {
+	var names []string
+	for _, d := range deployments {
+		names = append(names, d.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestQueryDeployments(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, deploymentIndexers)
+	for _, d := range indexedTestDeployments() {
+		indexer.Add(d)
+	}
+
+	cases := []struct {
+		name     string
+		filters  []indexFilter
+		expected string
+	}{
+		{"no filters", nil, "api,web,worker"},
+		{"image repository", []indexFilter{{indexByImage, "nginx"}}, "web,worker"},
+		{"image with tag", []indexFilter{{indexByImage, "nginx:1.25"}}, "web"},
+		{"image with registry port and digest", []indexFilter{{indexByImage, "registry.local:5000/payments/api"}}, "api"},
+		{"label", []indexFilter{{indexByLabel, "tier=backend"}}, "api,worker"},
+		{"team from either annotation", []indexFilter{{indexByTeam, "payments"}}, "api,worker"},
+		{"readiness ready", []indexFilter{{indexByReadiness, readinessReady}}, "web"},
+		{"readiness partial", []indexFilter{{indexByReadiness, readinessPartial}}, "api"},
+		{"readiness scaled down", []indexFilter{{indexByReadiness, readinessScaledDown}}, "worker"},
+		{"combined", []indexFilter{{indexByLabel, "tier=backend"}, {indexByImage, "nginx"}}, "worker"},
+		{"no match", []indexFilter{{indexByImage, "redis"}}, ""},
+	}
+	for _, c := range cases {
+		got, err := queryDeployments(indexer, c.filters)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if names := deploymentNames(got); names != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, names)
+		}
+	}
+
+	// Index entries follow updates
+	scaled := indexedTestDeployments()[1]
+	scaled.Status.ReadyReplicas = 3
+	indexer.Update(scaled)
+	if got, _ := queryDeployments(indexer, []indexFilter{{indexByReadiness, readinessReady}}); deploymentNames(got) != "api,web" {
+		t.Errorf("Expected api to become ready, got %q", deploymentNames(got))
+	}
+}
+
+func TestParseIndexFilters(t *testing.T) {
+	filters, err := parseIndexFilters("nginx", []string{"app=web"}, "payments", "ready")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var parts []string
+	for _, f := range filters {
+		parts = append(parts, f.String())
+	}
+	if got := strings.Join(parts, " "); got != "image=nginx label=app=web team=payments readiness=ready" {
+		t.Errorf("Unexpected filters %q", got)
+	}
+
+	if _, err := parseIndexFilters("", []string{"app"}, "", ""); err == nil {
+		t.Error("Expected an error for a label without a value")
+	}
+	if _, err := parseIndexFilters("", nil, "", "healthy"); err == nil || !strings.Contains(err.Error(), "scaled-down") {
+		t.Errorf("Expected an error listing readiness states, got %v", err)
+	}
+}
+
+func TestListDeploymentsHandler_IndexQueries(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	for _, d := range indexedTestDeployments() {
+		client.Tracker().Add(d)
+	}
+	factory := informers.NewSharedInformerFactory(client, 0)
+	deploymentInformer := factory.Apps().V1().Deployments().Informer()
+	if err := addDeploymentIndexers(deploymentInformer); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory.Start(ctx.Done())
+	cache.WaitForCacheSync(ctx.Done(), deploymentInformer.HasSynced)
+
+	previous := informer
+	informer = deploymentInformer
+	defer func() { informer = previous }()
+
+	rr := httptest.NewRecorder()
+	listDeploymentsHandler(rr, httptest.NewRequest("GET", "/deployments?team=payments&label=tier=backend&readiness=partial", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var deployments []api.Deployment
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployments); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(deployments) != 1 || deployments[0].Name != "api" {
+		t.Errorf("Expected only api, got %+v", deployments)
+	}
+
+	rr = httptest.NewRecorder()
+	listDeploymentsHandler(rr, httptest.NewRequest("GET", "/deployments?image=redis", nil))
+	if strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Expected an empty JSON array, got %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	listDeploymentsHandler(rr, httptest.NewRequest("GET", "/deployments?readiness=healthy", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid readiness, got %d", rr.Code)
+	}
+}
@@ -4,33 +4,60 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
-	appsv1 "k8s.io/api/apps/v1"
-	"k8s.io/client-go/informers"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 )
 
 var (
-	informerKubeconfig string
-	namespace          string
+	informerKubeconfig    string
+	informerOpts          informerOptions
+	informerStatusChanges bool
+	informerSinkSpecs     []string
+	informerSinks         []*filteredSink
+	informerResources     []string
+	informerFollowOwners  bool
+	informerWorkers       int
+	informerMaxRetries    int
+	informerRecordPath    string
+	informerMetricsAddr   string
+	informerCoalesce      time.Duration
+	informerSummaryEvery  time.Duration
+	informerMetadataOnly  bool
 )
 
 // informerCmd represents the informer command
 var informerCmd = &cobra.Command{
 	Use:   "informer",
 	Short: "Watch Kubernetes deployment changes",
-	Long: `Watch for changes to Kubernetes deployment resources and log events.
+	Long: `Watch for changes to Kubernetes deployments and related resources and log events.
 
 Examples:
   k8s-controller informer                           # Watch deployments in default namespace
-  k8s-controller informer --namespace=kube-system  # Watch deployments in kube-system`,
+  k8s-controller informer --namespace=kube-system  # Watch deployments in kube-system
+  k8s-controller informer --namespace=team-a --namespace=team-b --label-selector=team=payments
+  k8s-controller informer --all-namespaces --resync-period=0  # One cluster-wide cache, no resync
+  k8s-controller informer --status-changes=false   # Ignore status-only updates
+  k8s-controller informer --sink=stdout --sink='file:/var/log/deployments.jsonl;types=DELETED'
+  k8s-controller informer --resources=deployments,pods --follow-owners  # Only pods of watched deployments
+  k8s-controller informer --record=events.jsonl    # Save every watch event for "replay"
+  k8s-controller informer --metrics-addr=:9090     # Prometheus metrics at http://localhost:9090/metrics
+  k8s-controller informer --coalesce-window=10s --summary-interval=1m  # Readable logs during rollouts
+  k8s-controller informer --resources=pods --metadata-only  # Names and labels only, small cache`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := runInformer(); err != nil {
 			fmt.Printf("Error: %v\n", err)
@@ -43,61 +70,377 @@ func init() {
 	rootCmd.AddCommand(informerCmd)
 
 	informerCmd.Flags().StringVar(&informerKubeconfig, "kubeconfig", "", "path to kubeconfig file")
-	informerCmd.Flags().StringVar(&namespace, "namespace", "default", "namespace to watch")
+	informerCmd.Flags().StringSliceVar(&informerOpts.namespaces, "namespace", []string{"default"}, "namespace to watch (repeatable, one informer factory each)")
+	informerOpts.addFlags(informerCmd.Flags())
+	informerCmd.Flags().BoolVar(&informerStatusChanges, "status-changes", true, "log updates that only change status counters")
+	informerCmd.Flags().StringArrayVar(&informerSinkSpecs, "sink", nil,
+		"event sink as kind[:target][;option=value...], kinds: stdout, file, webhook, exec (repeatable)")
+	informerCmd.Flags().StringSliceVar(&informerResources, "resources", []string{"deployments"},
+		"resources to watch: "+strings.Join(watchableResourceNames(), ", "))
+	informerCmd.Flags().BoolVar(&informerFollowOwners, "follow-owners", false, "only report pods and ReplicaSets that belong to watched deployments")
+	informerCmd.Flags().IntVar(&informerWorkers, "workers", 2, "number of workers delivering events to sinks")
+	informerCmd.Flags().IntVar(&informerMaxRetries, "max-retries", 5, "delivery retries per object before its events are dropped")
+	informerCmd.Flags().StringVar(&informerRecordPath, "record", "", "record every watch event with its full object to a JSON lines file")
+	informerCmd.Flags().StringVar(&informerMetricsAddr, "metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9090 (disabled when empty)")
+	informerCmd.Flags().DurationVar(&informerCoalesce, "coalesce-window", 0, "merge the updates of an object within this window into one event (0 disables)")
+	informerCmd.Flags().DurationVar(&informerSummaryEvery, "summary-interval", 0, "periodically log ready and unready deployments per namespace (0 disables)")
+	informerCmd.Flags().BoolVar(&informerMetadataOnly, "metadata-only", false, "cache only object metadata and report name, label and annotation changes")
+
+	// The informer never reads managedFields or kubectl's last-applied copy
+	informerOpts.transform = stripMetadata
 }
 
 // runInformer starts the deployment informer
 func runInformer() error {
-	// Create Kubernetes client
-	client, err := createClient()
+	if err := informerOpts.validate(); err != nil {
+		return err
+	}
+	if informerWorkers < 1 {
+		return fmt.Errorf("--workers must be at least 1")
+	}
+	if informerCoalesce < 0 || informerSummaryEvery < 0 {
+		return fmt.Errorf("--coalesce-window and --summary-interval must not be negative")
+	}
+	if informerMetadataOnly && informerSummaryEvery > 0 {
+		return fmt.Errorf("--summary-interval needs deployment status and cannot be used with --metadata-only")
+	}
+
+	// Create one informer factory per watched namespace
+	config, err := createConfig()
 	if err != nil {
 		return fmt.Errorf("failed to create client: %w", err)
 	}
+	sources, err := newInformerSources(config, &informerOpts)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+	// --follow-owners selects pods and ReplicaSets by their owning deployment,
+	// so their informers watch without the selectors
+	ownedSources, allSources := sources, sources
+	if informerFollowOwners && (informerOpts.labelSelector != "" || informerOpts.fieldSelector != "") {
+		unfiltered := informerOpts.withoutSelectors()
+		if ownedSources, err = newInformerSources(config, &unfiltered); err != nil {
+			return fmt.Errorf("failed to create client: %w", err)
+		}
+		allSources = append(append([]informerSource(nil), sources...), ownedSources...)
+	}
+
+	// Create event sinks
+	informerSinks, err = parseSinks(informerSinkSpecs)
+	if err != nil {
+		return err
+	}
+
+	resources, err := parseResources(informerResources)
+	if err != nil {
+		return err
+	}
+	if informerMetadataOnly {
+		for i := range resources {
+			resources[i] = resources[i].metadataOnly()
+		}
+	}
+
+	var recorder *eventRecorder
+	if informerRecordPath != "" {
+		if recorder, err = newEventRecorder(informerRecordPath); err != nil {
+			return err
+		}
+		defer recorder.Close()
+	}
+
+	// Handlers only enqueue events; workers deliver them so a slow sink
+	// cannot block the shared informers
+	queue := newEventQueue(informerSinks, informerMaxRetries,
+		workqueue.NewItemExponentialFailureRateLimiter(500*time.Millisecond, time.Minute))
+	emit := queue.add
+	var coalescer *eventCoalescer
+	if informerCoalesce > 0 {
+		coalescer = newEventCoalescer(informerCoalesce, queue.add)
+		emit = coalescer.add
+	}
+
+	// Create the informers of every resource in one factory per watched namespace
+	type registration struct {
+		informer cache.SharedIndexInformer
+		handler  cache.ResourceEventHandler
+	}
+	var registrations []registration
+	// stores are the caches of each kind over all sources, for the cache metrics
+	stores := make(map[string][]cache.Store)
+	// Sources are created in the order of the watched namespaces
+	namespaces := informerOpts.watchedNamespaces()
+	for i, source := range sources {
+		var filter *ownerFilter
+		if informerFollowOwners {
+			if filter, err = newOwnerFilter(source, ownedSources[i]); err != nil {
+				return err
+			}
+		}
+		for _, resource := range resources {
+			resourceSource := source
+			if filter.includeFor(resource.kind) != nil {
+				resourceSource = ownedSources[i]
+			}
+			informer, err := resourceSource.informerFor(resource)
+			if err != nil {
+				return err
+			}
+			stores[resource.kind] = append(stores[resource.kind], informer.GetStore())
+			registrations = append(registrations, registration{
+				informer: informer,
+				handler:  resourceEventHandler(resource, filter.includeFor(resource.kind), emit),
+			})
+			if recorder != nil {
+				registrations = append(registrations, registration{
+					informer: informer,
+					handler:  recordingEventHandler(resource.kind, recorder),
+				})
+			}
+			if informerMetricsAddr != "" {
+				registrations = append(registrations, registration{
+					informer: informer,
+					handler:  metricsEventHandler(resource.kind),
+				})
+			}
+			if err := handleWatchErrors(informer, resource.kind, namespaces[i], resource.gvr.Resource); err != nil {
+				return err
+			}
+		}
+	}
+
+	klog.Infof("Starting informer for %s in %s", strings.Join(informerResources, ", "), informerOpts.describeNamespaces())
+	if recorder != nil {
+		klog.Infof("Recording watch events to %s", informerRecordPath)
+	}
+
+	// Start event workers and informers. The workers outlive the informers so
+	// the pending events are still delivered on shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	queue.run(context.Background(), informerWorkers)
+	var deploymentStores []cache.Store
+	if informerSummaryEvery > 0 {
+		for _, source := range sources {
+			deployments, err := source.informerFor(watchableResources["deployments"])
+			if err != nil {
+				return err
+			}
+			deploymentStores = append(deploymentStores, deployments.GetStore())
+		}
+	}
+
+	if informerMetricsAddr != "" {
+		for _, resource := range resources {
+			registerCacheMetrics(resource.kind, stores[resource.kind]...)
+		}
+		serveMetrics(informerMetricsAddr)
+	}
+	started := time.Now()
+	for _, source := range allSources {
+		source.Start(ctx.Done())
+	}
+
+	// Wait for cache sync
+	for _, source := range allSources {
+		if err := source.waitForCacheSync(ctx.Done()); err != nil {
+			return err
+		}
+	}
+	observeInitialSync(started)
+
+	// Add event handlers once the caches are warm, so owner lookups see every
+	// deployment; the informers replay existing objects as ADDED events
+	for _, r := range registrations {
+		if _, err := r.informer.AddEventHandler(r.handler); err != nil {
+			return fmt.Errorf("failed to add event handler: %w", err)
+		}
+	}
 
-	// Create informer
-	informerFactory := informers.NewSharedInformerFactoryWithOptions(
-		client,
-		30*time.Second,
-		informers.WithNamespace(namespace),
-	)
+	if informerSummaryEvery > 0 {
+		go wait.Until(func() { logNamespaceSummary(deploymentStores) }, informerSummaryEvery, ctx.Done())
+	}
 
-	deploymentInformer := informerFactory.Apps().V1().Deployments().Informer()
+	klog.Info("Informer running! Press Ctrl+C to stop...")
+	<-ctx.Done()
+
+	klog.Info("Shutting down, delivering pending events")
+	if coalescer != nil {
+		coalescer.flush()
+	}
+	drainCtx, cancel := context.WithTimeout(context.Background(), eventQueueDrainTimeout)
+	defer cancel()
+	queue.drain(drainCtx)
+	return nil
+}
+
+// Event types reported by the informer
+const (
+	eventAdded   = "ADDED"
+	eventUpdated = "UPDATED"
+	eventDeleted = "DELETED"
+)
 
-	// Add simple event handlers
-	deploymentInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+// informerEvent is a single informer notification as delivered to logs and sinks
+type informerEvent struct {
+	Type              string      `json:"type"`
+	Kind              string      `json:"kind"`
+	Namespace         string      `json:"namespace"`
+	Name              string      `json:"name"`
+	Time              time.Time   `json:"time"`
+	Summary           string      `json:"summary,omitempty"`
+	Changes           []string    `json:"changes,omitempty"`
+	FinalStateUnknown bool        `json:"finalStateUnknown,omitempty"`
+	Updates           int         `json:"updates,omitempty"`
+	Object            interface{} `json:"object,omitempty"`
+
+	// previous is the object before an update and diff the diff of its
+	// resource, typed or metadata-only, used to coalesce updates
+	previous interface{}
+	diff     func(oldObj, newObj interface{}) []fieldChange
+}
+
+// String renders the event as a log line, e.g. "Deployment UPDATED: default/web replicas: 3→5".
+// Coalesced updates are prefixed with their count: "... default/web 14 updates, replicas: 3→5".
+func (e informerEvent) String() string {
+	line := fmt.Sprintf("%s %s: %s/%s", e.Kind, e.Type, e.Namespace, e.Name)
+	if e.Updates > 1 {
+		line += fmt.Sprintf(" %d updates,", e.Updates)
+	}
+	if len(e.Changes) > 0 {
+		line += " " + strings.Join(e.Changes, ", ")
+	} else if e.Summary != "" {
+		line += " " + e.Summary
+	}
+	if e.FinalStateUnknown {
+		line += " (finalStateUnknown=true)"
+	}
+	return line
+}
+
+// newObjectEvent builds an informer event for an object of the given kind
+func newObjectEvent(eventType, kind string, object metav1.Object) informerEvent {
+	return informerEvent{
+		Type:      eventType,
+		Kind:      kind,
+		Namespace: object.GetNamespace(),
+		Name:      object.GetName(),
+		Time:      time.Now().UTC(),
+		Object:    object,
+	}
+}
+
+// resourceEventHandler turns notifications for one resource kind into events,
+// skipping resyncs and summarizing updates as diffs. Objects rejected by
+// include, when set, are not reported.
+func resourceEventHandler(resource watchedResource, include func(metav1.Object) bool, emit func(informerEvent)) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			deployment := obj.(*appsv1.Deployment)
-			klog.Infof("Deployment ADDED: %s/%s", deployment.Namespace, deployment.Name)
+			object, _, err := objectFromEvent(obj)
+			if err != nil {
+				klog.Errorf("%s ADDED: %v", resource.kind, err)
+				return
+			}
+			if include != nil && !include(object) {
+				return
+			}
+			event := newObjectEvent(eventAdded, resource.kind, object)
+			event.Summary = resource.describe(object)
+			emit(event)
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
-			deployment := newObj.(*appsv1.Deployment)
-			klog.Infof("Deployment UPDATED: %s/%s", deployment.Namespace, deployment.Name)
+			oldObject, _, err := objectFromEvent(oldObj)
+			if err != nil {
+				klog.Errorf("%s UPDATED: %v", resource.kind, err)
+				return
+			}
+			object, _, err := objectFromEvent(newObj)
+			if err != nil {
+				klog.Errorf("%s UPDATED: %v", resource.kind, err)
+				return
+			}
+
+			// Periodic resyncs redeliver the cached object unchanged
+			if oldObject.GetResourceVersion() == object.GetResourceVersion() {
+				return
+			}
+			if include != nil && !include(object) {
+				return
+			}
+
+			changes := resource.diff(oldObject, object)
+			if len(changes) == 0 {
+				klog.V(2).Infof("%s UPDATED: %s/%s (no tracked field changes)", resource.kind, object.GetNamespace(), object.GetName())
+				return
+			}
+			if !informerStatusChanges && !hasSpecChanges(changes) {
+				return
+			}
+
+			event := newObjectEvent(eventUpdated, resource.kind, object)
+			event.Summary = resource.describe(object)
+			event.previous, event.diff = oldObject, resource.diff
+			for _, change := range changes {
+				event.Changes = append(event.Changes, change.String())
+			}
+			emit(event)
 		},
 		DeleteFunc: func(obj interface{}) {
-			deployment := obj.(*appsv1.Deployment)
-			klog.Infof("Deployment DELETED: %s/%s", deployment.Namespace, deployment.Name)
+			object, finalStateUnknown, err := objectFromEvent(obj)
+			if err != nil {
+				klog.Errorf("%s DELETED: %v", resource.kind, err)
+				return
+			}
+			if include != nil && !include(object) {
+				return
+			}
+			event := newObjectEvent(eventDeleted, resource.kind, object)
+			event.Summary = resource.describe(object)
+			event.FinalStateUnknown = finalStateUnknown
+			emit(event)
 		},
-	})
-
-	klog.Infof("Starting informer for deployments in namespace: %s", namespace)
-
-	// Start informer
-	ctx := context.Background()
-	informerFactory.Start(ctx.Done())
+	}
+}
 
-	// Wait for cache sync
-	if !cache.WaitForCacheSync(ctx.Done(), deploymentInformer.HasSynced) {
-		return fmt.Errorf("failed to sync cache")
+// logNamespaceSummary logs the ready and unready deployments of every namespace
+func logNamespaceSummary(stores []cache.Store) {
+	summary := summarizeDeployments(stores...)
+	if len(summary) == 0 {
+		klog.Info("Namespace summary: no deployments")
+		return
+	}
+	for _, totals := range summary {
+		klog.Infof("Namespace summary: %s", totals)
 	}
+}
 
-	klog.Info("Informer running! Press Ctrl+C to stop...")
+// newInformerSources creates one informer source per watched namespace, with
+// metadata-only informers when --metadata-only is set
+func newInformerSources(config *rest.Config, opts *informerOptions) ([]informerSource, error) {
+	var sources []informerSource
+	if informerMetadataOnly {
+		client, err := metadata.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		for _, factory := range opts.newMetadataFactories(client) {
+			sources = append(sources, metadataInformerSource{SharedInformerFactory: factory, transform: opts.transform})
+		}
+		return sources, nil
+	}
 
-	// Keep running
-	select {}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, factory := range opts.newFactories(client) {
+		sources = append(sources, typedInformerSource{factory})
+	}
+	return sources, nil
 }
 
-// createClient creates a simple Kubernetes client
-func createClient() (kubernetes.Interface, error) {
+// createConfig builds the client configuration from the informer's kubeconfig
+func createConfig() (*rest.Config, error) {
 	if informerKubeconfig == "" {
 		if home := homedir.HomeDir(); home != "" {
 			informerKubeconfig = filepath.Join(home, ".kube", "config")
@@ -108,6 +451,7 @@ func createClient() (kubernetes.Interface, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	return kubernetes.NewForConfig(config)
+	countWatchRestarts(config)
+	trackConnection(config)
+	return config, nil
 }
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// informerOptions configures the shared informer factories of a command
+type informerOptions struct {
+	resyncPeriod  time.Duration
+	labelSelector string
+	fieldSelector string
+	allNamespaces bool
+	namespaces    []string
+
+	// transform, when set, is installed on every informer of the factories
+	transform cache.TransformFunc
+}
+
+// addFlags registers the resync, selector and all-namespaces flags.
+// Commands register their own --namespace flag since some accept several.
+func (o *informerOptions) addFlags(flags *pflag.FlagSet) {
+	flags.DurationVar(&o.resyncPeriod, "resync-period", 30*time.Second, "informer resync period (0 disables resync)")
+	flags.StringVar(&o.labelSelector, "label-selector", "", "only watch objects matching this label selector")
+	flags.StringVar(&o.fieldSelector, "field-selector", "", "only watch objects matching this field selector")
+	flags.BoolVar(&o.allNamespaces, "all-namespaces", false, "watch all namespaces with a single cluster-wide cache")
+}
+
+// validate checks the selectors and resync period before any factory is built
+func (o *informerOptions) validate() error {
+	if o.resyncPeriod < 0 {
+		return fmt.Errorf("--resync-period must not be negative")
+	}
+	if _, err := labels.Parse(o.labelSelector); err != nil {
+		return fmt.Errorf("invalid --label-selector: %w", err)
+	}
+	if _, err := fields.ParseSelector(o.fieldSelector); err != nil {
+		return fmt.Errorf("invalid --field-selector: %w", err)
+	}
+	if !o.allNamespaces && len(o.watchedNamespaces()) == 0 {
+		return fmt.Errorf("at least one --namespace is required unless --all-namespaces is set")
+	}
+	return nil
+}
+
+// watchedNamespaces returns the deduplicated namespaces to start factories for
+func (o *informerOptions) watchedNamespaces() []string {
+	if o.allNamespaces {
+		return []string{metav1.NamespaceAll}
+	}
+
+	seen := make(map[string]bool, len(o.namespaces))
+	var namespaces []string
+	for _, ns := range o.namespaces {
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces
+}
+
+// describeNamespaces renders the watched namespaces for log messages
+func (o *informerOptions) describeNamespaces() string {
+	if o.allNamespaces {
+		return "all namespaces"
+	}
+	return fmt.Sprintf("namespaces %v", o.watchedNamespaces())
+}
+
+// newFactory creates a shared informer factory for a single namespace
+func (o *informerOptions) newFactory(client kubernetes.Interface, namespace string) informers.SharedInformerFactory {
+	options := []informers.SharedInformerOption{
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(o.tweakListOptions),
+	}
+	if o.transform != nil {
+		options = append(options, informers.WithTransform(o.transform))
+	}
+	return informers.NewSharedInformerFactoryWithOptions(client, o.resyncPeriod, options...)
+}
+
+// withoutSelectors returns a copy of the options that watches every object
+// of the same namespaces
+func (o informerOptions) withoutSelectors() informerOptions {
+	o.labelSelector = ""
+	o.fieldSelector = ""
+	return o
+}
+
+// tweakListOptions applies the selectors to the informers' list and watch calls
+func (o *informerOptions) tweakListOptions(options *metav1.ListOptions) {
+	options.LabelSelector = o.labelSelector
+	options.FieldSelector = o.fieldSelector
+}
+
+// newFactories creates one shared informer factory per watched namespace
+func (o *informerOptions) newFactories(client kubernetes.Interface) []informers.SharedInformerFactory {
+	var factories []informers.SharedInformerFactory
+	for _, ns := range o.watchedNamespaces() {
+		factories = append(factories, o.newFactory(client, ns))
+	}
+	return factories
+}
+
+// newMetadataFactories creates one metadata-only informer factory per watched namespace
+func (o *informerOptions) newMetadataFactories(client metadata.Interface) []metadatainformer.SharedInformerFactory {
+	var factories []metadatainformer.SharedInformerFactory
+	for _, ns := range o.watchedNamespaces() {
+		factories = append(factories, metadatainformer.NewFilteredSharedInformerFactory(client, o.resyncPeriod, ns, o.tweakListOptions))
+	}
+	return factories
+}
+
+// informerSource hands out the informers of one shared factory, either with
+// full typed objects or metadata only
+type informerSource interface {
+	informerFor(resource watchedResource) (cache.SharedIndexInformer, error)
+	Start(stopCh <-chan struct{})
+	waitForCacheSync(stopCh <-chan struct{}) error
+}
+
+// typedInformerSource serves full objects from a typed shared informer factory
+type typedInformerSource struct {
+	informers.SharedInformerFactory
+}
+
+func (s typedInformerSource) informerFor(resource watchedResource) (cache.SharedIndexInformer, error) {
+	return resource.informer(s.SharedInformerFactory), nil
+}
+
+func (s typedInformerSource) waitForCacheSync(stopCh <-chan struct{}) error {
+	for informerType, synced := range s.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("failed to sync cache for %v", informerType)
+		}
+	}
+	return nil
+}
+
+// metadataInformerSource serves PartialObjectMetadata from a metadata informer factory
+type metadataInformerSource struct {
+	metadatainformer.SharedInformerFactory
+	transform cache.TransformFunc
+}
+
+// informerFor installs the transform on the informer, which fails once the
+// informer has started
+func (s metadataInformerSource) informerFor(resource watchedResource) (cache.SharedIndexInformer, error) {
+	informer := s.ForResource(resource.gvr).Informer()
+	if s.transform != nil {
+		if err := informer.SetTransform(s.transform); err != nil {
+			return nil, fmt.Errorf("failed to set the transform of the %s informer: %w", resource.gvr.Resource, err)
+		}
+	}
+	return informer, nil
+}
+
+func (s metadataInformerSource) waitForCacheSync(stopCh <-chan struct{}) error {
+	for gvr, synced := range s.WaitForCacheSync(stopCh) {
+		if !synced {
+			return fmt.Errorf("failed to sync cache for %v", gvr)
+		}
+	}
+	return nil
+}
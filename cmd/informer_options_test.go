@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestInformerOptions_Validate(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    informerOptions
+		wantErr string
+	}{
+		{"defaults", informerOptions{namespaces: []string{"default"}}, ""},
+		{"all namespaces", informerOptions{allNamespaces: true}, ""},
+		{"negative resync", informerOptions{namespaces: []string{"default"}, resyncPeriod: -1}, "resync-period"},
+		{"bad label selector", informerOptions{namespaces: []string{"default"}, labelSelector: "team in (a"}, "label-selector"},
+		{"bad field selector", informerOptions{namespaces: []string{"default"}, fieldSelector: "metadata.name"}, "field-selector"},
+		{"no namespace", informerOptions{namespaces: []string{""}}, "--namespace"},
+	}
+
+	for _, c := range cases {
+		err := c.opts.validate()
+		switch {
+		case c.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		case c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)):
+			t.Errorf("%s: expected error mentioning %q, got %v", c.name, c.wantErr, err)
+		}
+	}
+}
+
+func TestInformerOptions_WatchedNamespaces(t *testing.T) {
+	opts := informerOptions{namespaces: []string{"team-a", "team-b", "team-a"}}
+	if got := opts.watchedNamespaces(); strings.Join(got, ",") != "team-a,team-b" {
+		t.Errorf("Expected deduplicated namespaces, got %v", got)
+	}
+
+	opts.allNamespaces = true
+	if got := opts.watchedNamespaces(); len(got) != 1 || got[0] != metav1.NamespaceAll {
+		t.Errorf("Expected a single cluster-wide namespace, got %v", got)
+	}
+}
+
+func TestInformerOptions_NewFactoriesAppliesSelectors(t *testing.T) {
+	client := fake.NewSimpleClientset()
+
+	var mu sync.Mutex
+	listed := map[string]metav1.ListOptions{}
+	client.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		list := action.(k8stesting.ListActionImpl)
+		mu.Lock()
+		listed[list.GetNamespace()] = metav1.ListOptions{
+			LabelSelector: list.GetListRestrictions().Labels.String(),
+			FieldSelector: list.GetListRestrictions().Fields.String(),
+		}
+		mu.Unlock()
+		return false, nil, nil
+	})
+
+	opts := informerOptions{
+		namespaces:    []string{"team-a", "team-b"},
+		labelSelector: "team=payments",
+		fieldSelector: "metadata.name=web",
+	}
+	factories := opts.newFactories(client)
+	if len(factories) != 2 {
+		t.Fatalf("Expected one factory per namespace, got %d", len(factories))
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	var synced []cache.InformerSynced
+	for _, factory := range factories {
+		synced = append(synced, factory.Apps().V1().Deployments().Informer().HasSynced)
+		factory.Start(stopCh)
+	}
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		t.Fatal("Failed to sync informers")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, ns := range opts.namespaces {
+		options, ok := listed[ns]
+		if !ok {
+			t.Errorf("Expected a list call in namespace %s", ns)
+			continue
+		}
+		if options.LabelSelector != "team=payments" || options.FieldSelector != "metadata.name=web" {
+			t.Errorf("Expected selectors to be applied in %s, got %+v", ns, options)
+		}
+	}
+}
@@ -5,16 +5,27 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 )
 
-var kubeconfig string
+var (
+	kubeconfig    string
+	listUseCache  bool
+	listImage     string
+	listLabels    []string
+	listTeam      string
+	listReadiness string
+)
 
 // listCmd represents the list command
 var listCmd = &cobra.Command{
@@ -34,7 +45,12 @@ var deploymentsCmd = &cobra.Command{
 	Long: `List all deployments in the default namespace using the configured kubeconfig.
 	
 This command will connect to your Kubernetes cluster and display all deployments
-in the default namespace with their basic information.`,
+in the default namespace with their basic information.
+
+Filters:
+  k8s-controller list deployments --image=nginx               # Running an image, with or without tag
+  k8s-controller list deployments --label=app=web --readiness=partial
+  k8s-controller list deployments --team=payments --cache     # Answer from indexed informer cache`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := listDeployments(); err != nil {
 			fmt.Printf("Error listing deployments: %v\n", err)
@@ -49,6 +65,12 @@ func init() {
 
 	// Add kubeconfig flag to the list command
 	listCmd.PersistentFlags().StringVar(&kubeconfig, "kubeconfig", "", "path to kubeconfig file (default is $HOME/.kube/config)")
+
+	deploymentsCmd.Flags().BoolVar(&listUseCache, "cache", false, "sync an informer cache and answer filters from its indexes")
+	deploymentsCmd.Flags().StringVar(&listImage, "image", "", "only deployments running this image (with or without tag)")
+	deploymentsCmd.Flags().StringArrayVar(&listLabels, "label", nil, "only deployments with this key=value label (repeatable)")
+	deploymentsCmd.Flags().StringVar(&listTeam, "team", "", "only deployments whose team or owner annotation matches")
+	deploymentsCmd.Flags().StringVar(&listReadiness, "readiness", "", "only deployments in this state: ready, partial, unavailable, scaled-down")
 }
 
 // createKubernetesClient creates a Kubernetes client using the provided kubeconfig
@@ -95,16 +117,34 @@ func listDeployments() error {
 		return err
 	}
 
-	// List deployments in default namespace
-	deployments, err := clientset.AppsV1().Deployments("default").List(context.TODO(), metav1.ListOptions{})
+	filters, err := parseIndexFilters(listImage, listLabels, listTeam, listReadiness)
 	if err != nil {
-		return fmt.Errorf("failed to list deployments: %w", err)
+		return err
+	}
+
+	// List deployments in default namespace
+	var deployments []*appsv1.Deployment
+	if listUseCache {
+		deployments, err = listCachedDeployments(clientset, filters)
+		if err != nil {
+			return err
+		}
+	} else {
+		list, err := clientset.AppsV1().Deployments("default").List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list deployments: %w", err)
+		}
+		for i := range list.Items {
+			if matchesIndexFilters(&list.Items[i], filters) {
+				deployments = append(deployments, &list.Items[i])
+			}
+		}
 	}
 
 	// Display results
-	fmt.Printf("Found %d deployments in default namespace:\n\n", len(deployments.Items))
+	fmt.Printf("Found %d deployments in default namespace:\n\n", len(deployments))
 
-	if len(deployments.Items) == 0 {
+	if len(deployments) == 0 {
 		fmt.Println("No deployments found in default namespace.")
 		return nil
 	}
@@ -114,21 +154,13 @@ func listDeployments() error {
 	fmt.Println("----------------------------------------------------------------------------------------------")
 
 	// Print deployment details
-	for _, deployment := range deployments.Items {
+	for _, deployment := range deployments {
 		ready := fmt.Sprintf("%d/%d", deployment.Status.ReadyReplicas, deployment.Status.Replicas)
 		upToDate := fmt.Sprintf("%d", deployment.Status.UpdatedReplicas)
 		available := fmt.Sprintf("%d", deployment.Status.AvailableReplicas)
 
 		// Calculate age
-		age := metav1.Now().Sub(deployment.CreationTimestamp.Time)
-		ageStr := ""
-		if age.Hours() >= 24 {
-			ageStr = fmt.Sprintf("%.0fd", age.Hours()/24)
-		} else if age.Hours() >= 1 {
-			ageStr = fmt.Sprintf("%.0fh", age.Hours())
-		} else {
-			ageStr = fmt.Sprintf("%.0fm", age.Minutes())
-		}
+		ageStr := formatAge(metav1.Now().Sub(deployment.CreationTimestamp.Time))
 
 		fmt.Printf("%-30s %-10s %-10s %-10s %-15s\n",
 			deployment.Name,
@@ -140,3 +172,31 @@ func listDeployments() error {
 
 	return nil
 }
+
+// listCachedDeployments syncs an indexed deployment informer for the default
+// namespace and answers the filters from its indexes
+func listCachedDeployments(clientset kubernetes.Interface, filters []indexFilter) ([]*appsv1.Deployment, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace("default"))
+	deploymentInformer := factory.Apps().V1().Deployments().Informer()
+	if err := addDeploymentIndexers(deploymentInformer); err != nil {
+		return nil, err
+	}
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), deploymentInformer.HasSynced) {
+		return nil, fmt.Errorf("failed to sync deployment cache")
+	}
+	return queryDeployments(deploymentInformer.GetIndexer(), filters)
+}
+
+// formatAge renders a duration the way kubectl prints resource ages
+func formatAge(age time.Duration) string {
+	if age.Hours() >= 24 {
+		return fmt.Sprintf("%.0fd", age.Hours()/24)
+	} else if age.Hours() >= 1 {
+		return fmt.Sprintf("%.0fh", age.Hours())
+	}
+	return fmt.Sprintf("%.0fm", age.Minutes())
+}
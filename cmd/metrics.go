@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// metricsNamespace prefixes the metrics defined by this tool
+const metricsNamespace = "k8s_controller"
+
+// Informer and cache metrics. They live in controller-runtime's registry,
+// which already carries the client-go workqueue and REST client metrics.
+var (
+	informerEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "informer_events_total",
+		Help:      "Informer notifications by event type, namespace and kind, excluding resyncs.",
+	}, []string{"type", "namespace", "kind"})
+
+	informerLastEventTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "informer_last_event_timestamp_seconds",
+		Help:      "Unix time of the last informer notification by kind.",
+	}, []string{"kind"})
+
+	informerWatchErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "informer_watch_errors_total",
+		Help:      "List and watch errors reported by the informers by kind and reason.",
+	}, []string{"kind", "reason"})
+
+	informerWatchRestartsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "informer_watch_restarts_total",
+		Help:      "Watch requests that follow a failed or short watch of the same collection, by resource.",
+	}, []string{"resource"})
+
+	informerInitialSyncSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "informer_initial_sync_duration_seconds",
+		Help:      "Time from starting the informers until their caches synced.",
+	})
+
+	connectionStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "connection_state",
+		Help:      "1 for the current state of the API server connection (connected, degraded, disconnected), 0 for the others.",
+	}, []string{"state"})
+)
+
+var registerMetricsOnce sync.Once
+
+// registerMetrics adds the informer metrics to the registry. No reflector
+// metrics provider is installed: the client-go version in use never reports
+// through it, and watch restarts come from countWatchRestarts instead.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		metrics.Registry.MustRegister(informerEventsTotal, informerLastEventTimestamp,
+			informerWatchErrorsTotal, informerWatchRestartsTotal, informerInitialSyncSeconds, connectionStateGauge,
+			apiRequestsTotal, apiRequestDuration, apiResponseSize, apiRequestsInFlight)
+	})
+}
+
+// serveMetrics exposes the registry on addr at /metrics in the background
+func serveMetrics(addr string) {
+	registerMetrics()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	go func() {
+		klog.Infof("Serving metrics on http://%s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			klog.Errorf("Metrics server failed: %v", err)
+		}
+	}()
+}
+
+// registerCacheMetrics exports the number of cached objects of a kind, summed over stores
+func registerCacheMetrics(kind string, stores ...cache.Store) {
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        "cache_objects",
+		Help:        "Objects in the informer cache by kind.",
+		ConstLabels: prometheus.Labels{"kind": kind},
+	}, func() float64 {
+		total := 0
+		for _, store := range stores {
+			total += len(store.ListKeys())
+		}
+		return float64(total)
+	})
+	if err := metrics.Registry.Register(gauge); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if !errors.As(err, &already) {
+			klog.Errorf("Failed to register cache metrics for %s: %v", kind, err)
+		}
+	}
+}
+
+// metricsEventHandler counts informer notifications of a kind. Resyncs of
+// unchanged objects are not counted.
+func metricsEventHandler(kind string) cache.ResourceEventHandlerFuncs {
+	count := func(eventType string, obj interface{}) {
+		object, _, err := objectFromEvent(obj)
+		if err != nil {
+			return
+		}
+		informerEventsTotal.WithLabelValues(eventType, object.GetNamespace(), kind).Inc()
+		informerLastEventTimestamp.WithLabelValues(kind).SetToCurrentTime()
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { count(eventAdded, obj) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldObject, _, errOld := objectFromEvent(oldObj)
+			newObject, _, errNew := objectFromEvent(newObj)
+			if errOld == nil && errNew == nil && oldObject.GetResourceVersion() == newObject.GetResourceVersion() {
+				return
+			}
+			count(eventUpdated, newObj)
+		},
+		DeleteFunc: func(obj interface{}) { count(eventDeleted, obj) },
+	}
+}
+
+// observeInitialSync records how long the informers took to sync since start
+func observeInitialSync(start time.Time) {
+	informerInitialSyncSeconds.Set(time.Since(start).Seconds())
+}
+
+// shortWatch is how long a watch must last to count as healthy. Reflectors
+// treat quicker watches without events as a sign of a problem too.
+const shortWatch = time.Second
+
+// countWatchRestarts wraps the client transport to count watch restarts.
+// Reflectors re-issue the watch of a collection whenever it ends, which the
+// API server does routinely after the watch timeout. Only a watch that
+// follows a failed or short watch of the same URL path is a restart.
+func countWatchRestarts(config *rest.Config) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &watchRestartCounter{next: rt, now: time.Now, last: make(map[string]*watchRecord)}
+	})
+}
+
+// watchRestartCounter is the round tripper installed by countWatchRestarts
+type watchRestartCounter struct {
+	next http.RoundTripper
+	now  func() time.Time
+
+	mu   sync.Mutex
+	last map[string]*watchRecord
+}
+
+// watchRecord is the outcome of the last watch of a URL path
+type watchRecord struct {
+	started, ended time.Time
+	failed         bool
+}
+
+// unhealthyLocked reports whether the watch ended early or with an error
+func (w *watchRecord) unhealthyLocked() bool {
+	return w.failed || (!w.ended.IsZero() && w.ended.Sub(w.started) < shortWatch)
+}
+
+func (c *watchRestartCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if watch := req.URL.Query().Get("watch"); watch != "true" && watch != "1" {
+		return c.next.RoundTrip(req)
+	}
+
+	record := &watchRecord{started: c.now()}
+	c.mu.Lock()
+	previous := c.last[req.URL.Path]
+	restarted := previous != nil && previous.unhealthyLocked()
+	c.last[req.URL.Path] = record
+	c.mu.Unlock()
+	if restarted {
+		informerWatchRestartsTotal.WithLabelValues(path.Base(req.URL.Path)).Inc()
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil || resp.StatusCode >= http.StatusMultipleChoices || resp.Body == nil {
+		c.end(record, err != nil || resp.StatusCode >= http.StatusMultipleChoices)
+		return resp, err
+	}
+	resp.Body = &watchBody{ReadCloser: resp.Body, counter: c, record: record}
+	return resp, nil
+}
+
+// end records how a watch ended, once
+func (c *watchRestartCounter) end(record *watchRecord, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !record.ended.IsZero() {
+		return
+	}
+	record.ended, record.failed = c.now(), failed
+}
+
+// watchBody ends the watch record when the stream ends: cleanly at EOF or
+// when the reflector closes it, as a failure on any other read error
+type watchBody struct {
+	io.ReadCloser
+	counter *watchRestartCounter
+	record  *watchRecord
+}
+
+func (b *watchBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.counter.end(b.record, err != io.EOF)
+	}
+	return n, err
+}
+
+func (b *watchBody) Close() error {
+	b.counter.end(b.record, false)
+	return b.ReadCloser.Close()
+}
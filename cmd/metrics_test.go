@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+func TestMetricsEventHandler(t *testing.T) {
+	handler := metricsEventHandler("Deployment")
+
+	deployment := newTestDeployment("web", 3, 3)
+	deployment.Namespace = "metrics-test"
+	deployment.ResourceVersion = "1"
+	handler.OnAdd(deployment, true)
+
+	// Resyncs are not counted
+	handler.OnUpdate(deployment, deployment.DeepCopy())
+
+	scaled := deployment.DeepCopy()
+	scaled.ResourceVersion = "2"
+	handler.OnUpdate(deployment, scaled)
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "metrics-test/web", Obj: scaled})
+
+	for _, eventType := range []string{eventAdded, eventUpdated, eventDeleted} {
+		if got := testutil.ToFloat64(informerEventsTotal.WithLabelValues(eventType, "metrics-test", "Deployment")); got != 1 {
+			t.Errorf("Expected one %s event, got %v", eventType, got)
+		}
+	}
+	if testutil.ToFloat64(informerLastEventTimestamp.WithLabelValues("Deployment")) == 0 {
+		t.Error("Expected the last event timestamp to be set")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestCountWatchRestarts(t *testing.T) {
+	config := &rest.Config{}
+	countWatchRestarts(config)
+	status, readErr := http.StatusOK, io.EOF
+	rt := config.WrapTransport(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(iotest.ErrReader(readErr))}, nil
+	}))
+	now := time.Now()
+	rt.(*watchRestartCounter).now = func() time.Time { return now }
+
+	restarts := informerWatchRestartsTotal.WithLabelValues("statefulsets")
+	before := testutil.ToFloat64(restarts)
+	// watch runs a watch of url that lasts d
+	watch := func(url string, d time.Duration) {
+		req, _ := http.NewRequest("GET", url, nil)
+		resp, _ := rt.RoundTrip(req)
+		now = now.Add(d)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	restarted := func(msg string, want float64) {
+		t.Helper()
+		if got := testutil.ToFloat64(restarts) - before; got != want {
+			t.Errorf("%s: expected %v restarts, got %v", msg, want, got)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "https://cluster/apis/apps/v1/namespaces/a/statefulsets?limit=500", nil)
+	rt.RoundTrip(req)
+	watch("https://cluster/apis/apps/v1/namespaces/a/statefulsets?watch=true", 5*time.Minute)
+	watch("https://cluster/apis/apps/v1/namespaces/b/statefulsets?watch=true", 5*time.Minute)
+	watch("https://cluster/apis/apps/v1/namespaces/a/statefulsets?watch=true&resourceVersion=42", 10*time.Millisecond)
+	restarted("a watch after a timed out watch", 0)
+
+	watch("https://cluster/apis/apps/v1/namespaces/a/statefulsets?watch=true&resourceVersion=43", 5*time.Minute)
+	restarted("a watch after a short watch", 1)
+
+	readErr = io.ErrUnexpectedEOF
+	watch("https://cluster/apis/apps/v1/namespaces/b/statefulsets?watch=true", 5*time.Minute)
+	readErr, status = io.EOF, http.StatusInternalServerError
+	watch("https://cluster/apis/apps/v1/namespaces/b/statefulsets?watch=true", 0)
+	restarted("a watch after a broken stream", 2)
+	status = http.StatusOK
+	watch("https://cluster/apis/apps/v1/namespaces/b/statefulsets?watch=true", 5*time.Minute)
+	restarted("a watch after a failed request", 3)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	registerMetrics()
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(newTestDeployment("web", 1, 1))
+	store.Add(newTestDeployment("api", 1, 1))
+	registerCacheMetrics("MetricsTestKind", store)
+
+	// Creating a named queue registers its workqueue metrics
+	newTestQueue(1)
+
+	rr := httptest.NewRecorder()
+	promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body := rr.Body.String()
+
+	for _, expected := range []string{
+		`k8s_controller_cache_objects{kind="MetricsTestKind"} 2`,
+		`k8s_controller_informer_initial_sync_duration_seconds`,
+		`workqueue_adds_total{name="informer_events"}`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected metrics output to contain %s", expected)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// eventQueueStatsInterval is how often queue statistics are logged
+const eventQueueStatsInterval = 30 * time.Second
+
+// eventQueueDrainTimeout bounds how long shutdown waits for pending events
+const eventQueueDrainTimeout = 30 * time.Second
+
+// queuedEvent is an event waiting for delivery, with the sinks that still need it
+type queuedEvent struct {
+	event    informerEvent
+	sinks    []*filteredSink
+	logged   bool
+	enqueued time.Time
+}
+
+// eventQueue decouples informer handlers from event delivery. Handlers record
+// events per kind/namespace/name key and enqueue the key; workers drain the queue
+// and deliver the events to the sinks, retrying failing sinks with per-item
+// exponential backoff. Events of one key are delivered in order, so a failing
+// event holds back the later events of the same object until it succeeds or
+// is dropped.
+type eventQueue struct {
+	queue      workqueue.RateLimitingInterface
+	sinks      []*filteredSink
+	maxRetries int
+
+	mu      sync.Mutex
+	pending map[string][]*queuedEvent
+
+	processed    int64
+	retries      int64
+	dropped      int64
+	latencyNanos int64
+	delivered    int64
+}
+
+// newEventQueue creates a queue that delivers events to sinks, giving up on a key after maxRetries
+func newEventQueue(sinks []*filteredSink, maxRetries int, rateLimiter workqueue.RateLimiter) *eventQueue {
+	return &eventQueue{
+		queue: workqueue.NewRateLimitingQueueWithConfig(rateLimiter, workqueue.RateLimitingQueueConfig{
+			Name: "informer_events",
+		}),
+		sinks:      sinks,
+		maxRetries: maxRetries,
+		pending:    make(map[string][]*queuedEvent),
+	}
+}
+
+// add records an event and enqueues its key; it is safe to call from informer handlers
+func (q *eventQueue) add(event informerEvent) {
+	// Objects of different kinds often share a name, such as a deployment and its service
+	key := event.Kind + "/" + event.Namespace + "/" + event.Name
+	if event.Namespace == "" {
+		key = event.Kind + "/" + event.Name
+	}
+
+	q.mu.Lock()
+	q.pending[key] = append(q.pending[key], &queuedEvent{event: event, sinks: q.sinks, enqueued: time.Now()})
+	q.mu.Unlock()
+
+	q.queue.Add(key)
+}
+
+// run starts the workers and the statistics logger, and shuts the queue down when ctx is done
+func (q *eventQueue) run(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, func(ctx context.Context) {
+			for q.processNextItem(ctx) {
+			}
+		}, time.Second)
+	}
+	go wait.UntilWithContext(ctx, func(ctx context.Context) { q.logStats() }, eventQueueStatsInterval)
+
+	go func() {
+		<-ctx.Done()
+		q.queue.ShutDown()
+	}()
+}
+
+// drain waits until every pending event has been delivered or dropped, then
+// shuts the queue down once the workers are idle. When ctx is done first,
+// the remaining events are given up and the queue is shut down right away.
+func (q *eventQueue) drain(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		q.mu.Lock()
+		pending := 0
+		for _, events := range q.pending {
+			pending += len(events)
+		}
+		q.mu.Unlock()
+		if pending == 0 && q.queue.Len() == 0 {
+			q.queue.ShutDownWithDrain()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			klog.Errorf("Giving up on %d undelivered events: %v", pending, ctx.Err())
+			q.queue.ShutDown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNextItem handles one key and reports whether the worker should continue
+func (q *eventQueue) processNextItem(ctx context.Context) bool {
+	item, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer q.queue.Done(item)
+	key := item.(string)
+
+	err := q.process(ctx, key)
+	switch {
+	case err == nil:
+		q.queue.Forget(key)
+	case q.queue.NumRequeues(key) < q.maxRetries:
+		atomic.AddInt64(&q.retries, 1)
+		klog.Warningf("Retrying events for %s (attempt %d/%d): %v", key, q.queue.NumRequeues(key)+1, q.maxRetries, err)
+		q.queue.AddRateLimited(key)
+	default:
+		// Only the head event failed; the events behind it were never tried
+		q.mu.Lock()
+		q.pending[key] = q.pending[key][1:]
+		remaining := len(q.pending[key])
+		if remaining == 0 {
+			delete(q.pending, key)
+		}
+		q.mu.Unlock()
+
+		atomic.AddInt64(&q.dropped, 1)
+		klog.Errorf("Dropping an event for %s after %d retries, %d more pending: %v", key, q.maxRetries, remaining, err)
+		q.queue.Forget(key)
+		if remaining > 0 {
+			q.queue.Add(key)
+		}
+	}
+	return true
+}
+
+// process delivers the pending events of a key in order. On failure the
+// undelivered events, starting with the failed one, are put back in front of
+// the pending list.
+func (q *eventQueue) process(ctx context.Context, key string) error {
+	q.mu.Lock()
+	events := q.pending[key]
+	delete(q.pending, key)
+	q.mu.Unlock()
+
+	var undelivered []*queuedEvent
+	var lastErr error
+	for _, queued := range events {
+		if !queued.logged {
+			klog.Info(queued.event.String())
+			queued.logged = true
+		}
+		if len(undelivered) > 0 {
+			undelivered = append(undelivered, queued)
+			continue
+		}
+
+		remaining, err := dispatchEvent(ctx, queued.sinks, queued.event)
+		if err != nil {
+			queued.sinks = remaining
+			undelivered = append(undelivered, queued)
+			lastErr = err
+			continue
+		}
+
+		atomic.AddInt64(&q.processed, 1)
+		atomic.AddInt64(&q.delivered, 1)
+		atomic.AddInt64(&q.latencyNanos, int64(time.Since(queued.enqueued)))
+	}
+
+	if len(undelivered) > 0 {
+		q.mu.Lock()
+		q.pending[key] = append(undelivered, q.pending[key]...)
+		q.mu.Unlock()
+	}
+	return lastErr
+}
+
+// logStats logs queue depth, retries, drops and the average delivery latency since the last call
+func (q *eventQueue) logStats() {
+	delivered := atomic.SwapInt64(&q.delivered, 0)
+	latency := time.Duration(atomic.SwapInt64(&q.latencyNanos, 0))
+	if delivered > 0 {
+		latency /= time.Duration(delivered)
+	}
+
+	q.mu.Lock()
+	pending := 0
+	for _, events := range q.pending {
+		pending += len(events)
+	}
+	q.mu.Unlock()
+
+	klog.Infof("Event queue: depth=%d pending=%d processed=%d retries=%d dropped=%d avgLatency=%s",
+		q.queue.Len(), pending,
+		atomic.LoadInt64(&q.processed), atomic.LoadInt64(&q.retries), atomic.LoadInt64(&q.dropped),
+		latency.Round(time.Millisecond))
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// flakySink fails the first failures sends and records delivered events
+type flakySink struct {
+	mu        sync.Mutex
+	failures  int
+	delivered []informerEvent
+}
+
+func (s *flakySink) Send(ctx context.Context, event informerEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	s.delivered = append(s.delivered, event)
+	return nil
+}
+
+func (s *flakySink) Close() error { return nil }
+
+func (s *flakySink) events() []informerEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]informerEvent(nil), s.delivered...)
+}
+
+func newTestQueue(maxRetries int, sinks ...*flakySink) *eventQueue {
+	var filtered []*filteredSink
+	for _, sink := range sinks {
+		filtered = append(filtered, &filteredSink{name: "flaky", eventSink: sink})
+	}
+	return newEventQueue(filtered, maxRetries,
+		workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, 10*time.Millisecond))
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEventQueue_KeysByKind(t *testing.T) {
+	sink := &flakySink{failures: 1}
+	queue := newTestQueue(0, sink)
+	deployment := informerEvent{Type: eventAdded, Kind: "Deployment", Namespace: "default", Name: "web"}
+	service := informerEvent{Type: eventAdded, Kind: "Service", Namespace: "default", Name: "web"}
+	queue.add(deployment)
+	queue.add(service)
+	if got := queue.queue.Len(); got != 2 {
+		t.Fatalf("Expected a key per kind, got %d keys", got)
+	}
+
+	// Dropping the failed deployment event leaves the service event alone
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.run(ctx, 1)
+	waitFor(t, func() bool { return len(sink.events()) == 1 })
+	if got := sink.events()[0]; got.Kind != "Service" {
+		t.Errorf("Expected the service event to be delivered, got %+v", got)
+	}
+}
+
+func TestEventQueue_RetriesFailingSinkInOrder(t *testing.T) {
+	sink := &flakySink{failures: 2}
+	q := newTestQueue(5, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q.add(testEvent(eventAdded, "default"))
+	q.add(testEvent(eventUpdated, "default"))
+	q.run(ctx, 2)
+
+	waitFor(t, func() bool { return len(sink.events()) == 2 })
+
+	events := sink.events()
+	if events[0].Type != eventAdded || events[1].Type != eventUpdated {
+		t.Errorf("Expected ADDED then UPDATED, got %s then %s", events[0].Type, events[1].Type)
+	}
+	if atomic.LoadInt64(&q.retries) != 2 {
+		t.Errorf("Expected 2 retries, got %d", atomic.LoadInt64(&q.retries))
+	}
+}
+
+func TestEventQueue_DropsAfterMaxRetries(t *testing.T) {
+	sink := &flakySink{failures: 100}
+	q := newTestQueue(2, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q.add(testEvent(eventAdded, "default"))
+	q.run(ctx, 1)
+
+	waitFor(t, func() bool { return atomic.LoadInt64(&q.dropped) == 1 })
+
+	q.mu.Lock()
+	pending := len(q.pending)
+	q.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("Expected dropped events to be removed, %d keys still pending", pending)
+	}
+	if retries := atomic.LoadInt64(&q.retries); retries != 2 {
+		t.Errorf("Expected 2 retries before dropping, got %d", retries)
+	}
+	if len(sink.events()) != 0 {
+		t.Errorf("Expected no delivered events, got %d", len(sink.events()))
+	}
+}
+
+func TestEventQueue_DeliversEventsBehindDroppedOne(t *testing.T) {
+	// The head event fails its attempt and both retries, the next one succeeds
+	sink := &flakySink{failures: 3}
+	q := newTestQueue(2, sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q.add(testEvent(eventAdded, "default"))
+	q.add(testEvent(eventUpdated, "default"))
+	q.run(ctx, 1)
+
+	waitFor(t, func() bool { return len(sink.events()) == 1 })
+	if got := sink.events()[0].Type; got != eventUpdated {
+		t.Errorf("Expected the UPDATED event behind the dropped one, got %s", got)
+	}
+	if dropped := atomic.LoadInt64(&q.dropped); dropped != 1 {
+		t.Errorf("Expected only the failed event to be dropped, got %d", dropped)
+	}
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Second)
+	defer drainCancel()
+	q.drain(drainCtx)
+	if drainCtx.Err() != nil {
+		t.Error("Expected drain to return once the queue is empty")
+	}
+}
+
+func TestEventQueue_DrainDeadline(t *testing.T) {
+	sink := &flakySink{failures: 100}
+	q := newEventQueue([]*filteredSink{{name: "flaky", eventSink: sink}}, 100,
+		workqueue.NewItemExponentialFailureRateLimiter(time.Hour, time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q.add(testEvent(eventAdded, "default"))
+	q.run(ctx, 1)
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer drainCancel()
+	done := make(chan struct{})
+	go func() {
+		q.drain(drainCtx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected drain to give up at its deadline")
+	}
+}
+
+func TestEventQueue_RetriesOnlyFailedSinks(t *testing.T) {
+	healthy := &flakySink{}
+	flaky := &flakySink{failures: 1}
+	q := newTestQueue(5, healthy, flaky)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q.add(testEvent(eventDeleted, "default"))
+	q.run(ctx, 1)
+
+	waitFor(t, func() bool { return len(flaky.events()) == 1 })
+
+	if got := len(healthy.events()); got != 1 {
+		t.Errorf("Expected the healthy sink to receive the event once, got %d", got)
+	}
+}
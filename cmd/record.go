@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// recordedEvent is one line of a recording: a watch event with the full object
+type recordedEvent struct {
+	Time   time.Time       `json:"time"`
+	Type   watch.EventType `json:"type"`
+	Kind   string          `json:"kind"`
+	Object json.RawMessage `json:"object"`
+}
+
+// decode returns the recorded object as its typed Kubernetes object
+func (e recordedEvent) decode() (runtime.Object, error) {
+	_, resource, ok := resourceForKind(e.Kind)
+	if !ok {
+		return nil, fmt.Errorf("unsupported kind %q", e.Kind)
+	}
+	obj := resource.newObject()
+	if err := json.Unmarshal(e.Object, obj); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", e.Kind, err)
+	}
+	return obj, nil
+}
+
+// eventRecorder appends watch events to a JSON lines file
+type eventRecorder struct {
+	mu  sync.Mutex
+	out io.WriteCloser
+	enc *json.Encoder
+}
+
+// newEventRecorder creates or truncates the recording file at path
+func newEventRecorder(path string) (*eventRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	return &eventRecorder{out: f, enc: json.NewEncoder(f)}, nil
+}
+
+// record writes one event; errors are logged so recording never blocks the informer
+func (r *eventRecorder) record(eventType watch.EventType, kind string, obj interface{}) {
+	inner, _ := unwrapTombstone(obj)
+	data, err := json.Marshal(inner)
+	if err != nil {
+		klog.Errorf("Failed to record %s %s: %v", kind, tombstoneKey(obj), err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(recordedEvent{Time: time.Now().UTC(), Type: eventType, Kind: kind, Object: data}); err != nil {
+		klog.Errorf("Failed to record %s %s: %v", kind, tombstoneKey(obj), err)
+	}
+}
+
+// Close closes the recording file
+func (r *eventRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.out.Close()
+}
+
+// recordingEventHandler records every add, update and delete of a resource
+// before any filtering, so a replay sees the same stream as the informer.
+// Resyncs are not watch events and are skipped.
+func recordingEventHandler(kind string, recorder *eventRecorder) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			recorder.record(watch.Added, kind, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, _, errOld := objectFromEvent(oldObj)
+			newMeta, _, errNew := objectFromEvent(newObj)
+			if errOld == nil && errNew == nil && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				return
+			}
+			recorder.record(watch.Modified, kind, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			recorder.record(watch.Deleted, kind, obj)
+		},
+	}
+}
+
+// readRecording loads all events of a recording file
+func readRecording(path string) ([]recordedEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []recordedEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event recordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		switch event.Type {
+		case watch.Added, watch.Modified, watch.Deleted:
+		default:
+			return nil, fmt.Errorf("%s:%d: unsupported event type %q", path, line, event.Type)
+		}
+		if _, _, ok := resourceForKind(event.Kind); !ok {
+			return nil, fmt.Errorf("%s:%d: unsupported kind %q", path, line, event.Kind)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return events, nil
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ownedReplicaSets returns the ReplicaSets controlled by the deployment, newest revision first
+func ownedReplicaSets(deployment *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet) []*appsv1.ReplicaSet {
+	var owned []*appsv1.ReplicaSet
+	for _, rs := range replicaSets {
+		if isControlledBy(rs.OwnerReferences, deployment.UID) {
+			owned = append(owned, rs)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return replicaSetRevision(owned[i]) > replicaSetRevision(owned[j])
+	})
+	return owned
+}
+
+// ownedPods returns the pods controlled by any of the given ReplicaSets, sorted by name
+func ownedPods(replicaSets []*appsv1.ReplicaSet, pods []*corev1.Pod) []*corev1.Pod {
+	owners := make(map[types.UID]bool, len(replicaSets))
+	for _, rs := range replicaSets {
+		owners[rs.UID] = true
+	}
+
+	var owned []*corev1.Pod
+	for _, pod := range pods {
+		if ref := metav1.GetControllerOf(pod); ref != nil && owners[ref.UID] {
+			owned = append(owned, pod)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].Name < owned[j].Name })
+	return owned
+}
+
+// eventsFor returns the events whose involved object is one of the given UIDs, most recent first
+func eventsFor(uids map[types.UID]bool, events []*corev1.Event) []*corev1.Event {
+	var related []*corev1.Event
+	for _, event := range events {
+		if uids[event.InvolvedObject.UID] {
+			related = append(related, event)
+		}
+	}
+	sort.Slice(related, func(i, j int) bool {
+		return eventTime(related[i]).After(eventTime(related[j]).Time)
+	})
+	return related
+}
+
+// eventTime returns the most specific timestamp recorded on an event
+func eventTime(event *corev1.Event) metav1.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp
+	}
+	if !event.EventTime.IsZero() {
+		return metav1.NewTime(event.EventTime.Time)
+	}
+	return event.CreationTimestamp
+}
+
+// isControlledBy reports whether the owner references contain a controller with the given UID
+func isControlledBy(refs []metav1.OwnerReference, uid types.UID) bool {
+	for _, ref := range refs {
+		if ref.UID == uid && ref.Controller != nil && *ref.Controller {
+			return true
+		}
+	}
+	return false
+}
+
+// podReadiness returns the number of ready containers, total containers and restarts of a pod
+func podReadiness(pod *corev1.Pod) (ready, total int, restarts int32) {
+	total = len(pod.Spec.Containers)
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Ready {
+			ready++
+		}
+		restarts += status.RestartCount
+	}
+	return ready, total, restarts
+}
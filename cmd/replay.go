@@ -0,0 +1,408 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var (
+	replaySpeed     float64
+	replayTarget    string
+	replaySinkSpecs []string
+)
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay <recording>",
+	Short: "Replay a recorded watch event stream",
+	Long: `Replay a recording made with "informer --record" through a fake watcher, so the
+same handlers run without a cluster. Useful for reproducing bugs and regression tests.
+
+Targets:
+  informer    run the informer event handlers, logs and sinks (default)
+  api         serve the deployments API from the replayed cache
+  controller  apply deployment events to a fake client and run the controller reconciler
+  manager     same as controller, with the manager reconciler
+
+Examples:
+  k8s-controller replay events.jsonl                 # Original timing
+  k8s-controller replay events.jsonl --speed=10      # Ten times faster
+  k8s-controller replay events.jsonl --speed=0       # As fast as possible
+  k8s-controller replay events.jsonl --target=api --port=8080`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runReplay(args[0]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().Float64Var(&replaySpeed, "speed", 1, "playback speed multiplier, 0 replays without delays")
+	replayCmd.Flags().StringVar(&replayTarget, "target", "informer", "what to drive: informer, api, controller or manager")
+	replayCmd.Flags().StringArrayVar(&replaySinkSpecs, "sink", nil, "event sink for the informer target, as for the informer command (repeatable)")
+	replayCmd.Flags().StringVar(&apiPort, "port", "8080", "port of the API server for the api target")
+}
+
+// runReplay replays a recording into the selected target
+func runReplay(path string) error {
+	if replaySpeed < 0 {
+		return fmt.Errorf("--speed must not be negative")
+	}
+	events, err := readRecording(path)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("recording %s has no events", path)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	klog.Infof("Replaying %d events from %s into the %s at %gx speed", len(events), path, replayTarget, replaySpeed)
+	switch replayTarget {
+	case "informer":
+		return replayToInformer(ctx, events)
+	case "api":
+		return replayToAPI(ctx, events)
+	case "controller", "manager":
+		ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+		return replayToReconciler(ctx, events, replaySpeed, func(c client.Client) reconcile.Reconciler {
+			if replayTarget == "manager" {
+				return &ManagerReconciler{Client: c}
+			}
+			return &DeploymentReconciler{Client: c}
+		})
+	default:
+		return fmt.Errorf("unknown --target %q, expected informer, api, controller or manager", replayTarget)
+	}
+}
+
+// replayToInformer feeds the recording through the informer handlers and event queue
+func replayToInformer(ctx context.Context, events []recordedEvent) error {
+	sinks, err := parseSinks(replaySinkSpecs)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, sink := range sinks {
+			sink.Close()
+		}
+	}()
+
+	// A single worker keeps the output in recording order
+	queue := newEventQueue(sinks, informerMaxRetries,
+		workqueue.NewItemExponentialFailureRateLimiter(500*time.Millisecond, time.Minute))
+	queue.run(ctx, 1)
+
+	cluster := newReplayCluster(recordedResources(events))
+	if err := cluster.start(ctx, func(resource watchedResource) cache.ResourceEventHandler {
+		return resourceEventHandler(resource, nil, queue.add)
+	}); err != nil {
+		return err
+	}
+	if err := cluster.replay(ctx, events, replaySpeed); err != nil {
+		return err
+	}
+
+	queue.drain(ctx)
+	klog.Infof("Replay finished: %d events", len(events))
+	return nil
+}
+
+// replayToAPI serves the deployments API from a cache filled by the recording
+func replayToAPI(ctx context.Context, events []recordedEvent) error {
+	cluster := newReplayCluster([]string{"deployments"})
+	informer = cluster.factory.Apps().V1().Deployments().Informer()
+	if err := addDeploymentIndexers(informer); err != nil {
+		return err
+	}
+	if err := addRelatedInformers(cluster.factory, ""); err != nil {
+		return err
+	}
+	apiWatch = newWatchBroadcaster(1000)
+	if _, err := informer.AddEventHandler(apiWatch.handler()); err != nil {
+		return err
+	}
+	if _, err := informer.AddEventHandler(apiActivity.handler()); err != nil {
+		return err
+	}
+	if err := cluster.start(ctx, nil); err != nil {
+		return err
+	}
+	apiActivity.synced()
+	apiClient = cluster.client
+	apiFactory = cluster.factory
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- serveAPI() }()
+
+	var deployments []recordedEvent
+	for _, event := range events {
+		if event.Kind == "Deployment" {
+			deployments = append(deployments, event)
+		}
+	}
+	if err := cluster.replay(ctx, deployments, replaySpeed); err != nil {
+		return err
+	}
+
+	klog.Info("Replay finished, API still serving. Press Ctrl+C to stop...")
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// replayToReconciler applies the recorded deployment events to a fake
+// controller-runtime client and reconciles each affected deployment
+func replayToReconciler(ctx context.Context, events []recordedEvent, speed float64, newReconciler func(client.Client) reconcile.Reconciler) error {
+	scheme := runtime.NewScheme()
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	c := fakeclient.NewClientBuilder().WithScheme(scheme).Build()
+	reconciler := newReconciler(c)
+
+	var deployments []recordedEvent
+	for _, event := range events {
+		if event.Kind == "Deployment" {
+			deployments = append(deployments, event)
+		}
+	}
+
+	err := replayEvents(ctx, deployments, speed, func(event recordedEvent) error {
+		obj, err := event.decode()
+		if err != nil {
+			return err
+		}
+		deployment := obj.(*appsv1.Deployment)
+		if err := applyRecordedDeployment(ctx, c, event.Type, deployment); err != nil {
+			return fmt.Errorf("failed to apply %s %s/%s: %w", event.Type, deployment.Namespace, deployment.Name, err)
+		}
+
+		request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}}
+		if _, err := reconciler.Reconcile(ctx, request); err != nil {
+			klog.Errorf("Reconcile of %s failed: %v", request, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("Replay finished: %d deployment events reconciled", len(deployments))
+	return nil
+}
+
+// applyRecordedDeployment makes the fake client's state match a recorded event
+func applyRecordedDeployment(ctx context.Context, c client.Client, eventType watch.EventType, deployment *appsv1.Deployment) error {
+	deployment.ResourceVersion = ""
+	if eventType == watch.Deleted {
+		return client.IgnoreNotFound(c.Delete(ctx, deployment))
+	}
+
+	var existing appsv1.Deployment
+	err := c.Get(ctx, client.ObjectKeyFromObject(deployment), &existing)
+	if apierrors.IsNotFound(err) {
+		return c.Create(ctx, deployment)
+	}
+	if err != nil {
+		return err
+	}
+	deployment.ResourceVersion = existing.ResourceVersion
+	return c.Update(ctx, deployment)
+}
+
+// replayEvents calls send for every event, waiting between events for the
+// recorded gap divided by speed. A speed of 0 sends without delays.
+func replayEvents(ctx context.Context, events []recordedEvent, speed float64, send func(recordedEvent) error) error {
+	for i, event := range events {
+		if i > 0 && speed > 0 {
+			if delay := time.Duration(float64(event.Time.Sub(events[i-1].Time)) / speed); delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		if err := send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordedResources returns the sorted plural names of the kinds in a recording
+func recordedResources(events []recordedEvent) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, event := range events {
+		name, _, ok := resourceForKind(event.Kind)
+		if ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// replayCluster is a fake clientset whose watches are fed from a recording.
+// It starts empty, so every object enters the informers through the recorded
+// ADDED events, exactly as when the recording was made.
+type replayCluster struct {
+	client    *fake.Clientset
+	factory   informers.SharedInformerFactory
+	resources []string
+
+	mu       sync.Mutex
+	watchers map[string]*watch.FakeWatcher
+	watching map[string]chan struct{}
+
+	// handled counts watch events the informers have passed to handlers
+	handled int64
+}
+
+// newReplayCluster creates a fake cluster serving watches for the given resources
+func newReplayCluster(resources []string) *replayCluster {
+	c := &replayCluster{
+		client:    fake.NewSimpleClientset(),
+		resources: resources,
+		watchers:  make(map[string]*watch.FakeWatcher),
+		watching:  make(map[string]chan struct{}),
+	}
+	c.factory = informers.NewSharedInformerFactory(c.client, 0)
+
+	for _, name := range resources {
+		name := name
+		watching := make(chan struct{})
+		c.watching[name] = watching
+		c.client.PrependWatchReactor(name, func(action k8stesting.Action) (bool, watch.Interface, error) {
+			w := watch.NewFake()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.watchers[name] == nil {
+				close(watching)
+			}
+			c.watchers[name] = w
+			return true, w, nil
+		})
+	}
+	return c
+}
+
+// start registers handlers built by newHandler (if any), starts the informers
+// and waits until every resource is being watched
+func (c *replayCluster) start(ctx context.Context, newHandler func(watchedResource) cache.ResourceEventHandler) error {
+	for _, name := range c.resources {
+		resource := watchableResources[name]
+		var handler cache.ResourceEventHandler = cache.ResourceEventHandlerFuncs{}
+		if newHandler != nil {
+			handler = newHandler(resource)
+		}
+		if _, err := resource.informer(c.factory).AddEventHandler(c.countingHandler(handler)); err != nil {
+			return fmt.Errorf("failed to add event handler: %w", err)
+		}
+	}
+
+	c.factory.Start(ctx.Done())
+	for informerType, synced := range c.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync cache for %v", informerType)
+		}
+	}
+	for name, watching := range c.watching {
+		select {
+		case <-watching:
+		case <-ctx.Done():
+			return fmt.Errorf("informer for %s never started watching: %w", name, ctx.Err())
+		}
+	}
+	return nil
+}
+
+// countingHandler wraps handler to count the events it has processed
+func (c *replayCluster) countingHandler(handler cache.ResourceEventHandler) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			handler.OnAdd(obj, false)
+			atomic.AddInt64(&c.handled, 1)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			handler.OnUpdate(oldObj, newObj)
+			atomic.AddInt64(&c.handled, 1)
+		},
+		DeleteFunc: func(obj interface{}) {
+			handler.OnDelete(obj)
+			atomic.AddInt64(&c.handled, 1)
+		},
+	}
+}
+
+// send delivers one recorded event to the watcher of its resource
+func (c *replayCluster) send(event recordedEvent) error {
+	obj, err := event.decode()
+	if err != nil {
+		return err
+	}
+	name, _, _ := resourceForKind(event.Kind)
+
+	c.mu.Lock()
+	w := c.watchers[name]
+	c.mu.Unlock()
+	if w == nil {
+		return fmt.Errorf("no watch for %s", name)
+	}
+	w.Action(event.Type, obj)
+	return nil
+}
+
+// replay sends the events with their recorded timing and waits until the
+// informers have handled all of them
+func (c *replayCluster) replay(ctx context.Context, events []recordedEvent, speed float64) error {
+	start := atomic.LoadInt64(&c.handled)
+	if err := replayEvents(ctx, events, speed, c.send); err != nil {
+		return err
+	}
+
+	for atomic.LoadInt64(&c.handled)-start < int64(len(events)) {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newRecordedEvent(t *testing.T, eventType watch.EventType, kind string, obj interface{}, at time.Time) recordedEvent {
+	t.Helper()
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatalf("Failed to encode %s: %v", kind, err)
+	}
+	return recordedEvent{Time: at, Type: eventType, Kind: kind, Object: data}
+}
+
+// deploymentLifecycle returns a recording of a deployment being created, scaled and deleted
+func deploymentLifecycle(t *testing.T) []recordedEvent {
+	created := newTestDeployment("web", 3, 3)
+	created.ResourceVersion = "1"
+	scaled := created.DeepCopy()
+	scaled.ResourceVersion = "2"
+	replicas := int32(5)
+	scaled.Spec.Replicas = &replicas
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	return []recordedEvent{
+		newRecordedEvent(t, watch.Added, "Deployment", created, start),
+		newRecordedEvent(t, watch.Modified, "Deployment", scaled, start.Add(time.Second)),
+		newRecordedEvent(t, watch.Deleted, "Deployment", scaled, start.Add(2*time.Second)),
+	}
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	recorder, err := newEventRecorder(path)
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+
+	handler := recordingEventHandler("Deployment", recorder)
+	deployment := newTestDeployment("web", 3, 3)
+	deployment.ResourceVersion = "1"
+	handler.OnAdd(deployment, true)
+
+	// Resyncs are not watch events
+	handler.OnUpdate(deployment, deployment.DeepCopy())
+
+	scaled := deployment.DeepCopy()
+	scaled.ResourceVersion = "2"
+	replicas := int32(5)
+	scaled.Spec.Replicas = &replicas
+	handler.OnUpdate(deployment, scaled)
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "default/web", Obj: scaled})
+	recorder.Close()
+
+	events, err := readRecording(path)
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	var types []string
+	for _, event := range events {
+		types = append(types, string(event.Type))
+	}
+	if strings.Join(types, ",") != "ADDED,MODIFIED,DELETED" {
+		t.Fatalf("Expected ADDED,MODIFIED,DELETED, got %v", types)
+	}
+
+	obj, err := events[2].decode()
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	decoded, ok := obj.(*appsv1.Deployment)
+	if !ok || decoded.Name != "web" || *decoded.Spec.Replicas != 5 {
+		t.Errorf("Expected the tombstone's deployment with 5 replicas, got %#v", obj)
+	}
+}
+
+func TestReadRecording_Errors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	os.WriteFile(path, []byte(`{"type":"ADDED","kind":"Secret","object":{}}`+"\n"), 0o644)
+	if _, err := readRecording(path); err == nil || !strings.Contains(err.Error(), ":1: unsupported kind") {
+		t.Errorf("Expected an unsupported kind error with a line number, got %v", err)
+	}
+
+	os.WriteFile(path, []byte(`{"type":"BOOKMARK","kind":"Deployment","object":{}}`+"\n"), 0o644)
+	if _, err := readRecording(path); err == nil || !strings.Contains(err.Error(), "unsupported event type") {
+		t.Errorf("Expected an unsupported event type error, got %v", err)
+	}
+}
+
+func TestReplayCluster_DrivesInformerHandlers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var lines []string
+	cluster := newReplayCluster([]string{"deployments"})
+	err := cluster.start(ctx, func(resource watchedResource) cache.ResourceEventHandler {
+		return resourceEventHandler(resource, nil, func(e informerEvent) {
+			mu.Lock()
+			lines = append(lines, e.String())
+			mu.Unlock()
+		})
+	})
+	if err != nil {
+		t.Fatalf("Failed to start replay cluster: %v", err)
+	}
+	if err := cluster.replay(ctx, deploymentLifecycle(t), 0); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{
+		"Deployment ADDED: default/web replicas=3 ready=3 image=nginx:1.25",
+		"Deployment UPDATED: default/web replicas: 3→5",
+		"Deployment DELETED: default/web replicas=5 ready=3 image=nginx:1.25",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected replayed events:\n%s", strings.Join(lines, "\n"))
+	}
+	if got := len(cluster.factory.Apps().V1().Deployments().Informer().GetStore().List()); got != 0 {
+		t.Errorf("Expected an empty cache after the delete, got %d objects", got)
+	}
+}
+
+// recordingReconciler records reconcile requests and whether the deployment existed
+type recordingReconciler struct {
+	client.Client
+	requests []string
+}
+
+func (r *recordingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var deployment appsv1.Deployment
+	if err := r.Get(ctx, req.NamespacedName, &deployment); err != nil {
+		r.requests = append(r.requests, req.String()+" deleted")
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	r.requests = append(r.requests, req.String()+" replicas="+replicasString(deployment.Spec.Replicas))
+	return ctrl.Result{}, nil
+}
+
+func TestReplayToReconciler(t *testing.T) {
+	var reconciler *recordingReconciler
+	err := replayToReconciler(context.Background(), deploymentLifecycle(t), 0, func(c client.Client) reconcile.Reconciler {
+		reconciler = &recordingReconciler{Client: c}
+		return reconciler
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	expected := "default/web replicas=3,default/web replicas=5,default/web deleted"
+	if got := strings.Join(reconciler.requests, ","); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestReplayEvents_Speed(t *testing.T) {
+	events := deploymentLifecycle(t)
+
+	start := time.Now()
+	sent := 0
+	err := replayEvents(context.Background(), events, 40, func(recordedEvent) error {
+		sent++
+		return nil
+	})
+	elapsed := time.Since(start)
+
+	if err != nil || sent != 3 {
+		t.Fatalf("Expected 3 events sent without error, got %d, %v", sent, err)
+	}
+	// Two seconds of recording at 40x take 50ms
+	if elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected about 50ms of replay, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := replayEvents(ctx, events, 1, func(recordedEvent) error { return nil }); err != context.Canceled {
+		t.Errorf("Expected a cancelled replay to stop, got %v", err)
+	}
+}
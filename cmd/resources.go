@@ -0,0 +1,374 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchedResource describes how the informer watches and reports one resource kind
+type watchedResource struct {
+	kind      string
+	gvr       schema.GroupVersionResource
+	newObject func() runtime.Object
+	informer  func(informers.SharedInformerFactory) cache.SharedIndexInformer
+	describe  func(obj interface{}) string
+	diff      func(oldObj, newObj interface{}) []fieldChange
+}
+
+// watchableResources lists the resources accepted by --resources, keyed by plural name
+var watchableResources = map[string]watchedResource{
+	"deployments": {
+		kind:      "Deployment",
+		gvr:       appsv1.SchemeGroupVersion.WithResource("deployments"),
+		newObject: func() runtime.Object { return &appsv1.Deployment{} },
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Apps().V1().Deployments().Informer()
+		},
+		describe: describeAs(func(d *appsv1.Deployment) string {
+			return fmt.Sprintf("replicas=%s ready=%d image=%s", replicasString(d.Spec.Replicas), d.Status.ReadyReplicas, getImage(d))
+		}),
+		diff: diffAs(diffDeployments),
+	},
+	"replicasets": {
+		kind:      "ReplicaSet",
+		gvr:       appsv1.SchemeGroupVersion.WithResource("replicasets"),
+		newObject: func() runtime.Object { return &appsv1.ReplicaSet{} },
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Apps().V1().ReplicaSets().Informer()
+		},
+		describe: describeAs(func(rs *appsv1.ReplicaSet) string {
+			return fmt.Sprintf("replicas=%s ready=%d owner=%s", replicasString(rs.Spec.Replicas), rs.Status.ReadyReplicas, controllerName(rs))
+		}),
+		diff: diffAs(func(oldRS, newRS *appsv1.ReplicaSet) []fieldChange {
+			changes := diffReplicas(oldRS.Spec.Replicas, newRS.Spec.Replicas)
+			changes = append(changes, diffContainers(oldRS.Spec.Template.Spec.Containers, newRS.Spec.Template.Spec.Containers)...)
+			changes = append(changes, diffMaps("label", oldRS.Labels, newRS.Labels)...)
+			changes = appendCounter(changes, "status.replicas", oldRS.Status.Replicas, newRS.Status.Replicas)
+			changes = appendCounter(changes, "status.ready", oldRS.Status.ReadyReplicas, newRS.Status.ReadyReplicas)
+			return appendCounter(changes, "status.available", oldRS.Status.AvailableReplicas, newRS.Status.AvailableReplicas)
+		}),
+	},
+	"pods": {
+		kind:      "Pod",
+		gvr:       corev1.SchemeGroupVersion.WithResource("pods"),
+		newObject: func() runtime.Object { return &corev1.Pod{} },
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Core().V1().Pods().Informer()
+		},
+		describe: describeAs(func(pod *corev1.Pod) string {
+			ready, total, restarts := podReadiness(pod)
+			return fmt.Sprintf("phase=%s ready=%d/%d restarts=%d node=%s", pod.Status.Phase, ready, total, restarts, pod.Spec.NodeName)
+		}),
+		diff: diffAs(func(oldPod, newPod *corev1.Pod) []fieldChange {
+			var changes []fieldChange
+			if oldPod.Spec.NodeName != newPod.Spec.NodeName {
+				changes = append(changes, fieldChange{Field: "node", Old: oldPod.Spec.NodeName, New: newPod.Spec.NodeName})
+			}
+			changes = append(changes, diffContainers(oldPod.Spec.Containers, newPod.Spec.Containers)...)
+			changes = append(changes, diffMaps("label", oldPod.Labels, newPod.Labels)...)
+			if oldPod.Status.Phase != newPod.Status.Phase {
+				changes = append(changes, fieldChange{Field: "status.phase", Old: string(oldPod.Status.Phase), New: string(newPod.Status.Phase), Status: true})
+			}
+			oldReady, _, oldRestarts := podReadiness(oldPod)
+			newReady, _, newRestarts := podReadiness(newPod)
+			changes = appendCounter(changes, "status.ready", int32(oldReady), int32(newReady))
+			return appendCounter(changes, "status.restarts", oldRestarts, newRestarts)
+		}),
+	},
+	"services": {
+		kind:      "Service",
+		gvr:       corev1.SchemeGroupVersion.WithResource("services"),
+		newObject: func() runtime.Object { return &corev1.Service{} },
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Core().V1().Services().Informer()
+		},
+		describe: describeAs(func(svc *corev1.Service) string {
+			return fmt.Sprintf("type=%s clusterIP=%s ports=%s", svc.Spec.Type, svc.Spec.ClusterIP, servicePorts(svc))
+		}),
+		diff: diffAs(func(oldSvc, newSvc *corev1.Service) []fieldChange {
+			var changes []fieldChange
+			if oldSvc.Spec.Type != newSvc.Spec.Type {
+				changes = append(changes, fieldChange{Field: "type", Old: string(oldSvc.Spec.Type), New: string(newSvc.Spec.Type)})
+			}
+			if oldPorts, newPorts := servicePorts(oldSvc), servicePorts(newSvc); oldPorts != newPorts {
+				changes = append(changes, fieldChange{Field: "ports", Old: oldPorts, New: newPorts})
+			}
+			changes = append(changes, diffMaps("selector", oldSvc.Spec.Selector, newSvc.Spec.Selector)...)
+			return append(changes, diffMaps("label", oldSvc.Labels, newSvc.Labels)...)
+		}),
+	},
+	"configmaps": {
+		kind:      "ConfigMap",
+		gvr:       corev1.SchemeGroupVersion.WithResource("configmaps"),
+		newObject: func() runtime.Object { return &corev1.ConfigMap{} },
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Core().V1().ConfigMaps().Informer()
+		},
+		describe: describeAs(func(cm *corev1.ConfigMap) string {
+			return fmt.Sprintf("keys=%d", len(cm.Data)+len(cm.BinaryData))
+		}),
+		diff: diffAs(func(oldCM, newCM *corev1.ConfigMap) []fieldChange {
+			// Values may be large or sensitive, so only content hashes are reported
+			changes := diffMaps("data", hashValues(oldCM.Data), hashValues(newCM.Data))
+			return append(changes, diffMaps("label", oldCM.Labels, newCM.Labels)...)
+		}),
+	},
+	"statefulsets": {
+		kind:      "StatefulSet",
+		gvr:       appsv1.SchemeGroupVersion.WithResource("statefulsets"),
+		newObject: func() runtime.Object { return &appsv1.StatefulSet{} },
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Apps().V1().StatefulSets().Informer()
+		},
+		describe: describeAs(func(sts *appsv1.StatefulSet) string {
+			return fmt.Sprintf("replicas=%s ready=%d image=%s", replicasString(sts.Spec.Replicas), sts.Status.ReadyReplicas, firstImage(sts.Spec.Template.Spec.Containers))
+		}),
+		diff: diffAs(func(oldSts, newSts *appsv1.StatefulSet) []fieldChange {
+			changes := diffReplicas(oldSts.Spec.Replicas, newSts.Spec.Replicas)
+			changes = append(changes, diffContainers(oldSts.Spec.Template.Spec.Containers, newSts.Spec.Template.Spec.Containers)...)
+			changes = append(changes, diffMaps("label", oldSts.Labels, newSts.Labels)...)
+			changes = appendCounter(changes, "status.ready", oldSts.Status.ReadyReplicas, newSts.Status.ReadyReplicas)
+			return appendCounter(changes, "status.updated", oldSts.Status.UpdatedReplicas, newSts.Status.UpdatedReplicas)
+		}),
+	},
+	"daemonsets": {
+		kind:      "DaemonSet",
+		gvr:       appsv1.SchemeGroupVersion.WithResource("daemonsets"),
+		newObject: func() runtime.Object { return &appsv1.DaemonSet{} },
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Apps().V1().DaemonSets().Informer()
+		},
+		describe: describeAs(func(ds *appsv1.DaemonSet) string {
+			return fmt.Sprintf("desired=%d ready=%d image=%s", ds.Status.DesiredNumberScheduled, ds.Status.NumberReady, firstImage(ds.Spec.Template.Spec.Containers))
+		}),
+		diff: diffAs(func(oldDS, newDS *appsv1.DaemonSet) []fieldChange {
+			changes := diffContainers(oldDS.Spec.Template.Spec.Containers, newDS.Spec.Template.Spec.Containers)
+			changes = append(changes, diffMaps("label", oldDS.Labels, newDS.Labels)...)
+			changes = appendCounter(changes, "status.desired", oldDS.Status.DesiredNumberScheduled, newDS.Status.DesiredNumberScheduled)
+			changes = appendCounter(changes, "status.ready", oldDS.Status.NumberReady, newDS.Status.NumberReady)
+			return appendCounter(changes, "status.updated", oldDS.Status.UpdatedNumberScheduled, newDS.Status.UpdatedNumberScheduled)
+		}),
+	},
+	"jobs": {
+		kind:      "Job",
+		gvr:       batchv1.SchemeGroupVersion.WithResource("jobs"),
+		newObject: func() runtime.Object { return &batchv1.Job{} },
+		informer: func(f informers.SharedInformerFactory) cache.SharedIndexInformer {
+			return f.Batch().V1().Jobs().Informer()
+		},
+		describe: describeAs(func(job *batchv1.Job) string {
+			return fmt.Sprintf("active=%d succeeded=%d failed=%d", job.Status.Active, job.Status.Succeeded, job.Status.Failed)
+		}),
+		diff: diffAs(func(oldJob, newJob *batchv1.Job) []fieldChange {
+			changes := diffMaps("label", oldJob.Labels, newJob.Labels)
+			changes = appendCounter(changes, "status.active", oldJob.Status.Active, newJob.Status.Active)
+			changes = appendCounter(changes, "status.succeeded", oldJob.Status.Succeeded, newJob.Status.Succeeded)
+			return appendCounter(changes, "status.failed", oldJob.Status.Failed, newJob.Status.Failed)
+		}),
+	},
+}
+
+// parseResources resolves --resources names into watched resources, in the given order
+func parseResources(names []string) ([]watchedResource, error) {
+	var resources []watchedResource
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		resource, ok := watchableResources[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported resource %q, expected one of: %s", name, strings.Join(watchableResourceNames(), ", "))
+		}
+		seen[name] = true
+		resources = append(resources, resource)
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("at least one resource is required")
+	}
+	return resources, nil
+}
+
+// watchableResourceNames returns the sorted names accepted by --resources
+func watchableResourceNames() []string {
+	names := make([]string, 0, len(watchableResources))
+	for name := range watchableResources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// metadataOnly returns the resource as watched by a metadata informer, which
+// caches PartialObjectMetadata, so events summarize and diff labels and
+// annotations only
+func (r watchedResource) metadataOnly() watchedResource {
+	r.describe = describeAs(func(m *metav1.PartialObjectMetadata) string {
+		if len(m.Labels) == 0 {
+			return "labels=<none>"
+		}
+		return "labels=" + labels.Set(m.Labels).String()
+	})
+	r.diff = diffAs(func(oldMeta, newMeta *metav1.PartialObjectMetadata) []fieldChange {
+		changes := diffMaps("label", oldMeta.Labels, newMeta.Labels)
+		return append(changes, diffMaps("annotation", oldMeta.Annotations, newMeta.Annotations)...)
+	})
+	return r
+}
+
+// resourceForKind returns the plural name and definition of a watchable kind
+func resourceForKind(kind string) (string, watchedResource, bool) {
+	for name, resource := range watchableResources {
+		if resource.kind == kind {
+			return name, resource, true
+		}
+	}
+	return "", watchedResource{}, false
+}
+
+// describeAs adapts a typed summary function to informer objects
+func describeAs[T any](describe func(T) string) func(interface{}) string {
+	return func(obj interface{}) string {
+		typed, ok := obj.(T)
+		if !ok {
+			return ""
+		}
+		return describe(typed)
+	}
+}
+
+// diffAs adapts a typed diff function to informer objects
+func diffAs[T any](diff func(T, T) []fieldChange) func(interface{}, interface{}) []fieldChange {
+	return func(oldObj, newObj interface{}) []fieldChange {
+		oldTyped, okOld := oldObj.(T)
+		newTyped, okNew := newObj.(T)
+		if !okOld || !okNew {
+			return nil
+		}
+		return diff(oldTyped, newTyped)
+	}
+}
+
+// ownerFilter limits pods and ReplicaSets to those controlled by a deployment in the cache
+type ownerFilter struct {
+	deployments cache.Store
+	replicaSets cache.Store
+}
+
+// newOwnerFilter looks up deployments in the selected source and ReplicaSets
+// in the owned source, which watches the same namespace without the selectors
+func newOwnerFilter(selected, owned informerSource) (*ownerFilter, error) {
+	deployments, err := selected.informerFor(watchableResources["deployments"])
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := owned.informerFor(watchableResources["replicasets"])
+	if err != nil {
+		return nil, err
+	}
+	return &ownerFilter{deployments: deployments.GetStore(), replicaSets: replicaSets.GetStore()}, nil
+}
+
+// includeFor returns the filter to apply to a resource kind, or nil when it is not filtered
+func (f *ownerFilter) includeFor(kind string) func(metav1.Object) bool {
+	if f == nil || (kind != "Pod" && kind != "ReplicaSet") {
+		return nil
+	}
+	return f.ownedByWatchedDeployment
+}
+
+// ownedByWatchedDeployment follows controller references up to a cached deployment
+func (f *ownerFilter) ownedByWatchedDeployment(object metav1.Object) bool {
+	ref := metav1.GetControllerOfNoCopy(object)
+	if ref == nil {
+		return false
+	}
+
+	var store cache.Store
+	switch ref.Kind {
+	case "Deployment":
+		store = f.deployments
+	case "ReplicaSet":
+		store = f.replicaSets
+	default:
+		return false
+	}
+
+	obj, exists, err := store.GetByKey(object.GetNamespace() + "/" + ref.Name)
+	if err != nil || !exists {
+		return false
+	}
+	owner, ok := obj.(metav1.Object)
+	if !ok || owner.GetUID() != ref.UID {
+		return false
+	}
+	if ref.Kind == "ReplicaSet" {
+		return f.ownedByWatchedDeployment(owner)
+	}
+	return true
+}
+
+// diffReplicas reports a change of an optional replica count
+func diffReplicas(oldReplicas, newReplicas *int32) []fieldChange {
+	if o, n := replicasString(oldReplicas), replicasString(newReplicas); o != n {
+		return []fieldChange{{Field: "replicas", Old: o, New: n}}
+	}
+	return nil
+}
+
+// appendCounter appends a status counter change if the value differs
+func appendCounter(changes []fieldChange, field string, oldValue, newValue int32) []fieldChange {
+	if oldValue == newValue {
+		return changes
+	}
+	return append(changes, fieldChange{Field: field, Old: fmt.Sprint(oldValue), New: fmt.Sprint(newValue), Status: true})
+}
+
+// controllerName renders the controller reference of an object as Kind/name
+func controllerName(object metav1.Object) string {
+	if ref := metav1.GetControllerOfNoCopy(object); ref != nil {
+		return ref.Kind + "/" + ref.Name
+	}
+	return "<none>"
+}
+
+// firstImage returns the image of the first container, or "unknown"
+func firstImage(containers []corev1.Container) string {
+	if len(containers) > 0 {
+		return containers[0].Image
+	}
+	return "unknown"
+}
+
+// servicePorts renders service ports as a comma-separated port/protocol list
+func servicePorts(svc *corev1.Service) string {
+	ports := make([]string, len(svc.Spec.Ports))
+	for i, port := range svc.Spec.Ports {
+		ports[i] = fmt.Sprintf("%d/%s", port.Port, port.Protocol)
+	}
+	return strings.Join(ports, ",")
+}
+
+// hashValues replaces each value with a short content hash
+func hashValues(data map[string]string) map[string]string {
+	hashed := make(map[string]string, len(data))
+	for k, v := range data {
+		sum := sha256.Sum256([]byte(v))
+		hashed[k] = hex.EncodeToString(sum[:4])
+	}
+	return hashed
+}
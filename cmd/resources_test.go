@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestPod(name, owner string, ownerUID types.UID, phase corev1.PodPhase) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			UID:             types.UID(name),
+			ResourceVersion: "1",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: owner, UID: ownerUID, Controller: &controller},
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   "node-1",
+			Containers: []corev1.Container{{Name: "web", Image: "nginx:1.25"}},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestParseResources(t *testing.T) {
+	resources, err := parseResources([]string{"deployments", "Pods", "deployments", " jobs "})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var kinds []string
+	for _, r := range resources {
+		kinds = append(kinds, r.kind)
+	}
+	if strings.Join(kinds, ",") != "Deployment,Pod,Job" {
+		t.Errorf("Expected Deployment,Pod,Job, got %v", kinds)
+	}
+
+	if _, err := parseResources([]string{"secrets"}); err == nil || !strings.Contains(err.Error(), "configmaps") {
+		t.Errorf("Expected an error listing supported resources, got %v", err)
+	}
+	if _, err := parseResources(nil); err == nil {
+		t.Error("Expected an error for an empty resource list")
+	}
+}
+
+func TestOwnerFilter(t *testing.T) {
+	deployment := newTestDeployment("web", 1, 1)
+	controller := true
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:      "web-1",
+		Namespace: "default",
+		UID:       "web-1-uid",
+		OwnerReferences: []metav1.OwnerReference{
+			{Kind: "Deployment", Name: "web", UID: deployment.UID, Controller: &controller},
+		},
+	}}
+
+	filter := &ownerFilter{
+		deployments: cache.NewStore(cache.MetaNamespaceKeyFunc),
+		replicaSets: cache.NewStore(cache.MetaNamespaceKeyFunc),
+	}
+	filter.deployments.Add(deployment)
+	filter.replicaSets.Add(replicaSet)
+
+	include := filter.includeFor("Pod")
+	cases := []struct {
+		name     string
+		pod      *corev1.Pod
+		expected bool
+	}{
+		{"owned by watched deployment", newTestPod("web-1-a", "web-1", "web-1-uid", corev1.PodRunning), true},
+		{"unknown ReplicaSet", newTestPod("other-a", "other-1", "other-1-uid", corev1.PodRunning), false},
+		{"stale owner UID", newTestPod("web-1-b", "web-1", "old-uid", corev1.PodRunning), false},
+		{"no controller", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "default"}}, false},
+	}
+	for _, c := range cases {
+		if got := include(c.pod); got != c.expected {
+			t.Errorf("%s: expected %t, got %t", c.name, c.expected, got)
+		}
+	}
+
+	if !filter.includeFor("ReplicaSet")(replicaSet) {
+		t.Error("Expected ReplicaSet owned by a watched deployment to be included")
+	}
+	if filter.includeFor("Service") != nil {
+		t.Error("Expected services not to be filtered by owner")
+	}
+	var noFilter *ownerFilter
+	if noFilter.includeFor("Pod") != nil {
+		t.Error("Expected a nil filter to include everything")
+	}
+}
+
+func TestOwnerFilter_WithSelector(t *testing.T) {
+	// Only the deployment carries the selected label, its ReplicaSet and pod do not
+	deployment := newTestDeployment("web", 1, 1)
+	deployment.Labels = map[string]string{"team": "payments"}
+	controller := true
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:      "web-1",
+		Namespace: "default",
+		UID:       "web-1-uid",
+		OwnerReferences: []metav1.OwnerReference{
+			{Kind: "Deployment", Name: "web", UID: deployment.UID, Controller: &controller},
+		},
+	}}
+	pod := newTestPod("web-1-a", "web-1", "web-1-uid", corev1.PodRunning)
+	client := fake.NewSimpleClientset(deployment, replicaSet, pod)
+
+	opts := informerOptions{namespaces: []string{"default"}, labelSelector: "team=payments"}
+	unfiltered := opts.withoutSelectors()
+	selected := typedInformerSource{opts.newFactory(client, "default")}
+	owned := typedInformerSource{unfiltered.newFactory(client, "default")}
+	filter, err := newOwnerFilter(selected, owned)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pods, _ := owned.informerFor(watchableResources["pods"])
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	selected.Start(stopCh)
+	owned.Start(stopCh)
+	if err := selected.waitForCacheSync(stopCh); err != nil {
+		t.Fatal(err)
+	}
+	if err := owned.waitForCacheSync(stopCh); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exists, _ := pods.GetStore().Get(pod); !exists {
+		t.Fatal("Expected the owned pod informer to ignore the label selector")
+	}
+	if !filter.includeFor("ReplicaSet")(replicaSet) {
+		t.Error("Expected the ReplicaSet of a selected deployment to be included")
+	}
+	if !filter.includeFor("Pod")(pod) {
+		t.Error("Expected the pod of a selected deployment to be included")
+	}
+}
+
+func TestResourceEventHandler_Pods(t *testing.T) {
+	var events []informerEvent
+	handler := resourceEventHandler(watchableResources["pods"], nil, func(e informerEvent) {
+		events = append(events, e)
+	})
+
+	pod := newTestPod("web-1-a", "web-1", "web-1-uid", corev1.PodPending)
+	handler.OnAdd(pod, true)
+
+	// A resync with the same resourceVersion is not reported
+	handler.OnUpdate(pod, pod.DeepCopy())
+
+	running := pod.DeepCopy()
+	running.ResourceVersion = "2"
+	running.Status.Phase = corev1.PodRunning
+	handler.OnUpdate(pod, running)
+
+	handler.OnDelete(cache.DeletedFinalStateUnknown{Key: "default/web-1-a", Obj: running})
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d: %v", len(events), events)
+	}
+	if got := events[0].String(); got != "Pod ADDED: default/web-1-a phase=Pending ready=0/1 restarts=0 node=node-1" {
+		t.Errorf("Unexpected ADDED line %q", got)
+	}
+	if got := events[1].String(); got != "Pod UPDATED: default/web-1-a status.phase: Pending→Running" {
+		t.Errorf("Unexpected UPDATED line %q", got)
+	}
+	if events[2].Type != eventDeleted || !events[2].FinalStateUnknown || events[2].Kind != "Pod" {
+		t.Errorf("Expected tombstone DELETED Pod event, got %+v", events[2])
+	}
+}
+
+func TestResourceEventHandler_IncludeFilter(t *testing.T) {
+	var events []informerEvent
+	handler := resourceEventHandler(watchableResources["pods"], func(metav1.Object) bool { return false }, func(e informerEvent) {
+		events = append(events, e)
+	})
+
+	handler.OnAdd(newTestPod("web-1-a", "web-1", "web-1-uid", corev1.PodRunning), true)
+	if len(events) != 0 {
+		t.Errorf("Expected filtered pod not to be reported, got %v", events)
+	}
+}
@@ -0,0 +1,462 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// eventSink delivers informer events to an external consumer
+type eventSink interface {
+	Send(ctx context.Context, event informerEvent) error
+	Close() error
+}
+
+// filteredSink wraps a sink with its own event type and namespace filters
+type filteredSink struct {
+	eventSink
+	name       string
+	types      map[string]bool
+	namespaces map[string]bool
+}
+
+// matches reports whether the event passes the sink's filters
+func (f *filteredSink) matches(event informerEvent) bool {
+	if len(f.types) > 0 && !f.types[event.Type] {
+		return false
+	}
+	if len(f.namespaces) > 0 && !f.namespaces[event.Namespace] {
+		return false
+	}
+	return true
+}
+
+// dispatchEvent sends the event to every matching sink, logging failures.
+// It returns the sinks that failed and the last error so delivery can be retried.
+func dispatchEvent(ctx context.Context, sinks []*filteredSink, event informerEvent) ([]*filteredSink, error) {
+	var failed []*filteredSink
+	var lastErr error
+	for _, sink := range sinks {
+		if !sink.matches(event) {
+			continue
+		}
+		if err := sink.Send(ctx, event); err != nil {
+			klog.Errorf("Sink %s failed for %s/%s: %v", sink.name, event.Namespace, event.Name, err)
+			failed = append(failed, sink)
+			lastErr = fmt.Errorf("sink %s: %w", sink.name, err)
+		}
+	}
+	return failed, lastErr
+}
+
+// parseSinks builds sinks from --sink flag values
+func parseSinks(specs []string) ([]*filteredSink, error) {
+	var sinks []*filteredSink
+	for _, spec := range specs {
+		sink, err := parseSink(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --sink %q: %w", spec, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// sinkKindOptions are the options of each sink kind besides types and namespaces
+var sinkKindOptions = map[string][]string{
+	"stdout":  nil,
+	"file":    {"max-size-mb", "max-files"},
+	"webhook": {"secret-file", "secret-env", "retries", "timeout"},
+	"exec":    {"timeout"},
+}
+
+// sinkOptions are the option names of all sink kinds
+var sinkOptions = func() map[string]bool {
+	options := map[string]bool{"types": true, "namespaces": true}
+	for _, names := range sinkKindOptions {
+		for _, name := range names {
+			options[name] = true
+		}
+	}
+	return options
+}()
+
+// parseSink parses a sink spec of the form kind[:target][;option=value...]
+func parseSink(spec string) (*filteredSink, error) {
+	head, options := splitSinkOptions(spec)
+	kind, target, _ := strings.Cut(head, ":")
+	if _, option, ok := strings.Cut(kind, ";"); ok {
+		return nil, fmt.Errorf("unknown sink option %q", option)
+	}
+
+	filtered := &filteredSink{
+		name:       kind,
+		types:      parseSet(options["types"], strings.ToUpper),
+		namespaces: parseSet(options["namespaces"], nil),
+	}
+	delete(options, "types")
+	delete(options, "namespaces")
+	if allowed, ok := sinkKindOptions[kind]; ok {
+		for option := range options {
+			if !slices.Contains(allowed, option) {
+				return nil, fmt.Errorf("option %s does not apply to %s sinks", option, kind)
+			}
+		}
+	}
+
+	var err error
+	switch kind {
+	case "stdout":
+		filtered.eventSink = &writerSink{out: os.Stdout}
+	case "file":
+		filtered.eventSink, err = newFileSink(target, options)
+	case "webhook":
+		filtered.eventSink, err = newWebhookSink(target, options)
+	case "exec":
+		filtered.eventSink, err = newExecSink(target, options)
+	default:
+		return nil, fmt.Errorf("unknown sink kind %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return filtered, nil
+}
+
+// splitSinkOptions splits the trailing ;option=value parts off a sink spec.
+// Only parts naming a sink option are split off, so a webhook URL or an exec
+// command may contain ";" itself. The last value of a repeated option wins.
+func splitSinkOptions(spec string) (string, map[string]string) {
+	options := make(map[string]string)
+	for {
+		i := strings.LastIndex(spec, ";")
+		if i < 0 {
+			return spec, options
+		}
+		key, value, ok := strings.Cut(spec[i+1:], "=")
+		if !ok || !sinkOptions[key] {
+			return spec, options
+		}
+		if _, seen := options[key]; !seen {
+			options[key] = value
+		}
+		spec = spec[:i]
+	}
+}
+
+// parseSet splits a comma-separated list into a set
+func parseSet(list string, normalize func(string) string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if normalize != nil {
+			item = normalize(item)
+		}
+		set[item] = true
+	}
+	return set
+}
+
+// intOption returns an integer sink option, or def if it is unset
+func intOption(options map[string]string, key string, def int) (int, error) {
+	value, ok := options[key]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("option %s must be a non-negative integer", key)
+	}
+	return n, nil
+}
+
+// durationOption returns a duration sink option, or def if it is unset
+func durationOption(options map[string]string, key string, def time.Duration) (time.Duration, error) {
+	value, ok := options[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("option %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// writerSink writes one JSON document per event to a writer
+type writerSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (s *writerSink) Send(ctx context.Context, event informerEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.NewEncoder(s.out).Encode(event)
+}
+
+func (s *writerSink) Close() error { return nil }
+
+// fileSink appends events as JSON lines to a file and rotates it by size
+type fileSink struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// newFileSink opens a rotating JSONL file; options: max-size-mb (default 100), max-files (default 3)
+func newFileSink(path string, options map[string]string) (*fileSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file sink requires a path")
+	}
+	maxSize, err := intOption(options, "max-size-mb", 100)
+	if err != nil {
+		return nil, err
+	}
+	maxFiles, err := intOption(options, "max-files", 3)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileSink{path: path, maxBytes: int64(maxSize) << 20, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open sink file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, moves the current file to path.1 and reopens path
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.maxFiles > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxFiles))
+		for i := s.maxFiles - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(s.path, 0); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Send(ctx context.Context, event informerEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate %s: %w", s.path, err)
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// webhookSink POSTs events as JSON, signing the body with HMAC-SHA256 when a secret is set
+type webhookSink struct {
+	url     string
+	secret  []byte
+	retries int
+	backoff time.Duration
+	client  *http.Client
+}
+
+// newWebhookSink creates a webhook sink; options: secret-file, secret-env, retries (default 3), timeout (default 5s)
+func newWebhookSink(url string, options map[string]string) (*webhookSink, error) {
+	if url == "" {
+		return nil, fmt.Errorf("webhook sink requires a URL")
+	}
+	retries, err := intOption(options, "retries", 3)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := durationOption(options, "timeout", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &webhookSink{
+		url:     url,
+		retries: retries,
+		backoff: 500 * time.Millisecond,
+		client:  &http.Client{Timeout: timeout},
+	}
+	if options["secret-file"] != "" && options["secret-env"] != "" {
+		return nil, fmt.Errorf("webhook sink accepts secret-file or secret-env, not both")
+	}
+	if path := options["secret-file"]; path != "" {
+		secret, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook secret: %w", err)
+		}
+		s.secret = bytes.TrimSpace(secret)
+	}
+	if env, ok := options["secret-env"]; ok {
+		secret := os.Getenv(env)
+		if secret == "" {
+			return nil, fmt.Errorf("webhook secret variable %q is empty or unset", env)
+		}
+		s.secret = []byte(secret)
+	}
+	return s, nil
+}
+
+func (s *webhookSink) Send(ctx context.Context, event informerEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := s.post(ctx, event, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= s.retries {
+			return fmt.Errorf("webhook delivery failed after %d attempts: %w", attempt+1, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post performs a single delivery attempt and reports whether a failure is worth retrying
+func (s *webhookSink) post(ctx context.Context, event informerEvent, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", event.Type)
+	if len(s.secret) > 0 {
+		req.Header.Set("X-Signature-256", "sha256="+signPayload(s.secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("server returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("server returned %s", resp.Status)
+	}
+}
+
+func (s *webhookSink) Close() error { return nil }
+
+// signPayload returns the hex-encoded HMAC-SHA256 of body
+func signPayload(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// execSink runs a command per event with the event JSON on stdin
+type execSink struct {
+	command []string
+	timeout time.Duration
+}
+
+// newExecSink creates an exec sink; options: timeout (default 10s)
+func newExecSink(command string, options map[string]string) (*execSink, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("exec sink requires a command")
+	}
+	timeout, err := durationOption(options, "timeout", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &execSink{command: args, timeout: timeout}, nil
+}
+
+func (s *execSink) Send(ctx context.Context, event informerEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Stdin = bytes.NewReader(append(body, '\n'))
+	cmd.Env = append(os.Environ(),
+		"EVENT_TYPE="+event.Type,
+		"EVENT_KIND="+event.Kind,
+		"EVENT_NAMESPACE="+event.Namespace,
+		"EVENT_NAME="+event.Name)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", s.command[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (s *execSink) Close() error { return nil }
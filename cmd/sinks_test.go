@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testEvent(eventType, namespace string) informerEvent {
+	return informerEvent{Type: eventType, Kind: "Deployment", Namespace: namespace, Name: "web", Time: time.Now()}
+}
+
+func TestParseSink_Filters(t *testing.T) {
+	sink, err := parseSink("stdout;types=added,deleted;namespaces=prod")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		event    informerEvent
+		expected bool
+	}{
+		{testEvent(eventAdded, "prod"), true},
+		{testEvent(eventDeleted, "prod"), true},
+		{testEvent(eventUpdated, "prod"), false},
+		{testEvent(eventAdded, "staging"), false},
+	}
+	for _, c := range cases {
+		if got := sink.matches(c.event); got != c.expected {
+			t.Errorf("matches(%s %s) = %t, expected %t", c.event.Type, c.event.Namespace, got, c.expected)
+		}
+	}
+}
+
+func TestParseSink_Errors(t *testing.T) {
+	t.Setenv("EMPTY_HOOK_SECRET", "")
+	for _, spec := range []string{"kafka:broker", "file", "webhook", "exec", "stdout;types", "stdout;colour=red", "file:/tmp/x;max-files=-1",
+		"webhook:https://hooks.example.com;secret-file=/tmp/secret;secret-env=HOOK_SECRET",
+		"webhook:https://hooks.example.com;secret-env=EMPTY_HOOK_SECRET",
+		"stdout;retries=3", "file:/tmp/x;timeout=1s", "exec:cat;max-files=2"} {
+		if _, err := parseSink(spec); err == nil {
+			t.Errorf("Expected error for spec %q", spec)
+		}
+	}
+}
+
+func TestParseSink_TargetWithSemicolon(t *testing.T) {
+	sink, err := parseSink("webhook:https://hooks.example.com/k8s;jsessionid=abc?x=1;timeout=2s;types=deleted")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	webhook := sink.eventSink.(*webhookSink)
+	if webhook.url != "https://hooks.example.com/k8s;jsessionid=abc?x=1" || webhook.client.Timeout != 2*time.Second {
+		t.Errorf("Expected the URL to keep its semicolon, got %q with timeout %s", webhook.url, webhook.client.Timeout)
+	}
+	if !sink.types[eventDeleted] || len(sink.types) != 1 {
+		t.Errorf("Expected the types option to apply, got %v", sink.types)
+	}
+}
+
+func TestDispatchEvent_WriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sinks := []*filteredSink{
+		{name: "buffer", eventSink: &writerSink{out: &buf}, types: map[string]bool{eventDeleted: true}},
+	}
+
+	dispatchEvent(context.TODO(), sinks, testEvent(eventAdded, "default"))
+	dispatchEvent(context.TODO(), sinks, testEvent(eventDeleted, "default"))
+
+	var event informerEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("Expected a single JSON event, got %q: %v", buf.String(), err)
+	}
+	if event.Type != eventDeleted {
+		t.Errorf("Expected only the DELETED event, got %s", event.Type)
+	}
+}
+
+func TestFileSink_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sink, err := newFileSink(path, map[string]string{"max-files": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.maxBytes = 200
+
+	for i := 0; i < 10; i++ {
+		if err := sink.Send(context.TODO(), testEvent(eventAdded, "default")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("Expected rotated file %s: %v", name, err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var event informerEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Errorf("Invalid JSON line in %s: %v", name, err)
+			}
+		}
+		file.Close()
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected at most 2 rotated files")
+	}
+}
+
+func TestWebhookSink_RetriesAndSignature(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("X-Signature-256"); got != "sha256="+signPayload([]byte("s3cret"), body) {
+			t.Errorf("Unexpected signature %q", got)
+		}
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+	sink, err := newWebhookSink(server.URL, map[string]string{"secret-env": "WEBHOOK_SECRET", "retries": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.backoff = time.Millisecond
+
+	if err := sink.Send(context.TODO(), testEvent(eventAdded, "default")); err != nil {
+		t.Fatalf("Expected delivery to succeed after retries: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestWebhookSink_ClientErrorIsNotRetried(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink, err := newWebhookSink(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.backoff = time.Millisecond
+
+	if err := sink.Send(context.TODO(), testEvent(eventAdded, "default")); err == nil {
+		t.Error("Expected an error for a 400 response")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a single attempt, got %d", attempts)
+	}
+}
+
+func TestExecSink_EventOnStdin(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "event.json")
+	sink := &execSink{command: []string{"sh", "-c", `cat > "$OUT"; echo "$EVENT_TYPE" >> "$OUT"`}, timeout: 5 * time.Second}
+	t.Setenv("OUT", out)
+
+	if err := sink.Send(context.TODO(), testEvent(eventUpdated, "default")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var event informerEvent
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil || event.Name != "web" {
+		t.Errorf("Expected event JSON on stdin, got %q", lines[0])
+	}
+	if lines[len(lines)-1] != eventUpdated {
+		t.Errorf("Expected EVENT_TYPE=%s in the environment, got %q", eventUpdated, lines[len(lines)-1])
+	}
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// cacheSnapshotFile is the on-disk format of a deployment cache snapshot.
+// The scope fields make sure a snapshot is only loaded for the same watch.
+type cacheSnapshotFile struct {
+	Time          time.Time            `json:"time"`
+	Namespace     string               `json:"namespace"`
+	LabelSelector string               `json:"labelSelector,omitempty"`
+	FieldSelector string               `json:"fieldSelector,omitempty"`
+	Deployments   []*appsv1.Deployment `json:"deployments"`
+}
+
+// cacheSnapshot is a loaded snapshot, indexed like the live cache
+type cacheSnapshot struct {
+	indexer cache.Indexer
+	time    time.Time
+}
+
+// age returns how old the snapshot data is
+func (s *cacheSnapshot) age() time.Duration {
+	return time.Since(s.time)
+}
+
+// writeSnapshot atomically replaces the snapshot at path with the deployments in store
+func writeSnapshot(path string, store cache.Store, opts *informerOptions, namespace string) error {
+	snapshot := cacheSnapshotFile{
+		Time:          time.Now().UTC(),
+		Namespace:     namespace,
+		LabelSelector: opts.labelSelector,
+		FieldSelector: opts.fieldSelector,
+		Deployments:   []*appsv1.Deployment{},
+	}
+	for _, obj := range store.List() {
+		if d, _, err := deploymentFromEvent(obj); err == nil {
+			snapshot.Deployments = append(snapshot.Deployments, d)
+		}
+	}
+	sort.Slice(snapshot.Deployments, func(i, j int) bool {
+		a, b := snapshot.Deployments[i], snapshot.Deployments[j]
+		return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+	})
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	// Write to a temporary file next to the snapshot and rename it, so a
+	// crash never leaves a truncated snapshot behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadSnapshot reads the snapshot at path into an indexed store. Snapshots of
+// another namespace or selector are rejected.
+func loadSnapshot(path string, opts *informerOptions, namespace string) (*cacheSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot cacheSnapshotFile
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	if snapshot.Namespace != namespace || snapshot.LabelSelector != opts.labelSelector || snapshot.FieldSelector != opts.fieldSelector {
+		return nil, fmt.Errorf("snapshot %s was taken for namespace %q with selectors %q/%q", path,
+			snapshot.Namespace, snapshot.LabelSelector, snapshot.FieldSelector)
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, deploymentIndexers)
+	for _, d := range snapshot.Deployments {
+		if err := indexer.Add(d); err != nil {
+			return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+		}
+	}
+	return &cacheSnapshot{indexer: indexer, time: snapshot.Time}, nil
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deployments.json")
+	opts := informerOptions{labelSelector: "tier=backend"}
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, d := range indexedTestDeployments() {
+		store.Add(d)
+	}
+	if err := writeSnapshot(path, store, &opts, "default"); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) != 0 {
+		t.Errorf("Expected no temporary files to be left, got %v", matches)
+	}
+
+	snapshot, err := loadSnapshot(path, &opts, "default")
+	if err != nil {
+		t.Fatalf("Failed to load snapshot: %v", err)
+	}
+	if got := len(snapshot.indexer.ListKeys()); got != 3 {
+		t.Errorf("Expected 3 deployments, got %d", got)
+	}
+	if snapshot.age() > time.Minute {
+		t.Errorf("Unexpected snapshot age %v", snapshot.age())
+	}
+	// The snapshot is indexed like the live cache
+	matches, err := queryDeployments(snapshot.indexer, []indexFilter{{index: indexByTeam, value: "payments"}})
+	if err != nil || len(matches) == 0 {
+		t.Errorf("Expected indexed lookups on the snapshot, got %v, %v", deploymentNames(matches), err)
+	}
+
+	if _, err := loadSnapshot(path, &opts, "production"); err == nil {
+		t.Error("Expected a snapshot of another namespace to be rejected")
+	}
+	if _, err := loadSnapshot(path, &informerOptions{}, "default"); err == nil {
+		t.Error("Expected a snapshot with other selectors to be rejected")
+	}
+	if _, err := loadSnapshot(filepath.Join(t.TempDir(), "missing.json"), &opts, "default"); !os.IsNotExist(err) {
+		t.Errorf("Expected a not-exist error, got %v", err)
+	}
+}
+
+func TestListDeploymentsHandler_ServesStaleSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deployments.json")
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, d := range indexedTestDeployments() {
+		store.Add(d)
+	}
+	if err := writeSnapshot(path, store, &informerOptions{}, "default"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := loadSnapshot(path, &informerOptions{}, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.time = time.Now().Add(-90 * time.Second)
+
+	// The informer is never started, so it has not synced
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	previousInformer, previousSnapshot := informer, apiSnapshot
+	informer, apiSnapshot = factory.Apps().V1().Deployments().Informer(), snapshot
+	defer func() { informer, apiSnapshot = previousInformer, previousSnapshot }()
+
+	rr := httptest.NewRecorder()
+	listDeploymentsHandler(rr, httptest.NewRequest("GET", "/deployments", nil))
+	var deployments []api.Deployment
+	if err := json.Unmarshal(rr.Body.Bytes(), &deployments); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(deployments) != 3 {
+		t.Fatalf("Expected the 3 snapshot deployments, got %+v", deployments)
+	}
+	for _, d := range deployments {
+		if !d.Stale {
+			t.Errorf("Expected %s to be marked stale", d.Name)
+		}
+	}
+	if age, _ := strconv.Atoi(rr.Header().Get(api.SnapshotAgeHeader)); age < 90 {
+		t.Errorf("Expected a snapshot age of at least 90s, got %q", rr.Header().Get(api.SnapshotAgeHeader))
+	}
+}
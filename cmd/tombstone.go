@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// unwrapTombstone returns the object inside a DeletedFinalStateUnknown tombstone.
+// The informer delivers a tombstone when an object was deleted while the watch
+// was disconnected, so its last known state may be stale.
+func unwrapTombstone(obj interface{}) (interface{}, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj, true
+	}
+	if tombstone, ok := obj.(*cache.DeletedFinalStateUnknown); ok && tombstone != nil {
+		return tombstone.Obj, true
+	}
+	return obj, false
+}
+
+// tombstoneKey returns the namespace/name key of an event object, including tombstones
+func tombstoneKey(obj interface{}) string {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return "<unknown>"
+	}
+	return key
+}
+
+// objectFromEvent extracts the object metadata from an informer event object.
+// finalStateUnknown is true when the object came out of a tombstone.
+func objectFromEvent(obj interface{}) (object metav1.Object, finalStateUnknown bool, err error) {
+	inner, finalStateUnknown := unwrapTombstone(obj)
+	object, ok := inner.(metav1.Object)
+	if !ok {
+		return nil, finalStateUnknown, fmt.Errorf("unexpected object of type %T for key %s", inner, tombstoneKey(obj))
+	}
+	return object, finalStateUnknown, nil
+}
+
+// deploymentFromEvent extracts a deployment from an informer event object.
+// finalStateUnknown is true when the deployment came out of a tombstone.
+func deploymentFromEvent(obj interface{}) (deployment *appsv1.Deployment, finalStateUnknown bool, err error) {
+	inner, finalStateUnknown := unwrapTombstone(obj)
+	deployment, ok := inner.(*appsv1.Deployment)
+	if !ok {
+		return nil, finalStateUnknown, fmt.Errorf("unexpected object of type %T for key %s", inner, tombstoneKey(obj))
+	}
+	return deployment, finalStateUnknown, nil
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+// deleteEvent records what a delete handler observed
+type deleteEvent struct {
+	key               string
+	finalStateUnknown bool
+}
+
+// newFakeWatcherClient returns a fake clientset whose deployment watches are served by fake watchers
+func newFakeWatcherClient(objects ...runtime.Object) (*fake.Clientset, chan *watch.FakeWatcher) {
+	client := fake.NewSimpleClientset(objects...)
+	watchers := make(chan *watch.FakeWatcher, 4)
+	client.PrependWatchReactor("deployments", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		watchers <- w
+		return true, w, nil
+	})
+	return client, watchers
+}
+
+// startDeleteRecorder starts a deployment informer that records delete events
+func startDeleteRecorder(t *testing.T, client *fake.Clientset, stopCh chan struct{}) chan deleteEvent {
+	deletes := make(chan deleteEvent, 4)
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace("default"))
+	deploymentInformer := factory.Apps().V1().Deployments().Informer()
+	deploymentInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			deployment, finalStateUnknown, err := deploymentFromEvent(obj)
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			deletes <- deleteEvent{key: deployment.Namespace + "/" + deployment.Name, finalStateUnknown: finalStateUnknown}
+		},
+	})
+
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, deploymentInformer.HasSynced) {
+		t.Fatal("Failed to sync informer cache")
+	}
+	return deletes
+}
+
+func TestDeploymentFromEvent_Tombstone(t *testing.T) {
+	deployment := newTestDeployment("web", 1, 1)
+
+	got, finalStateUnknown, err := deploymentFromEvent(cache.DeletedFinalStateUnknown{Key: "default/web", Obj: deployment})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != deployment || !finalStateUnknown {
+		t.Errorf("Expected unwrapped deployment with finalStateUnknown, got %v %t", got, finalStateUnknown)
+	}
+
+	_, _, err = deploymentFromEvent(cache.DeletedFinalStateUnknown{Key: "default/web", Obj: &appsv1.ReplicaSet{}})
+	if err == nil {
+		t.Error("Expected an error for a tombstone holding a non-deployment")
+	}
+
+	if _, _, err := deploymentFromEvent("not an object"); err == nil {
+		t.Error("Expected an error for an unknown object type")
+	}
+}
+
+func TestInformerDelete_RegularWatchEvent(t *testing.T) {
+	deployment := newTestDeployment("web", 1, 1)
+	client, watchers := newFakeWatcherClient(deployment)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	deletes := startDeleteRecorder(t, client, stopCh)
+
+	w := <-watchers
+	w.Delete(deployment)
+
+	select {
+	case event := <-deletes:
+		if event.key != "default/web" || event.finalStateUnknown {
+			t.Errorf("Expected regular delete of default/web, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for delete event")
+	}
+}
+
+func TestInformerDelete_TombstoneAfterWatchGap(t *testing.T) {
+	kept := newTestDeployment("api", 1, 1)
+	deleted := newTestDeployment("web", 1, 1)
+	client, watchers := newFakeWatcherClient(kept, deleted)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	deletes := startDeleteRecorder(t, client, stopCh)
+
+	// Delete the object while the watch is disconnected, then expire the watch
+	// so the reflector has to relist and notices the deployment is gone
+	w := <-watchers
+	gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+	if err := client.Tracker().Delete(gvr, "default", "web"); err != nil {
+		t.Fatal(err)
+	}
+	w.Error(&metav1.Status{
+		Status: metav1.StatusFailure,
+		Code:   410,
+		Reason: metav1.StatusReasonExpired,
+	})
+
+	select {
+	case event := <-deletes:
+		if event.key != "default/web" || !event.finalStateUnknown {
+			t.Errorf("Expected tombstone delete of default/web, got %+v", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for tombstone delete event")
+	}
+}
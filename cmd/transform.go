@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// lastAppliedAnnotation holds kubectl's copy of the applied manifest
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// stripMetadata is a cache transform that drops managedFields and the
+// last-applied annotation, which often make up most of a cached object.
+// Transforms may see an object more than once, so it is idempotent.
+func stripMetadata(obj interface{}) (interface{}, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		// Tombstones and foreign objects are cached as they are
+		return obj, nil
+	}
+	accessor.SetManagedFields(nil)
+	if annotations := accessor.GetAnnotations(); annotations[lastAppliedAnnotation] != "" {
+		delete(annotations, lastAppliedAnnotation)
+		accessor.SetAnnotations(annotations)
+	}
+	return obj, nil
+}
+
+// stripDeployment is the cache transform of the api's informer factory. On
+// top of stripMetadata it reduces the pod template of deployments and
+// ReplicaSets to the template labels and the container names and images, and
+// the spec of pods to their containers and node, which is all the api and
+// dashboard read.
+func stripDeployment(obj interface{}) (interface{}, error) {
+	obj, err := stripMetadata(obj)
+	if err != nil {
+		return obj, err
+	}
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		trimTemplate(&o.Spec.Template)
+	case *appsv1.ReplicaSet:
+		trimTemplate(&o.Spec.Template)
+	case *corev1.Pod:
+		o.Spec = corev1.PodSpec{
+			InitContainers: trimContainers(o.Spec.InitContainers),
+			Containers:     trimContainers(o.Spec.Containers),
+			NodeName:       o.Spec.NodeName,
+		}
+	}
+	return obj, nil
+}
+
+// trimTemplate keeps the labels and the container names and images of a pod template
+func trimTemplate(template *corev1.PodTemplateSpec) {
+	template.Annotations = nil
+	template.Spec = corev1.PodSpec{
+		InitContainers: trimContainers(template.Spec.InitContainers),
+		Containers:     trimContainers(template.Spec.Containers),
+	}
+}
+
+// trimContainers keeps only the name and image of each container
+func trimContainers(containers []corev1.Container) []corev1.Container {
+	if len(containers) == 0 {
+		return nil
+	}
+	trimmed := make([]corev1.Container, len(containers))
+	for i, c := range containers {
+		trimmed[i] = corev1.Container{Name: c.Name, Image: c.Image}
+	}
+	return trimmed
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newHeavyDeployment returns a deployment the way the API server returns
+// it: with managedFields, a last-applied annotation and a full pod template
+func newHeavyDeployment(i int) *appsv1.Deployment {
+	d := newTestDeployment(fmt.Sprintf("web-%d", i), 3, 3)
+	d.Annotations = map[string]string{"team": "storefront", revisionAnnotation: "7"}
+
+	var env []corev1.EnvVar
+	for j := 0; j < 10; j++ {
+		env = append(env, corev1.EnvVar{Name: fmt.Sprintf("SETTING_%d", j), Value: fmt.Sprintf("value-%d-%d", i, j)})
+	}
+	probe := &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(8080)}}}
+	container := corev1.Container{
+		Name:           "web",
+		Image:          "nginx:1.25",
+		Command:        []string{"/docker-entrypoint.sh", "nginx", "-g", "daemon off;"},
+		Env:            env,
+		LivenessProbe:  probe,
+		ReadinessProbe: probe,
+		VolumeMounts:   []corev1.VolumeMount{{Name: "config", MountPath: "/etc/nginx/conf.d"}},
+	}
+	sidecar := container
+	sidecar.Name, sidecar.Image = "exporter", "nginx/nginx-prometheus-exporter:0.11"
+	d.Spec.Template.Spec.Containers = []corev1.Container{container, sidecar}
+	d.Spec.Template.Spec.Volumes = []corev1.Volume{{Name: "config", VolumeSource: corev1.VolumeSource{
+		ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "nginx-config"}},
+	}}}
+
+	applied, _ := json.Marshal(d)
+	d.Annotations[lastAppliedAnnotation] = string(applied)
+	for _, manager := range []string{"kubectl-client-side-apply", "kube-controller-manager", "argocd"} {
+		d.ManagedFields = append(d.ManagedFields, metav1.ManagedFieldsEntry{
+			Manager:    manager,
+			Operation:  metav1.ManagedFieldsOperationUpdate,
+			APIVersion: "apps/v1",
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: applied[:len(applied)/2]},
+		})
+	}
+	return d
+}
+
+func TestStripDeployment(t *testing.T) {
+	d := newHeavyDeployment(1)
+	obj, err := stripDeployment(d)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stripped := obj.(*appsv1.Deployment)
+
+	if len(stripped.ManagedFields) != 0 {
+		t.Error("Expected managedFields to be dropped")
+	}
+	if _, ok := stripped.Annotations[lastAppliedAnnotation]; ok {
+		t.Error("Expected the last-applied annotation to be dropped")
+	}
+	if stripped.Annotations["team"] != "storefront" || stripped.Annotations[revisionAnnotation] != "7" {
+		t.Errorf("Expected other annotations to be kept, got %v", stripped.Annotations)
+	}
+	if stripped.Spec.Template.Labels["app"] != "web-1" {
+		t.Error("Expected template labels to be kept")
+	}
+	containers := stripped.Spec.Template.Spec.Containers
+	if len(containers) != 2 || containers[1].Image != "nginx/nginx-prometheus-exporter:0.11" {
+		t.Fatalf("Expected container names and images to be kept, got %+v", containers)
+	}
+	if containers[0].Env != nil || containers[0].LivenessProbe != nil || stripped.Spec.Template.Spec.Volumes != nil {
+		t.Error("Expected env, probes and volumes to be dropped")
+	}
+	if *stripped.Spec.Replicas != 3 || stripped.Status.ReadyReplicas != 3 {
+		t.Error("Expected replicas and status to be kept")
+	}
+
+	// Transforms may run again on a cached object
+	if again, err := stripDeployment(stripped); err != nil || len(again.(*appsv1.Deployment).Spec.Template.Spec.Containers) != 2 {
+		t.Errorf("Expected the transform to be idempotent, got %v", err)
+	}
+
+	tombstone := cache.DeletedFinalStateUnknown{Key: "default/web-1", Obj: d}
+	if obj, err := stripDeployment(tombstone); err != nil || obj != tombstone {
+		t.Errorf("Expected tombstones to pass through, got %v, %v", obj, err)
+	}
+}
+
+func TestStripMetadata_KeepsSpec(t *testing.T) {
+	pod := newTestPod("web-1-a", "web-1", "web-1-uid", corev1.PodRunning)
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubelet"}}
+
+	obj, _ := stripMetadata(pod)
+	stripped := obj.(*corev1.Pod)
+	if len(stripped.ManagedFields) != 0 {
+		t.Error("Expected managedFields to be dropped")
+	}
+	if stripped.Spec.NodeName != "node-1" || len(stripped.Spec.Containers) != 1 {
+		t.Error("Expected the pod spec to be kept")
+	}
+}
+
+func TestMetadataInformerSource(t *testing.T) {
+	scheme := k8sruntime.NewScheme()
+	metav1.AddMetaToScheme(scheme)
+
+	d := newHeavyDeployment(1)
+	d.Labels = map[string]string{"app": "web"}
+	partial := meta.AsPartialObjectMetadata(d)
+	partial.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"}
+	client := metadatafake.NewSimpleMetadataClient(scheme, partial)
+
+	opts := informerOptions{namespaces: []string{"default"}}
+	source := metadataInformerSource{SharedInformerFactory: opts.newMetadataFactories(client)[0], transform: stripMetadata}
+	resource := watchableResources["deployments"].metadataOnly()
+	informer, err := source.informerFor(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source.Start(ctx.Done())
+	if err := source.waitForCacheSync(ctx.Done()); err != nil {
+		t.Fatal(err)
+	}
+
+	items := informer.GetStore().List()
+	if len(items) != 1 {
+		t.Fatalf("Expected one cached object, got %d", len(items))
+	}
+	cached := items[0].(*metav1.PartialObjectMetadata)
+	if len(cached.ManagedFields) != 0 || cached.Annotations[lastAppliedAnnotation] != "" {
+		t.Error("Expected the transform to strip the cached metadata")
+	}
+
+	if got := resource.describe(cached); got != "labels=app=web" {
+		t.Errorf("Unexpected summary %q", got)
+	}
+	relabeled := cached.DeepCopy()
+	relabeled.Labels = map[string]string{"app": "web", "tier": "frontend"}
+	changes := resource.diff(cached, relabeled)
+	if len(changes) != 1 || changes[0].String() != "label.tier: +frontend" {
+		t.Errorf("Unexpected changes %v", changes)
+	}
+}
+
+// cacheBytesPerObject measures the heap retained by a store of n objects
+// built by newObject
+func cacheBytesPerObject(n int, newObject func(i int) interface{}) float64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for i := 0; i < n; i++ {
+		store.Add(newObject(i))
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(store)
+	return float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)) / float64(n)
+}
+
+// BenchmarkDeploymentCacheMemory compares the memory of a full deployment
+// cache with the transformed api cache and a metadata-only cache:
+//
+//	go test ./cmd -run '^$' -bench DeploymentCacheMemory
+func BenchmarkDeploymentCacheMemory(b *testing.B) {
+	const deployments = 1000
+	cases := []struct {
+		name      string
+		newObject func(i int) interface{}
+	}{
+		{"full", func(i int) interface{} { return newHeavyDeployment(i) }},
+		{"transformed", func(i int) interface{} {
+			obj, _ := stripDeployment(newHeavyDeployment(i))
+			return obj
+		}},
+		{"metadata-only", func(i int) interface{} {
+			obj, _ := stripMetadata(meta.AsPartialObjectMetadata(newHeavyDeployment(i)))
+			return obj
+		}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			var total float64
+			for i := 0; i < b.N; i++ {
+				total += cacheBytesPerObject(deployments, c.newObject)
+			}
+			b.ReportMetric(total/float64(b.N), "B/deployment")
+		})
+	}
+}
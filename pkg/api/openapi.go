@@ -0,0 +1,8 @@
+package api
+
+import _ "embed"
+
+// OpenAPISpec is the OpenAPI 3 document of the API, as served at /openapi.json
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
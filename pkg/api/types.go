@@ -0,0 +1,169 @@
+// Package api defines the requests and responses of the k8s-controller JSON
+// API. The server in cmd and the client in pkg/client share these types, and
+// openapi.json describes them for everyone else.
+package api
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Response headers of the deployment list
+const (
+	// TotalCountHeader carries the number of deployments matching a list request before paging
+	TotalCountHeader = "X-Total-Count"
+	// ContinueHeader carries the token of the next page of a list request
+	ContinueHeader = "X-Continue"
+	// SnapshotAgeHeader carries the age in seconds of snapshot data served before the cache synced
+	SnapshotAgeHeader = "X-Snapshot-Age"
+)
+
+// Event types of the watch stream besides ADDED, MODIFIED and DELETED
+const (
+	// EventSynced follows the initial ADDED events of a new stream
+	EventSynced watch.EventType = "SYNCED"
+	// EventReset tells a resuming client that its position is gone and the
+	// initial events follow again
+	EventReset watch.EventType = "RESET"
+	// EventHeartbeat keeps idle WebSocket streams alive
+	EventHeartbeat watch.EventType = "HEARTBEAT"
+)
+
+// Deployment is an entry of the deployment list
+type Deployment struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Replicas  int32  `json:"replicas"`
+	Ready     int32  `json:"ready"`
+	Link      string `json:"link"`
+	Stale     bool   `json:"stale,omitempty"`
+}
+
+// DeploymentDetail is the response of GET /deployments/{namespace}/{name}
+type DeploymentDetail struct {
+	Name        string                `json:"name"`
+	Namespace   string                `json:"namespace"`
+	Created     time.Time             `json:"created"`
+	Labels      map[string]string     `json:"labels,omitempty"`
+	Annotations map[string]string     `json:"annotations,omitempty"`
+	Selector    string                `json:"selector"`
+	Revision    int64                 `json:"revision"`
+	Replicas    ReplicaCounts         `json:"replicas"`
+	Strategy    DeploymentStrategy    `json:"strategy"`
+	Images      []ContainerImage      `json:"images"`
+	Conditions  []DeploymentCondition `json:"conditions"`
+	ReplicaSets []ReplicaSetSummary   `json:"replicaSets"`
+	Pods        []PodSummary          `json:"pods"`
+	Stale       bool                  `json:"stale,omitempty"`
+}
+
+// ReplicaCounts are the desired and observed replicas of a deployment
+type ReplicaCounts struct {
+	Desired     int32 `json:"desired"`
+	Updated     int32 `json:"updated"`
+	Ready       int32 `json:"ready"`
+	Available   int32 `json:"available"`
+	Unavailable int32 `json:"unavailable"`
+}
+
+// DeploymentStrategy summarises how a deployment rolls out
+type DeploymentStrategy struct {
+	Type           string `json:"type"`
+	MaxSurge       string `json:"maxSurge,omitempty"`
+	MaxUnavailable string `json:"maxUnavailable,omitempty"`
+}
+
+// ContainerImage is the image of one container of the pod template
+type ContainerImage struct {
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	Init      bool   `json:"init,omitempty"`
+}
+
+// DeploymentCondition is a status condition of a deployment
+type DeploymentCondition struct {
+	Type           string    `json:"type"`
+	Status         string    `json:"status"`
+	Reason         string    `json:"reason,omitempty"`
+	Message        string    `json:"message,omitempty"`
+	LastTransition time.Time `json:"lastTransition"`
+}
+
+// ReplicaSetSummary is one revision of a deployment
+type ReplicaSetSummary struct {
+	Name     string    `json:"name"`
+	Revision int64     `json:"revision"`
+	Current  bool      `json:"current"`
+	Replicas int32     `json:"replicas"`
+	Ready    int32     `json:"ready"`
+	Images   []string  `json:"images"`
+	Created  time.Time `json:"created"`
+}
+
+// PodSummary is the status of one pod of a deployment
+type PodSummary struct {
+	Name       string    `json:"name"`
+	ReplicaSet string    `json:"replicaSet"`
+	Phase      string    `json:"phase"`
+	Ready      int       `json:"ready"`
+	Containers int       `json:"containers"`
+	Restarts   int32     `json:"restarts"`
+	Node       string    `json:"node,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	Created    time.Time `json:"created"`
+}
+
+// ServiceSummary is a service whose selector matches the pod template of a deployment
+type ServiceSummary struct {
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	ClusterIP string            `json:"clusterIP,omitempty"`
+	Selector  map[string]string `json:"selector"`
+	Ports     []ServicePort     `json:"ports"`
+	Created   time.Time         `json:"created"`
+}
+
+// ServicePort is a port of a service
+type ServicePort struct {
+	Name       string `json:"name,omitempty"`
+	Protocol   string `json:"protocol"`
+	Port       int32  `json:"port"`
+	TargetPort string `json:"targetPort,omitempty"`
+	NodePort   int32  `json:"nodePort,omitempty"`
+}
+
+// EventSummary is an event of a deployment, its ReplicaSets or their pods
+type EventSummary struct {
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+	Message  string    `json:"message"`
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Count    int32     `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// ScaleRequest is the body of PATCH /deployments/{namespace}/{name}/scale
+type ScaleRequest struct {
+	Replicas *int32 `json:"replicas"`
+}
+
+// ImageRequest is the body of PUT /deployments/{namespace}/{name}/image. The
+// container may be left out for deployments with a single container.
+type ImageRequest struct {
+	Container string `json:"container,omitempty"`
+	Image     string `json:"image"`
+}
+
+// WatchEvent is one message of the GET /deployments/watch stream
+type WatchEvent struct {
+	ID         string          `json:"id,omitempty"`
+	Type       watch.EventType `json:"type"`
+	Deployment *Deployment     `json:"deployment,omitempty"`
+}
+
+// Error is the body of every error response
+type Error struct {
+	Error string `json:"error"`
+}
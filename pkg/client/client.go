@@ -0,0 +1,396 @@
+// Package client is a typed Go client of the k8s-controller JSON API.
+//
+// The client is written by hand, not generated from the OpenAPI document in
+// package api. The contract test in cmd calls every deployment operation of
+// the document through this client against the api's handlers, so an
+// operation added to the document without a client method fails the tests.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Client calls the api of one k8s-controller
+type Client struct {
+	baseURL    *url.URL
+	token      string
+	httpClient *http.Client
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithToken authenticates requests with a bearer token
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient sends requests with httpClient, for example one with TLS
+// client certificates or a custom CA
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// New returns a client of the api at baseURL, such as https://controller:8080
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	c := &Client{baseURL: u, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// StatusError is returned for responses outside 2xx
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("api returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Filter selects deployments of a list or watch. Empty fields match everything.
+type Filter struct {
+	Namespace     string
+	Image         string
+	Labels        []string // key=value pairs, all must match
+	Team          string
+	Readiness     string // ready, partial, unavailable or scaled-down
+	LabelSelector string
+	Ready         *bool
+}
+
+func (f Filter) values() url.Values {
+	values := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	set("namespace", f.Namespace)
+	set("image", f.Image)
+	set("team", f.Team)
+	set("readiness", f.Readiness)
+	set("labelSelector", f.LabelSelector)
+	for _, label := range f.Labels {
+		values.Add("label", label)
+	}
+	if f.Ready != nil {
+		values.Set("ready", strconv.FormatBool(*f.Ready))
+	}
+	return values
+}
+
+// ListOptions are the filters, order and page of a list
+type ListOptions struct {
+	Filter
+	Sort     string // name, age or ready, prefixed with - to reverse
+	Limit    int
+	Continue string
+}
+
+// DeploymentList is one page of deployments
+type DeploymentList struct {
+	Items []api.Deployment
+	// Total is the number of matching deployments across all pages
+	Total int
+	// Continue fetches the next page when passed in ListOptions, empty on the last page
+	Continue string
+}
+
+// WriteOptions apply to the requests that change deployments
+type WriteOptions struct {
+	DryRun bool
+	// ResourceVersion makes the write fail with 412 if the deployment changed since
+	ResourceVersion string
+}
+
+// ListDeployments returns a page of the deployments matching opts
+func (c *Client) ListDeployments(ctx context.Context, opts ListOptions) (*DeploymentList, error) {
+	values := opts.values()
+	if opts.Sort != "" {
+		values.Set("sort", opts.Sort)
+	}
+	if opts.Limit > 0 {
+		values.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Continue != "" {
+		values.Set("continue", opts.Continue)
+	}
+
+	list := &DeploymentList{}
+	resp, err := c.do(ctx, http.MethodGet, "/deployments", values, nil, nil, &list.Items)
+	if err != nil {
+		return nil, err
+	}
+	if total := resp.Header.Get(api.TotalCountHeader); total != "" {
+		if list.Total, err = strconv.Atoi(total); err != nil {
+			return nil, fmt.Errorf("invalid %s header %q", api.TotalCountHeader, total)
+		}
+	} else {
+		list.Total = len(list.Items)
+	}
+	list.Continue = resp.Header.Get(api.ContinueHeader)
+	return list, nil
+}
+
+// GetDeployment returns a deployment with its ReplicaSets and pods
+func (c *Client) GetDeployment(ctx context.Context, namespace, name string) (*api.DeploymentDetail, error) {
+	detail := &api.DeploymentDetail{}
+	if _, err := c.do(ctx, http.MethodGet, deploymentPath(namespace, name, ""), nil, nil, nil, detail); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// ListPods returns the pods of all revisions of a deployment
+func (c *Client) ListPods(ctx context.Context, namespace, name string) ([]api.PodSummary, error) {
+	var pods []api.PodSummary
+	_, err := c.do(ctx, http.MethodGet, deploymentPath(namespace, name, "pods"), nil, nil, nil, &pods)
+	return pods, err
+}
+
+// ListReplicaSets returns the revisions of a deployment, newest first
+func (c *Client) ListReplicaSets(ctx context.Context, namespace, name string) ([]api.ReplicaSetSummary, error) {
+	var replicaSets []api.ReplicaSetSummary
+	_, err := c.do(ctx, http.MethodGet, deploymentPath(namespace, name, "replicasets"), nil, nil, nil, &replicaSets)
+	return replicaSets, err
+}
+
+// ListServices returns the services that select the pods of a deployment
+func (c *Client) ListServices(ctx context.Context, namespace, name string) ([]api.ServiceSummary, error) {
+	var services []api.ServiceSummary
+	_, err := c.do(ctx, http.MethodGet, deploymentPath(namespace, name, "services"), nil, nil, nil, &services)
+	return services, err
+}
+
+// ListEvents returns the events of a deployment, its ReplicaSets and their pods, most recent first
+func (c *Client) ListEvents(ctx context.Context, namespace, name string) ([]api.EventSummary, error) {
+	var events []api.EventSummary
+	_, err := c.do(ctx, http.MethodGet, deploymentPath(namespace, name, "events"), nil, nil, nil, &events)
+	return events, err
+}
+
+// CreateDeployment creates deployment, in the namespace of the api if it has none
+func (c *Client) CreateDeployment(ctx context.Context, deployment *appsv1.Deployment, opts WriteOptions) (*api.DeploymentDetail, error) {
+	return c.write(ctx, http.MethodPost, "/deployments", opts, deployment)
+}
+
+// Scale sets the replicas of a deployment
+func (c *Client) Scale(ctx context.Context, namespace, name string, replicas int32, opts WriteOptions) (*api.DeploymentDetail, error) {
+	return c.write(ctx, http.MethodPatch, deploymentPath(namespace, name, "scale"), opts, api.ScaleRequest{Replicas: &replicas})
+}
+
+// Restart restarts the pods of a deployment
+func (c *Client) Restart(ctx context.Context, namespace, name string, opts WriteOptions) (*api.DeploymentDetail, error) {
+	return c.write(ctx, http.MethodPost, deploymentPath(namespace, name, "restart"), opts, nil)
+}
+
+// SetImage sets the image of a container. The container may be empty for
+// deployments with a single container.
+func (c *Client) SetImage(ctx context.Context, namespace, name, container, image string, opts WriteOptions) (*api.DeploymentDetail, error) {
+	return c.write(ctx, http.MethodPut, deploymentPath(namespace, name, "image"), opts, api.ImageRequest{Container: container, Image: image})
+}
+
+// Delete deletes a deployment
+func (c *Client) Delete(ctx context.Context, namespace, name string, opts WriteOptions) error {
+	_, err := c.do(ctx, http.MethodDelete, deploymentPath(namespace, name, ""), opts.values(), opts.header(), nil, nil)
+	return err
+}
+
+func (c *Client) write(ctx context.Context, method, path string, opts WriteOptions, body interface{}) (*api.DeploymentDetail, error) {
+	detail := &api.DeploymentDetail{}
+	if _, err := c.do(ctx, method, path, opts.values(), opts.header(), body, detail); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+func (o WriteOptions) values() url.Values {
+	if !o.DryRun {
+		return nil
+	}
+	return url.Values{"dryRun": {"All"}}
+}
+
+func (o WriteOptions) header() http.Header {
+	if o.ResourceVersion == "" {
+		return nil
+	}
+	return http.Header{"If-Match": {strconv.Quote(o.ResourceVersion)}}
+}
+
+func deploymentPath(namespace, name, action string) string {
+	path := "/deployments/" + url.PathEscape(namespace) + "/" + url.PathEscape(name)
+	if action != "" {
+		path += "/" + action
+	}
+	return path
+}
+
+// do sends a request with an optional JSON body and decodes a JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, values url.Values, header http.Header, body, out interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := c.newRequest(ctx, method, path, values, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range header {
+		req.Header[key] = value
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("failed to decode %s %s response: %w", method, path, err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, values url.Values, body io.Reader) (*http.Request, error) {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = values.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// checkResponse turns a response outside 2xx into a StatusError
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body api.Error
+	if err := json.Unmarshal(data, &body); err != nil || body.Error == "" {
+		body.Error = strings.TrimSpace(string(data))
+		if body.Error == "" {
+			body.Error = http.StatusText(resp.StatusCode)
+		}
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Message: body.Error}
+}
+
+// WatchOptions are the filters of a watch and the event to resume after
+type WatchOptions struct {
+	Filter
+	LastEventID string
+}
+
+// Watcher reads the events of a watch stream
+type Watcher struct {
+	body        io.ReadCloser
+	scanner     *bufio.Scanner
+	lastEventID string
+}
+
+// Watch opens a Server-Sent Events stream of deployment changes. The stream
+// starts with an ADDED event per matching deployment followed by SYNCED,
+// unless it resumes after opts.LastEventID.
+func (c *Client) Watch(ctx context.Context, opts WatchOptions) (*Watcher, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/deployments/watch", opts.values(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if opts.LastEventID != "" {
+		req.Header.Set("Last-Event-ID", opts.LastEventID)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &Watcher{body: resp.Body, scanner: scanner, lastEventID: opts.LastEventID}, nil
+}
+
+// Next blocks until the next event. It returns io.EOF when the server ends
+// the stream, a resumed Watch continues after LastEventID.
+func (w *Watcher) Next() (api.WatchEvent, error) {
+	var data []string
+	for w.scanner.Scan() {
+		line := w.scanner.Text()
+		switch {
+		case line == "":
+			if len(data) == 0 {
+				continue
+			}
+			var event api.WatchEvent
+			if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &event); err != nil {
+				return event, fmt.Errorf("invalid watch event: %w", err)
+			}
+			if event.ID != "" {
+				w.lastEventID = event.ID
+			}
+			return event, nil
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// Comments, id and retry lines carry nothing the event does not
+	}
+	if err := w.scanner.Err(); err != nil {
+		return api.WatchEvent{}, err
+	}
+	return api.WatchEvent{}, io.EOF
+}
+
+// LastEventID is the ID to resume from after the stream ends
+func (w *Watcher) LastEventID() string {
+	return w.lastEventID
+}
+
+// Close ends the stream
+func (w *Watcher) Close() error {
+	return w.body.Close()
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/e1jefe/k8s-controller/pkg/api"
+)
+
+func TestNewRejectsInvalidBaseURL(t *testing.T) {
+	for _, baseURL := range []string{"controller:8080", "ftp://controller", "http://[::1"} {
+		if _, err := New(baseURL); err == nil {
+			t.Errorf("New(%q) succeeded, expected an error", baseURL)
+		}
+	}
+}
+
+func TestRequestEncoding(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set(api.TotalCountHeader, "7")
+		w.Header().Set(api.ContinueHeader, "next")
+		w.Write([]byte(`[{"name":"web","namespace":"default","replicas":2,"ready":2,"link":"/deployments/default/web"}]`))
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL+"/", WithToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := true
+	list, err := c.ListDeployments(context.TODO(), ListOptions{
+		Filter: Filter{Namespace: "default", Labels: []string{"app=web", "tier=front"}, Ready: &ready},
+		Sort:   "-age",
+		Limit:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/deployments" {
+		t.Errorf("path = %s, expected /deployments", got.URL.Path)
+	}
+	if q := got.URL.RawQuery; q != "label=app%3Dweb&label=tier%3Dfront&limit=1&namespace=default&ready=true&sort=-age" {
+		t.Errorf("unexpected query %s", q)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if list.Total != 7 || list.Continue != "next" || len(list.Items) != 1 || list.Items[0].Name != "web" {
+		t.Errorf("unexpected list %+v", list)
+	}
+
+	if err := c.Delete(context.TODO(), "default", "web", WriteOptions{DryRun: true, ResourceVersion: "42"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != http.MethodDelete || got.URL.Path != "/deployments/default/web" || got.URL.RawQuery != "dryRun=All" {
+		t.Errorf("unexpected delete %s %s", got.Method, got.URL)
+	}
+	if match := got.Header.Get("If-Match"); match != `"42"` {
+		t.Errorf("If-Match = %s", match)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deployments/default/web" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"deployment default/web not found"}`))
+			return
+		}
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	defer server.Close()
+	c, _ := New(server.URL)
+
+	_, err := c.GetDeployment(context.TODO(), "default", "web")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound || statusErr.Message != "deployment default/web not found" {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err = c.Restart(context.TODO(), "default", "api", WriteOptions{})
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway || statusErr.Message != "upstream unavailable" {
+		t.Errorf("non-JSON errors should keep the body, got %v", err)
+	}
+}
+
+func TestWatcherParsesEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get("Last-Event-ID"); id != "e-1" {
+			t.Errorf("Last-Event-ID = %q", id)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "retry: 3000\n\n: heartbeat\n\n")
+		io.WriteString(w, "id: e-2\ndata: {\"id\":\"e-2\",\"type\":\"MODIFIED\",\"deployment\":{\"name\":\"web\",\"namespace\":\"default\",\"replicas\":3,\"ready\":1,\"link\":\"/deployments/default/web\"}}\n\n")
+		io.WriteString(w, "data: {\"type\":\"SYNCED\"}\n\n")
+	}))
+	defer server.Close()
+	c, _ := New(server.URL)
+
+	watcher, err := c.Watch(context.TODO(), WatchOptions{LastEventID: "e-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	event, err := watcher.Next()
+	if err != nil || event.Type != "MODIFIED" || event.Deployment == nil || event.Deployment.Replicas != 3 {
+		t.Fatalf("unexpected first event %+v, %v", event, err)
+	}
+	if event, err = watcher.Next(); err != nil || event.Type != api.EventSynced {
+		t.Fatalf("unexpected second event %+v, %v", event, err)
+	}
+	if _, err = watcher.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end of the stream, got %v", err)
+	}
+	if watcher.LastEventID() != "e-2" {
+		t.Errorf("LastEventID = %s, expected e-2", watcher.LastEventID())
+	}
+}